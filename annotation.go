@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// versionAnnotation is the namespace annotation holding the helm version of
+// the namespace.
+const versionAnnotation = "helm-wrapper/version"
+
+// annotationResolver resolves to the version cluster admins set in the
+// helm-wrapper/version annotation of the namespace t acts in, from -n or the
+// kube-context. Without one, it falls back to Tiller detection.
+type annotationResolver struct {
+	dir    string
+	target target
+}
+
+func (r annotationResolver) Resolve(ctx context.Context) (string, error) {
+	clientset, err := newClientset(r.target.kubeContext)
+	if noContext(err) {
+		log.Printf("no current kube-context; skipping namespace annotation detection")
+		return "", errUndetected
+	}
+	if err != nil {
+		return "", err
+	}
+
+	v, ok, err := namespaceVersion(ctx, clientset, r.target)
+	if err != nil {
+		return "", err
+	}
+
+	if !ok {
+		debugf("no %s annotation on the namespace, detecting Tiller", versionAnnotation)
+		return tillerResolver{dir: r.dir, kubeContext: r.target.kubeContext}.Resolve(ctx)
+	}
+
+	return pin(v, r.dir)
+}
+
+// namespaceVersion returns the helm version set in the annotation of the
+// namespace t acts in, and whether there's one. A missing namespace has none.
+func namespaceVersion(ctx context.Context, clientset kubernetes.Interface, t target) (string, bool, error) {
+	namespace := t.namespace
+	if namespace == "" {
+		var err error
+		if namespace, _, err = kubeconfig(t.kubeContext).Namespace(); err != nil {
+			return "", false, err
+		}
+	}
+
+	ns, err := clientset.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	v := strings.TrimSpace(ns.Annotations[versionAnnotation])
+	return v, v != "", nil
+}
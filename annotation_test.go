@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestNamespaceVersion(t *testing.T) {
+	config := `apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: https://kubernetes.example.com
+contexts:
+- name: team-b
+  context:
+    cluster: test
+    namespace: team-b
+- name: no-namespace
+  context:
+    cluster: test
+current-context: team-b
+`
+	file := filepath.Join(tempDir(t), "config")
+	if err := ioutil.WriteFile(file, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	setenv(t, "KUBECONFIG", file)
+
+	unannotated := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "team-a",
+		Annotations: map[string]string{"owner": "team-a"},
+	}}
+
+	tests := []struct {
+		name    string
+		objects []runtime.Object
+		target  target
+		denied  bool
+		want    string
+		wantOK  bool
+		wantErr bool
+	}{
+		{"annotated", []runtime.Object{annotated("team-a", "v3.4.0")}, target{namespace: "team-a"}, false, "v3.4.0", true, false},
+		{"blank", []runtime.Object{annotated("team-a", " \n")}, target{namespace: "team-a"}, false, "", false, false},
+		{"other annotations", []runtime.Object{unannotated}, target{namespace: "team-a"}, false, "", false, false},
+		{"missing namespace", nil, target{namespace: "team-a"}, false, "", false, false},
+		{"forbidden", []runtime.Object{annotated("team-a", "v3.4.0")}, target{namespace: "team-a"}, true, "", false, true},
+		{"context namespace", []runtime.Object{annotated("team-a", "v3.4.0"), annotated("team-b", "v2.16.12")}, target{}, false, "v2.16.12", true, false},
+		{"flag over context namespace", []runtime.Object{annotated("team-a", "v3.4.0"), annotated("team-b", "v2.16.12")}, target{namespace: "team-a"}, false, "v3.4.0", true, false},
+		{"default namespace", []runtime.Object{annotated("default", "v3.3.4")}, target{kubeContext: "no-namespace"}, false, "v3.3.4", true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(tt.objects...)
+			if tt.denied {
+				clientset.PrependReactor("get", "namespaces", func(k8stesting.Action) (bool, runtime.Object, error) {
+					return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: "namespaces"}, "team-a", nil)
+				})
+			}
+
+			got, ok, err := namespaceVersion(context.Background(), clientset, tt.target)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("namespaceVersion() error = %v, wantErr %t", err, tt.wantErr)
+			}
+
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("namespaceVersion() = %q, %t, want %q, %t", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
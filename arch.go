@@ -0,0 +1,90 @@
+package main
+
+import (
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"os"
+)
+
+var elfMachines = map[string]elf.Machine{
+	"386":     elf.EM_386,
+	"amd64":   elf.EM_X86_64,
+	"arm":     elf.EM_ARM,
+	"arm64":   elf.EM_AARCH64,
+	"ppc64le": elf.EM_PPC64,
+	"s390x":   elf.EM_S390,
+}
+
+var machoCpus = map[string]macho.Cpu{
+	"386":   macho.Cpu386,
+	"amd64": macho.CpuAmd64,
+	"arm64": macho.CpuArm64,
+}
+
+var peMachines = map[string]uint16{
+	"386":   pe.IMAGE_FILE_MACHINE_I386,
+	"amd64": pe.IMAGE_FILE_MACHINE_AMD64,
+	"arm64": pe.IMAGE_FILE_MACHINE_ARM64,
+}
+
+// matchesHost reports whether the executable at path was built for the host
+// platform. Files that can't be parsed as the host's executable format don't
+// match, while unknown platforms are assumed to.
+func matchesHost(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	switch host.os {
+	case "linux":
+		want, ok := elfMachines[host.arch]
+		if !ok {
+			return true, nil
+		}
+
+		ef, err := elf.NewFile(f)
+		if err != nil {
+			return false, nil
+		}
+
+		return ef.Machine == want, nil
+	case "darwin":
+		want, ok := machoCpus[host.arch]
+		if !ok {
+			return true, nil
+		}
+
+		if ff, err := macho.NewFatFile(f); err == nil {
+			for _, a := range ff.Arches {
+				if a.Cpu == want {
+					return true, nil
+				}
+			}
+			return false, nil
+		}
+
+		mf, err := macho.NewFile(f)
+		if err != nil {
+			return false, nil
+		}
+
+		return mf.Cpu == want, nil
+	case "windows":
+		want, ok := peMachines[host.arch]
+		if !ok {
+			return true, nil
+		}
+
+		pf, err := pe.NewFile(f)
+		if err != nil {
+			return false, nil
+		}
+
+		return pf.Machine == want, nil
+	}
+
+	return true, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// elfHeader returns a bare 64-bit little endian ELF header for machine.
+func elfHeader(machine elf.Machine) []byte {
+	var buf bytes.Buffer
+	ident := [elf.EI_NIDENT]byte{0x7f, 'E', 'L', 'F', byte(elf.ELFCLASS64), byte(elf.ELFDATA2LSB), byte(elf.EV_CURRENT)}
+	binary.Write(&buf, binary.LittleEndian, elf.Header64{
+		Ident:     ident,
+		Type:      uint16(elf.ET_EXEC),
+		Machine:   uint16(machine),
+		Version:   uint32(elf.EV_CURRENT),
+		Ehsize:    64,
+		Phentsize: 56,
+		Shentsize: 64,
+	})
+
+	return buf.Bytes()
+}
+
+// machoHeader returns a bare 64-bit Mach-O header for cpu.
+func machoHeader(cpu macho.Cpu) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, macho.FileHeader{
+		Magic: macho.Magic64,
+		Cpu:   cpu,
+		Type:  macho.TypeExec,
+	})
+	binary.Write(&buf, binary.LittleEndian, uint32(0))
+
+	return buf.Bytes()
+}
+
+// peHeader returns a bare PE header for machine.
+func peHeader(machine uint16) []byte {
+	b := make([]byte, 0x40)
+	copy(b, "MZ")
+	binary.LittleEndian.PutUint32(b[0x3c:], 0x40)
+
+	buf := bytes.NewBuffer(b)
+	buf.WriteString("PE\x00\x00")
+	binary.Write(buf, binary.LittleEndian, pe.FileHeader{Machine: machine})
+	// pe.NewFile reads on past the file header, so pad it.
+	buf.Write(make([]byte, 64))
+
+	return buf.Bytes()
+}
+
+func TestMatchesHost(t *testing.T) {
+	tests := []struct {
+		name string
+		host platform
+		bin  []byte
+		want bool
+	}{
+		{"linux amd64", platform{"linux", "amd64"}, elfHeader(elf.EM_X86_64), true},
+		{"linux arm64 on amd64", platform{"linux", "amd64"}, elfHeader(elf.EM_AARCH64), false},
+		{"linux arm64", platform{"linux", "arm64"}, elfHeader(elf.EM_AARCH64), true},
+		{"darwin binary on linux", platform{"linux", "amd64"}, machoHeader(macho.CpuAmd64), false},
+		{"script on linux", platform{"linux", "amd64"}, []byte("#!/bin/sh\n"), false},
+		{"unknown linux arch", platform{"linux", "mips64le"}, []byte("#!/bin/sh\n"), true},
+		{"darwin amd64", platform{"darwin", "amd64"}, machoHeader(macho.CpuAmd64), true},
+		{"darwin arm64 on amd64", platform{"darwin", "amd64"}, machoHeader(macho.CpuArm64), false},
+		{"windows amd64", platform{"windows", "amd64"}, peHeader(pe.IMAGE_FILE_MACHINE_AMD64), true},
+		{"windows 386 on amd64", platform{"windows", "amd64"}, peHeader(pe.IMAGE_FILE_MACHINE_I386), false},
+		{"unknown os", platform{"plan9", "amd64"}, []byte("anything"), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forceHost(t, tt.host)
+
+			path := filepath.Join(tempDir(t), "helm")
+			if err := ioutil.WriteFile(path, tt.bin, 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := matchesHost(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tt.want {
+				t.Errorf("matchesHost() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckLocalArch(t *testing.T) {
+	forceHost(t, platform{"linux", "amd64"})
+	setenv(t, "HELM_WRAPPER_VERIFY_ARCH", "true")
+	unsetenv(t, "HELM_WRAPPER_VERIFY_VERSION")
+
+	tests := []struct {
+		name string
+		bin  []byte
+		want bool
+	}{
+		{"native", elfHeader(elf.EM_X86_64), true},
+		{"synced from a mac", machoHeader(macho.CpuArm64), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := tempDir(t)
+			if err := ioutil.WriteFile(binPath(dir, "v2.16.12"), tt.bin, 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := checkLocal("v2.16.12", dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tt.want {
+				t.Errorf("checkLocal() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
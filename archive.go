@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// keepArchives reports whether verified archives are kept in the cache, set
+// with HELM_WRAPPER_KEEP_ARCHIVE, so that a missing binary can be extracted
+// again without downloading it.
+func keepArchives() bool {
+	return envBool("HELM_WRAPPER_KEEP_ARCHIVE")
+}
+
+// keptArchive returns where the helm v archive is kept in dir. The sha256 it
+// was verified with is kept next to it, with a .sha256 suffix.
+func keptArchive(dir, v string) string {
+	return filepath.Join(dir, ".archives", archiveName(v))
+}
+
+// keepArchive copies the downloaded and verified helm v archive into dir.
+func keepArchive(v, dir string) error {
+	sum, err := hashFile(archivePath(v))
+	if err != nil {
+		return err
+	}
+
+	path := keptArchive(dir, v)
+	if err := dirs(filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	if err := copyFile(archivePath(v), path+".part", 0644); err != nil {
+		os.Remove(path + ".part")
+		return err
+	}
+
+	if err := os.Rename(path+".part", path); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path+".sha256", []byte(fmt.Sprintf("%s  %s\n", sum, archiveName(v))), 0644)
+}
+
+// restoreArchive puts the helm v archive kept in dir where it's downloaded to,
+// if there's one and it still has the sha256 it was verified with, and reports
+// whether it did.
+func restoreArchive(v, dir string) bool {
+	if !keepArchives() {
+		return false
+	}
+
+	path := keptArchive(dir, v)
+	b, err := ioutil.ReadFile(path + ".sha256")
+	if err != nil {
+		return false
+	}
+
+	fields := strings.Fields(string(b))
+	sum, err := hashFile(path)
+	if err != nil || len(fields) == 0 || sum != fields[0] {
+		log.Printf("kept archive %s is invalid, downloading helm %s again", path, v)
+		return false
+	}
+
+	if err := copyFile(path, archivePath(v), 0644); err != nil {
+		log.Printf("couldn't restore kept archive %s (%v), downloading helm %s again", path, err, v)
+		return false
+	}
+
+	debugf("extracting helm %s from kept archive %s", v, path)
+	return true
+}
+
+// dropArchive removes the helm v archive kept in dir, if any.
+func dropArchive(dir, v string) {
+	os.Remove(keptArchive(dir, v))
+	os.Remove(keptArchive(dir, v) + ".sha256")
+}
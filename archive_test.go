@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestKeepArchive(t *testing.T) {
+	tests := []struct {
+		name          string
+		keep          bool
+		corrupt       bool
+		wantDownloads int
+	}{
+		{"not kept", false, false, 2},
+		{"kept", true, false, 1},
+		{"kept archive corrupted", true, true, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTempScratch(t)
+			setenv(t, "HELM_WRAPPER_USE_SYSTEM_HELM", "false")
+			setenv(t, "HELM_WRAPPER_KEEP_ARCHIVE", strconv.FormatBool(tt.keep))
+			var downloads int
+			countingMirror(t, "#!/bin/sh\n", &downloads)
+			archive, sum := helmArchive(t, "#!/bin/sh\n")
+
+			dir := tempDir(t)
+			if err := ensure("v2.16.12", dir); err != nil {
+				t.Fatal(err)
+			}
+
+			kept, err := ioutil.ReadFile(keptArchive(dir, "v2.16.12"))
+			if tt.keep != (err == nil) {
+				t.Fatalf("kept archive: %v, want kept %t", err, tt.keep)
+			}
+			if tt.keep {
+				if string(kept) != archive {
+					t.Error("kept a different archive than the one downloaded")
+				}
+				if b, _ := ioutil.ReadFile(keptArchive(dir, "v2.16.12") + ".sha256"); string(b) != sum+"  "+archiveName("v2.16.12")+"\n" {
+					t.Errorf("kept sha256 %q, want %s", b, sum)
+				}
+			}
+
+			if tt.corrupt {
+				if err := ioutil.WriteFile(keptArchive(dir, "v2.16.12"), []byte("corrupt"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := os.Remove(binPath(dir, "v2.16.12")); err != nil {
+				t.Fatal(err)
+			}
+			if err := ensure("v2.16.12", dir); err != nil {
+				t.Fatal(err)
+			}
+
+			if downloads != tt.wantDownloads {
+				t.Errorf("downloaded helm %d times, want %d", downloads, tt.wantDownloads)
+			}
+
+			if b, err := ioutil.ReadFile(binPath(dir, "v2.16.12")); err != nil || string(b) != "#!/bin/sh\n" {
+				t.Errorf("cached helm holds %q, %v, want the archive's binary", b, err)
+			}
+
+			if tt.keep {
+				if b, _ := ioutil.ReadFile(keptArchive(dir, "v2.16.12")); string(b) != archive {
+					t.Error("the kept archive wasn't replaced by a verified one")
+				}
+			}
+		})
+	}
+}
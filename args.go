@@ -0,0 +1,261 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// valueFlags are helm's global flags that take a separate value argument,
+// which must be skipped when looking for the subcommand.
+var valueFlags = map[string]bool{
+	"--burst-limit":               true,
+	"--home":                      true,
+	"--host":                      true,
+	"--kube-apiserver":            true,
+	"--kube-as-group":             true,
+	"--kube-as-user":              true,
+	"--kube-ca-file":              true,
+	"--kube-context":              true,
+	"--kube-tls-server-name":      true,
+	"--kube-token":                true,
+	"--kubeconfig":                true,
+	"--namespace":                 true,
+	"--qps":                       true,
+	"-n":                          true,
+	"--registry-config":           true,
+	"--repository-cache":          true,
+	"--repository-config":         true,
+	"--tiller-connection-timeout": true,
+	"--tiller-namespace":          true,
+}
+
+// noDetect are the helm subcommands that never talk to the cluster.
+var noDetect = map[string]bool{
+	"completion": true,
+	"create":     true,
+	"dependency": true,
+	"env":        true,
+	"help":       true,
+	"home":       true,
+	"lint":       true,
+	"package":    true,
+	"template":   true,
+}
+
+// aliases maps helm subcommand aliases, of either major version, to the
+// command they stand for. Helm 3 renamed delete to uninstall and kept delete
+// as an alias, so both map to uninstall.
+var aliases = map[string]string{
+	"del":          "uninstall",
+	"delete":       "uninstall",
+	"dep":          "dependency",
+	"dependencies": "dependency",
+	"hist":         "history",
+	"ls":           "list",
+	"un":           "uninstall",
+}
+
+// command returns the helm subcommand sub stands for, resolving aliases.
+func command(sub string) string {
+	if c, ok := aliases[sub]; ok {
+		return c
+	}
+
+	return sub
+}
+
+// pinArg splits a leading +<version> override, e.g. `helm +v2.14.3 upgrade`,
+// off args.
+func pinArg(args []string) (string, []string) {
+	if len(args) > 0 && len(args[0]) > 1 && strings.HasPrefix(args[0], "+") {
+		return args[0][1:], args[1:]
+	}
+
+	return "", args
+}
+
+// subcommand returns the helm subcommand in args, skipping global flags.
+func subcommand(args []string) string {
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == "--" {
+			break
+		}
+
+		if !strings.HasPrefix(a, "-") {
+			return a
+		}
+
+		if valueFlags[a] {
+			i++
+		}
+	}
+
+	return ""
+}
+
+// positionals returns the arguments in args that aren't flags, starting with
+// the subcommand. Flags not in valueFlags are assumed to carry their value
+// with "=", so this is a best guess.
+func positionals(args []string) []string {
+	var ps []string
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == "--" {
+			break
+		}
+
+		if valueFlags[a] {
+			i++
+			continue
+		}
+
+		if !strings.HasPrefix(a, "-") {
+			ps = append(ps, a)
+		}
+	}
+
+	return ps
+}
+
+// releaseArg returns the first argument after the subcommand that isn't a
+// flag, which for the likes of upgrade, rollback and status is the release
+// name.
+func releaseArg(args []string) string {
+	if ps := positionals(args); len(ps) > 1 {
+		return ps[1]
+	}
+
+	return ""
+}
+
+// flagValue returns the value of the last occurrence of flag name in args,
+// given as either "name value" or "name=value".
+func flagValue(args []string, name string) string {
+	var v string
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == "--" {
+			break
+		}
+
+		switch {
+		case a == name && i+1 < len(args):
+			v = args[i+1]
+			i++
+		case strings.HasPrefix(a, name+"="):
+			v = strings.TrimPrefix(a, name+"=")
+		}
+	}
+
+	return v
+}
+
+// flagValues returns every value of the repeatable flag name in args.
+func flagValues(args []string, name string) []string {
+	var vs []string
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == "--" {
+			break
+		}
+
+		switch {
+		case a == name && i+1 < len(args):
+			vs = append(vs, args[i+1])
+			i++
+		case strings.HasPrefix(a, name+"="):
+			vs = append(vs, strings.TrimPrefix(a, name+"="))
+		}
+	}
+
+	return vs
+}
+
+// target is the cluster a helm command talks to, as given by its flags, and
+// the release it acts on, if any.
+type target struct {
+	kubeContext string
+	namespace   string
+	release     string
+}
+
+func parseTarget(args []string) target {
+	t := target{
+		kubeContext: flagValue(args, "--kube-context"),
+		namespace:   flagValue(args, "--namespace"),
+		release:     releaseArg(args),
+	}
+
+	// -n is helm 3's shorthand for --namespace, but helm 2 install's for
+	// --name, so for install it's only taken as the namespace in helm 3's
+	// form, with both a release name and a chart or --generate-name.
+	if subcommand(args) == "install" && !helm3Install(args) {
+		t.release = flagValue(args, "--name")
+		if t.release == "" {
+			t.release = flagValue(args, "-n")
+		}
+		return t
+	}
+
+	if t.namespace == "" {
+		t.namespace = flagValue(args, "-n")
+	}
+
+	return t
+}
+
+// helm3Install reports whether the install command in args is in helm 3's
+// form, which names the release with an argument or generates the name,
+// rather than helm 2's with --name.
+func helm3Install(args []string) bool {
+	if flagValue(args, "--name") != "" {
+		return false
+	}
+
+	return len(positionals(args)) > 2 || hasFlag(args, "--generate-name") || hasFlag(args, "-g")
+}
+
+// detect reports whether Tiller detection is needed to run args, which it
+// isn't for --client-only or subcommands in noDetect, or their aliases. It can
+// be forced either way with HELM_WRAPPER_NO_DETECT.
+func detect(args []string) bool {
+	if b, err := strconv.ParseBool(os.Getenv("HELM_WRAPPER_NO_DETECT")); err == nil {
+		return !b
+	}
+
+	// --client-only, as in `helm init --client-only`, and `helm version
+	// --client` ask helm not to talk to the cluster, so neither should the
+	// wrapper.
+	if clientOnly(args) {
+		return false
+	}
+
+	sub := subcommand(args)
+	if sub == "version" && (hasFlag(args, "--client") || hasFlag(args, "-c")) {
+		return false
+	}
+
+	return !noDetect[command(sub)]
+}
+
+// clientOnly reports whether args carry --client-only.
+func clientOnly(args []string) bool {
+	return hasFlag(args, "--client-only")
+}
+
+// hasFlag reports whether the boolean flag name is set in args.
+func hasFlag(args []string, name string) bool {
+	for _, a := range args {
+		if a == "--" {
+			break
+		}
+
+		if a == name || a == name+"=true" {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		args string
+		want target
+	}{
+		{"list", target{}},
+		{"upgrade web stable/nginx --namespace team-a", target{namespace: "team-a", release: "web"}},
+		{"upgrade web stable/nginx --namespace=team-a", target{namespace: "team-a", release: "web"}},
+		{"upgrade -n team-a web stable/nginx", target{namespace: "team-a", release: "web"}},
+		{"--kube-context staging status web -n team-a", target{kubeContext: "staging", namespace: "team-a", release: "web"}},
+		{"install -n team-a web stable/nginx", target{namespace: "team-a", release: "web"}},
+		{"install -n team-a --generate-name stable/nginx", target{namespace: "team-a", release: "stable/nginx"}},
+		{"install stable/nginx -n web", target{release: "web"}},
+		{"install stable/nginx --name web --namespace team-a", target{namespace: "team-a", release: "web"}},
+		{"install stable/nginx --name=web -n web2", target{release: "web"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.args, func(t *testing.T) {
+			if got := parseTarget(strings.Fields(tt.args)); got != tt.want {
+				t.Errorf("parseTarget() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubcommand(t *testing.T) {
+	tests := []struct {
+		args string
+		want string
+	}{
+		{"", ""},
+		{"list", "list"},
+		{"--debug list", "list"},
+		{"--kube-context prod delete web", "delete"},
+		{"--kube-context=prod delete web", "delete"},
+		{"-n team-a --tiller-namespace tiller uninstall web", "uninstall"},
+		{"--debug", ""},
+		{"-- list", ""},
+	}
+
+	for _, tt := range tests {
+		if got := subcommand(strings.Fields(tt.args)); got != tt.want {
+			t.Errorf("subcommand(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestPinArg(t *testing.T) {
+	tests := []struct {
+		args     string
+		wantV    string
+		wantArgs []string
+	}{
+		{"+v2.14.3 upgrade web", "v2.14.3", []string{"upgrade", "web"}},
+		{"+latest-3 list", "latest-3", []string{"list"}},
+		{"+ list", "", []string{"+", "list"}},
+		{"list +v2.14.3", "", []string{"list", "+v2.14.3"}},
+	}
+
+	for _, tt := range tests {
+		v, args := pinArg(strings.Fields(tt.args))
+		if v != tt.wantV || !reflect.DeepEqual(args, tt.wantArgs) {
+			t.Errorf("pinArg(%q) = %q, %q, want %q, %q", tt.args, v, args, tt.wantV, tt.wantArgs)
+		}
+	}
+}
+
+func TestFlagValue(t *testing.T) {
+	args := strings.Fields("--kube-as-group dev --kube-as-user=alice status --kube-as-group=ops -- --kube-as-user bob")
+
+	if got := flagValue(args, "--kube-as-user"); got != "alice" {
+		t.Errorf("flagValue() = %q, want alice", got)
+	}
+
+	if got, want := flagValues(args, "--kube-as-group"), []string{"dev", "ops"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("flagValues() = %q, want %q", got, want)
+	}
+}
+
+func TestResolveNamespacePin(t *testing.T) {
+	dir := tempDir(t)
+	for _, v := range []string{"v2.14.3", "v3.4.0"} {
+		if err := ioutil.WriteFile(binPath(dir, v), []byte("#!/bin/sh\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	old := settings
+	t.Cleanup(func() { settings = old })
+	settings.Version = "v3.4.0"
+	settings.Strategy = "pin"
+	settings.Namespaces = map[string]string{"legacy": "2.14.3"}
+
+	tests := []struct {
+		args string
+		want string
+	}{
+		{"upgrade web stable/nginx --namespace legacy", "v2.14.3"},
+		{"upgrade -n legacy web stable/nginx", "v2.14.3"},
+		{"upgrade -n team-a web stable/nginx", "v3.4.0"},
+		{"install stable/nginx -n legacy", "v3.4.0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.args, func(t *testing.T) {
+			got, _, err := resolveArgs(dir, strings.Fields(tt.args))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tt.want {
+				t.Errorf("resolveArgs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveArgsPin(t *testing.T) {
+	dir := tempDir(t)
+	for _, v := range []string{"v2.14.3", "v2.16.12"} {
+		if err := ioutil.WriteFile(binPath(dir, v), []byte("#!/bin/sh\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	old := settings
+	t.Cleanup(func() { settings = old })
+	settings.Version = "v2.16.12"
+	settings.Strategy = "tiller"
+	settings.Namespaces = map[string]string{"legacy": "v2.16.12"}
+	setenv(t, "HELM_WRAPPER_DETECT_TTL", "0")
+
+	// The cluster runs Tiller v2.16.12, so only a pin gets v2.14.3.
+	fakeCluster(t, tillerPod("kube-system", "tiller-deploy-1", "v2.16.12"))
+
+	tests := []struct {
+		args     string
+		want     string
+		wantArgs []string
+	}{
+		{"+v2.14.3 upgrade web stable/web", "v2.14.3", []string{"upgrade", "web", "stable/web"}},
+		{"+2.14.3 status web", "v2.14.3", []string{"status", "web"}},
+		{"+v2.14.3 upgrade --namespace legacy web stable/web", "v2.14.3", []string{"upgrade", "--namespace", "legacy", "web", "stable/web"}},
+		{"version --client", "v2.16.12", []string{"version", "--client"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.args, func(t *testing.T) {
+			got, args, err := resolveArgs(dir, strings.Fields(tt.args))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tt.want || !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("resolveArgs() = %q, %q, want %q, %q", got, args, tt.want, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		args     string
+		noDetect string
+		want     bool
+	}{
+		{"upgrade web stable/web", "", true},
+		{"status web", "", true},
+		{"template web stable/web", "", false},
+		{"lint ./chart", "", false},
+		{"package ./chart", "", false},
+		{"create web", "", false},
+		{"dependency update ./chart", "", false},
+		{"dep build ./chart", "", false},
+		{"dependencies list ./chart", "", false},
+		{"--kube-context prod template web stable/web", "", false},
+		{"--namespace lint upgrade web stable/web", "", true},
+		{"init --client-only", "", false},
+		{"version --client", "", false},
+		{"version -c", "", false},
+		{"version", "", true},
+		{"upgrade web stable/web", "true", false},
+		{"template web stable/web", "false", true},
+		{"template web stable/web", "maybe", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.args+"/"+tt.noDetect, func(t *testing.T) {
+			setenv(t, "HELM_WRAPPER_NO_DETECT", tt.noDetect)
+
+			if got := detect(strings.Fields(tt.args)); got != tt.want {
+				t.Errorf("detect(%q) = %t, want %t", tt.args, got, tt.want)
+			}
+		})
+	}
+}
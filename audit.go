@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// secretKey matches --set keys whose values shouldn't end up in the audit log.
+var secretKey = regexp.MustCompile(`(?i)(password|passwd|secret|token|credential|apikey|api_key|private)`)
+
+type auditEntry struct {
+	Time        time.Time `json:"time"`
+	Version     string    `json:"version"`
+	KubeContext string    `json:"kubeContext"`
+	Args        []string  `json:"args"`
+	ExitCode    int       `json:"exitCode"`
+}
+
+// audit appends a JSON line describing a helm run to HELM_WRAPPER_AUDIT_LOG,
+// if set. Passwords, tokens and secret looking --set values are redacted
+// unless HELM_WRAPPER_AUDIT_REDACT is false.
+func audit(v string, args []string, code int) error {
+	path := os.Getenv("HELM_WRAPPER_AUDIT_LOG")
+	if path == "" {
+		return nil
+	}
+
+	kubeContext := parseTarget(args).kubeContext
+	if kubeContext == "" {
+		if config, err := kubeconfig("").RawConfig(); err == nil {
+			kubeContext = config.CurrentContext
+		}
+	}
+
+	if s := os.Getenv("HELM_WRAPPER_AUDIT_REDACT"); s == "" || envBool("HELM_WRAPPER_AUDIT_REDACT") {
+		args = redact(args)
+	}
+
+	b, err := json.Marshal(auditEntry{
+		Time:        time.Now().UTC(),
+		Version:     v,
+		KubeContext: kubeContext,
+		Args:        args,
+		ExitCode:    code,
+	})
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// secretFlags are the helm flags whose whole value is a secret, or names one,
+// as --key-file does the key signing charts.
+var secretFlags = []string{"--password", "--kube-token", "--key-file"}
+
+// redact returns a copy of args with the values of secretFlags and of secret
+// looking keys given to --set, --set-string and --set-file replaced.
+func redact(args []string) []string {
+	out := make([]string, len(args))
+	copy(out, args)
+
+	for i := 0; i < len(out); i++ {
+		for _, flag := range []string{"--set", "--set-string", "--set-file"} {
+			switch {
+			case out[i] == flag && i+1 < len(out):
+				out[i+1] = redactValues(out[i+1])
+			case strings.HasPrefix(out[i], flag+"="):
+				out[i] = flag + "=" + redactValues(strings.TrimPrefix(out[i], flag+"="))
+			}
+		}
+
+		for _, flag := range secretFlags {
+			switch {
+			case out[i] == flag && i+1 < len(out):
+				out[i+1] = "REDACTED"
+				i++
+				break
+			case strings.HasPrefix(out[i], flag+"="):
+				out[i] = flag + "=REDACTED"
+			}
+		}
+	}
+
+	return out
+}
+
+// redactValues redacts a --set style list of key=value pairs.
+func redactValues(s string) string {
+	pairs := strings.Split(s, ",")
+	for i, p := range pairs {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) == 2 && secretKey.MatchString(kv[0]) {
+			pairs[i] = kv[0] + "=REDACTED"
+		}
+	}
+
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{"nothing secret", []string{"upgrade", "web", "--set", "image.tag=1.2"}, []string{"upgrade", "web", "--set", "image.tag=1.2"}},
+		{"set", []string{"--set", "db.password=hunter2,replicas=2"}, []string{"--set", "db.password=REDACTED,replicas=2"}},
+		{"set-string with =", []string{"--set-string=apiKey=abc"}, []string{"--set-string=apiKey=REDACTED"}},
+		{"set-file", []string{"--set-file", "tls.privateKey=key.pem"}, []string{"--set-file", "tls.privateKey=REDACTED"}},
+		{"password", []string{"repo", "add", "internal", "https://charts", "--username", "ci", "--password", "hunter2"}, []string{"repo", "add", "internal", "https://charts", "--username", "ci", "--password", "REDACTED"}},
+		{"password with =", []string{"registry", "login", "--password=hunter2", "ghcr.io"}, []string{"registry", "login", "--password=REDACTED", "ghcr.io"}},
+		{"kube-token", []string{"--kube-token", "eyJhbGci", "list"}, []string{"--kube-token", "REDACTED", "list"}},
+		{"kube-token with =", []string{"--kube-token=eyJhbGci", "list"}, []string{"--kube-token=REDACTED", "list"}},
+		{"key-file", []string{"package", "--sign", "--key-file", "secring.gpg", "./chart"}, []string{"package", "--sign", "--key-file", "REDACTED", "./chart"}},
+		{"key-file with =", []string{"package", "--sign", "--key-file=secring.gpg", "./chart"}, []string{"package", "--sign", "--key-file=REDACTED", "./chart"}},
+		{"flag without a value", []string{"list", "--password"}, []string{"list", "--password"}},
+		{"lookalike flag", []string{"--password-stdin", "--kube-tokens=x"}, []string{"--password-stdin", "--kube-tokens=x"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orig := append([]string(nil), tt.args...)
+
+			if got := redact(tt.args); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("redact(%q) = %q, want %q", tt.args, got, tt.want)
+			}
+			if !reflect.DeepEqual(tt.args, orig) {
+				t.Errorf("redact() changed its arguments to %q", tt.args)
+			}
+		})
+	}
+}
+
+func TestAudit(t *testing.T) {
+	tests := []struct {
+		name     string
+		redact   string
+		args     []string
+		wantArgs []string
+		wantCtx  string
+	}{
+		{"redacted", "", []string{"upgrade", "web", "--set", "token=abc"}, []string{"upgrade", "web", "--set", "token=REDACTED"}, "test"},
+		{"not redacted", "false", []string{"upgrade", "web", "--set", "token=abc"}, []string{"upgrade", "web", "--set", "token=abc"}, "test"},
+		{"explicit context", "", []string{"--kube-context", "prod", "list"}, []string{"--kube-context", "prod", "list"}, "prod"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeKubeconfig(t, "")
+			path := filepath.Join(tempDir(t), "audit.log")
+			setenv(t, "HELM_WRAPPER_AUDIT_LOG", path)
+			setenv(t, "HELM_WRAPPER_AUDIT_REDACT", tt.redact)
+
+			start := time.Now().UTC().Add(-time.Second)
+			for _, code := range []int{0, 1} {
+				if err := audit("v3.4.0", tt.args, code); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+			if len(lines) != 2 {
+				t.Fatalf("audit log has %d lines, want one per run:\n%s", len(lines), b)
+			}
+
+			for i, line := range lines {
+				var e auditEntry
+				if err := json.Unmarshal([]byte(line), &e); err != nil {
+					t.Fatalf("audit log line %q isn't JSON: %v", line, err)
+				}
+
+				if e.Version != "v3.4.0" || e.KubeContext != tt.wantCtx || e.ExitCode != i || !reflect.DeepEqual(e.Args, tt.wantArgs) {
+					t.Errorf("audit log line %d = %+v, want v3.4.0 in %s with %q, exit code %d", i, e, tt.wantCtx, tt.wantArgs, i)
+				}
+				if e.Time.Before(start) || e.Time.Location() != time.UTC {
+					t.Errorf("audit log line %d has time %v, want the run's in UTC", i, e.Time)
+				}
+			}
+
+			fi, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi.Mode().Perm() != 0600 {
+				t.Errorf("audit log has mode %v, want -rw-------", fi.Mode().Perm())
+			}
+		})
+	}
+}
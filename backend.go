@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io"
+	"os"
+)
+
+// Cache stores helm binaries by version. The filesystem cache is the only
+// implementation, but another, e.g. backed by an object store shared by
+// ephemeral runners, can take its place in newCache. Running, refetching and
+// hashing helm go through it, while the bookkeeping of the filesystem cache,
+// its manifest, used markers, retention policy and version directories, and
+// the commands maintaining it, fsck, gc and migrate-cache, are its own.
+type Cache interface {
+	// Has reports whether helm v is cached and can be run.
+	Has(v string) (bool, error)
+	// Get returns the local path of helm v, which Has reported cached.
+	Get(v string) string
+	// Put stores the helm v binary read from r.
+	Put(v string, r io.Reader) error
+}
+
+// newCache returns the cache of helm binaries kept in dir.
+var newCache = func(dir string) Cache {
+	return fsCache{dir: dir}
+}
+
+// fsCache is the Cache of helm binaries in a directory, see binPath for its
+// layout.
+type fsCache struct {
+	dir string
+}
+
+func (c fsCache) Has(v string) (bool, error) {
+	return checkLocal(v, c.dir)
+}
+
+func (c fsCache) Get(v string) string {
+	return binPath(c.dir, v)
+}
+
+func (c fsCache) Put(v string, r io.Reader) error {
+	if err := writeBin(r, v, c.dir); err != nil {
+		return err
+	}
+
+	return c.added(v)
+}
+
+// putDir extracts the files of the helm v archive f into a directory of their
+// own, for HELM_WRAPPER_VERSION_DIRS.
+func (c fsCache) putDir(v string, f *os.File) error {
+	if err := extractDir(f, v, c.dir); err != nil {
+		return err
+	}
+
+	return c.added(v)
+}
+
+// added records the newly cached helm v in the manifest, marks it used and
+// applies the retention policy, which never removes it.
+func (c fsCache) added(v string) error {
+	if err := record(c.dir, v); err != nil {
+		return err
+	}
+
+	if err := markUsed(c.dir, v); err != nil {
+		return err
+	}
+
+	return evict(c.dir, v)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// memCache is a Cache holding binaries in memory, written out to dir when
+// they're run.
+type memCache struct {
+	sync.Mutex
+	dir  string
+	bins map[string][]byte
+}
+
+func (c *memCache) Has(v string) (bool, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	_, ok := c.bins[v]
+	return ok, nil
+}
+
+func (c *memCache) Get(v string) string {
+	c.Lock()
+	defer c.Unlock()
+
+	path := filepath.Join(c.dir, "mem-"+v)
+	if b, ok := c.bins[v]; ok {
+		ioutil.WriteFile(path, b, 0755)
+	}
+
+	return path
+}
+
+func (c *memCache) Put(v string, r io.Reader) error {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	c.bins[v] = b
+
+	return nil
+}
+
+// useMemCache makes newCache return a memCache for the rest of t.
+func useMemCache(t *testing.T) *memCache {
+	c := &memCache{dir: tempDir(t), bins: map[string][]byte{}}
+
+	old := newCache
+	t.Cleanup(func() { newCache = old })
+	newCache = func(string) Cache { return c }
+
+	return c
+}
+
+// countingMirror serves the helm v2.16.12 archive and its checksum as the
+// mirror for the rest of t, counting archive downloads in n.
+func countingMirror(t *testing.T, bin string, n *int) {
+	archive, sum := helmArchive(t, bin)
+	files := map[string]string{
+		archiveName("v2.16.12"):             archive,
+		archiveName("v2.16.12") + ".sha256": sum,
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/")
+		body, ok := files[name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if name == archiveName("v2.16.12") {
+			*n++
+		}
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+
+	old := settings
+	t.Cleanup(func() { settings = old })
+	settings.Mirror = srv.URL
+}
+
+func TestMemCache(t *testing.T) {
+	tests := []struct {
+		name        string
+		versionDirs bool
+	}{
+		{"binary", false},
+		{"version dirs", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTempScratch(t)
+			setenv(t, "HELM_WRAPPER_VERSION_DIRS", strconv.FormatBool(tt.versionDirs))
+			mem := useMemCache(t)
+			var downloads int
+			countingMirror(t, "#!/bin/sh\n", &downloads)
+
+			dir := tempDir(t)
+			for i := 0; i < 2; i++ {
+				if err := ensure("v2.16.12", dir); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if downloads != 1 {
+				t.Errorf("downloaded helm %d times, want once", downloads)
+			}
+
+			if got := string(mem.bins["v2.16.12"]); got != "#!/bin/sh\n" {
+				t.Errorf("memCache holds %q, want the archive's binary", got)
+			}
+
+			if got := names(t, dir); len(got) != 0 {
+				t.Errorf("filesystem cache holds %v, want nothing", got)
+			}
+
+			bin := helmBin(dir, "v2.16.12")
+			if b, err := ioutil.ReadFile(bin); err != nil || string(b) != "#!/bin/sh\n" {
+				t.Errorf("helmBin() = %s holding %q, %v, want the cached binary", bin, b, err)
+			}
+
+			if err := sha256Cmd(dir, []string{"v2.16.12"}); err != nil {
+				t.Errorf("sha256Cmd() = %v", err)
+			}
+
+			// A binary vanishing from where Get put it is fetched again
+			// only if the cache lost it too.
+			os.Remove(bin)
+			delete(mem.bins, "v2.16.12")
+			if !refetch(dir, "v2.16.12", &os.PathError{Op: "fork/exec", Path: bin, Err: os.ErrNotExist}) {
+				t.Fatal("refetch() = false, want the binary downloaded again")
+			}
+			if downloads != 2 {
+				t.Errorf("downloaded helm %d times, want twice", downloads)
+			}
+		})
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sizeUnits are the suffixes understood by parseSize.
+var sizeUnits = []struct {
+	suffix string
+	n      int64
+}{
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"KB", 1e3},
+	{"MB", 1e6},
+	{"GB", 1e9},
+	{"K", 1 << 10},
+	{"M", 1 << 20},
+	{"G", 1 << 30},
+	{"B", 1},
+}
+
+// parseSize parses a size like 500MB, 2GiB or 1.5G into bytes.
+func parseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	n := int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s, n = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.n
+			break
+		}
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	return int64(f * float64(n)), nil
+}
+
+// usedPath returns the marker file whose mtime records when helm v was last
+// run. It's kept apart from the binary, since atime isn't reliably updated.
+func usedPath(dir, v string) string {
+	return filepath.Join(dir, fmt.Sprintf(".helm-%s.used", cacheKey(v)))
+}
+
+// markUsed records that helm v is being run.
+func markUsed(dir, v string) error {
+	now := time.Now()
+	err := os.Chtimes(usedPath(dir, v), now, now)
+	if os.IsNotExist(err) {
+		f, err := os.Create(usedPath(dir, v))
+		if err != nil {
+			return err
+		}
+		return f.Close()
+	}
+
+	return err
+}
+
+// lastUsed returns when the cached helm version was last run, falling back to
+// when it was downloaded.
+func lastUsed(dir string, c cachedVersion) time.Time {
+	if fi, err := os.Stat(usedPath(dir, c.Version)); err == nil {
+		return fi.ModTime()
+	}
+
+	return c.ModTime
+}
+
+// retention is a cache retention policy. Each of its limits, when set,
+// removes binaries on its own.
+type retention struct {
+	// keepLatest keeps only the highest that many versions.
+	keepLatest int
+	// maxAge removes versions unused for longer.
+	maxAge time.Duration
+	// maxSize removes the least recently used versions until the cache is
+	// no larger.
+	maxSize int64
+}
+
+// retentionPolicy returns the policy configured with
+// HELM_WRAPPER_KEEP_LATEST, HELM_WRAPPER_MAX_AGE and
+// HELM_WRAPPER_MAX_CACHE_SIZE.
+func retentionPolicy() (retention, error) {
+	var p retention
+	if s := os.Getenv("HELM_WRAPPER_KEEP_LATEST"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return p, fmt.Errorf("invalid HELM_WRAPPER_KEEP_LATEST %q", s)
+		}
+		p.keepLatest = n
+	}
+
+	if s := os.Getenv("HELM_WRAPPER_MAX_AGE"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return p, fmt.Errorf("invalid HELM_WRAPPER_MAX_AGE %q", s)
+		}
+		p.maxAge = d
+	}
+
+	if s := os.Getenv("HELM_WRAPPER_MAX_CACHE_SIZE"); s != "" {
+		n, err := parseSize(s)
+		if err != nil {
+			return p, fmt.Errorf("invalid HELM_WRAPPER_MAX_CACHE_SIZE: %v", err)
+		}
+		p.maxSize = n
+	}
+
+	return p, nil
+}
+
+// evict applies the configured retention policy to dir. Helm keep is never
+// removed.
+func evict(dir, keep string) error {
+	p, err := retentionPolicy()
+	if err != nil {
+		return err
+	}
+
+	removed, err := prune(dir, keep, p, false)
+	for _, c := range removed {
+		log.Printf("evicted helm %s from the cache", c.Version)
+	}
+
+	return err
+}
+
+// prune removes the helm binaries in dir that policy p doesn't retain, except
+// helm keep, and returns them. With dryRun it only returns them.
+func prune(dir, keep string, p retention, dryRun bool) ([]cachedVersion, error) {
+	vs, err := cached(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	drop := map[string]bool{}
+
+	if p.keepLatest > 0 {
+		var tags []string
+		for _, c := range vs {
+			tags = append(tags, c.Version)
+		}
+
+		for i, v := range stable(tags, 0) {
+			if i >= p.keepLatest {
+				drop[v] = true
+			}
+		}
+	}
+
+	if p.maxAge > 0 {
+		for _, c := range vs {
+			if time.Since(lastUsed(dir, c)) > p.maxAge {
+				drop[c.Version] = true
+			}
+		}
+	}
+
+	if p.maxSize > 0 {
+		var total int64
+		for _, c := range vs {
+			if !drop[c.Version] || c.Version == keep {
+				total += c.Size
+			}
+		}
+
+		sort.Slice(vs, func(i, j int) bool {
+			return lastUsed(dir, vs[i]).Before(lastUsed(dir, vs[j]))
+		})
+
+		for _, c := range vs {
+			if total <= p.maxSize {
+				break
+			}
+
+			if drop[c.Version] || c.Version == keep {
+				continue
+			}
+
+			drop[c.Version] = true
+			total -= c.Size
+		}
+	}
+
+	var removed []cachedVersion
+	for _, c := range vs {
+		if !drop[c.Version] || c.Version == keep {
+			continue
+		}
+
+		if dryRun {
+			removed = append(removed, c)
+			continue
+		}
+
+		if err := os.RemoveAll(c.Path); err != nil {
+			return removed, err
+		}
+		os.Remove(usedPath(dir, c.Version))
+		dropArchive(dir, c.Version)
+		if err := forget(dir, c.Version); err != nil {
+			return removed, err
+		}
+
+		removed = append(removed, c)
+	}
+
+	return removed, nil
+}
+
+// gc applies a retention policy to the cache in one pass and lists the
+// versions it removed and the space reclaimed, or with --dry-run those it
+// would remove without removing anything. Flags override the environment, so
+// it can be run from cron with a policy of its own. The client default
+// version is kept.
+func gc(dir string, args []string) error {
+	p, err := retentionPolicy()
+	if err != nil {
+		return err
+	}
+
+	fs := flag.NewFlagSet("gc", flag.ContinueOnError)
+	fs.IntVar(&p.keepLatest, "keep-latest", p.keepLatest, "keep only the highest `n` versions")
+	fs.DurationVar(&p.maxAge, "max-age", p.maxAge, "remove versions unused for longer")
+	maxSize := fs.String("max-size", "", "remove the least recently used versions until the cache is no larger")
+	dryRun := fs.Bool("dry-run", false, "list what would be removed without removing it")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *maxSize != "" {
+		if p.maxSize, err = parseSize(*maxSize); err != nil {
+			return err
+		}
+	}
+
+	if p.keepLatest < 0 || p.maxAge < 0 {
+		return fmt.Errorf("--keep-latest and --max-age can't be negative")
+	}
+
+	keep := ""
+	if _, ok := parseLatest(settings.Version); !ok {
+		keep = canonical(settings.Version)
+	}
+
+	verb, reclaim := "removed", "reclaimed"
+	if *dryRun {
+		verb, reclaim = "would remove", "would reclaim"
+	}
+
+	removed, err := prune(dir, keep, p, *dryRun)
+	var total int64
+	for _, c := range removed {
+		fmt.Printf("%s helm %s (%s) %s\n", verb, c.Version, humanSize(c.Size), c.Path)
+		total += c.Size
+	}
+
+	if len(removed) != 0 {
+		fmt.Printf("%s %s\n", reclaim, humanSize(total))
+	}
+
+	return err
+}
+
+// partialAge is how old a partial download must be before sweep removes it,
+// so that downloads still in progress elsewhere are left alone.
+const partialAge = time.Hour
+
+// isPartial reports whether name is a download or extraction in progress
+// rather than a complete file.
+func isPartial(name string) bool {
+	return strings.HasSuffix(name, ".part") || strings.HasSuffix(name, ".tmp")
+}
+
+// sweep removes partial files left behind in dir by interrupted runs.
+func sweep(dir string) error {
+	return sweepMatching(dir, isPartial)
+}
+
+// sweepArchives removes the helm archives left behind in the scratch directory
+// dir by interrupted downloads. As it's usually shared, only files named like
+// archivePath are touched.
+func sweepArchives(dir string) error {
+	return sweepMatching(dir, func(name string) bool {
+		return strings.HasPrefix(name, "helm-") && strings.HasSuffix(name, ".tmp")
+	})
+}
+
+// sweepMatching removes the files in dir whose names match and that are older
+// than partialAge.
+func sweepMatching(dir string, match func(name string) bool) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if !match(f.Name()) || time.Since(f.ModTime()) < partialAge {
+			continue
+		}
+
+		if err := os.RemoveAll(filepath.Join(dir, f.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
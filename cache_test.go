@@ -0,0 +1,293 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// touch creates the named empty files in dir, aged by age.
+func touch(t *testing.T, dir string, age time.Duration, names ...string) {
+	t.Helper()
+
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		mtime := time.Now().Add(-age)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// names returns the names of the files in dir, sorted.
+func names(t *testing.T, dir string) []string {
+	t.Helper()
+
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ns []string
+	for _, fi := range fis {
+		ns = append(ns, fi.Name())
+	}
+	sort.Strings(ns)
+
+	return ns
+}
+
+// fill caches a 100 byte binary downloaded two hours ago in dir for each
+// version in used, marked as last run that long ago unless that's zero.
+func fill(t *testing.T, dir string, used map[string]time.Duration) {
+	t.Helper()
+
+	for v, age := range used {
+		if err := ioutil.WriteFile(binPath(dir, v), make([]byte, 100), 0755); err != nil {
+			t.Fatal(err)
+		}
+		mtime := time.Now().Add(-2 * time.Hour)
+		if err := os.Chtimes(binPath(dir, v), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+
+		if age != 0 {
+			touch(t, dir, age, filepath.Base(usedPath(dir, v)))
+		}
+	}
+}
+
+// cachedVersions returns the versions cached in dir.
+func cachedVersions(t *testing.T, dir string) []string {
+	t.Helper()
+
+	vs, err := cached(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, c := range vs {
+		got = append(got, c.Version)
+	}
+
+	return got
+}
+
+func TestSweep(t *testing.T) {
+	tests := []struct {
+		name  string
+		sweep func(dir string) error
+		want  []string
+	}{
+		{"cache", sweep, []string{"helm-v2.16.12", "helm-v3.4.0.part", "notes.txt", "other.tmp.old"}},
+		{"scratch", sweepArchives, []string{"helm-v2.16.12", "helm-v2.16.12.part", "helm-v3.4.0.part", "notes.txt", "other.tmp", "other.tmp.old"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := tempDir(t)
+			touch(t, dir, 2*partialAge, "helm-v2.16.12", "helm-v2.16.12.part", "helm-v2.16.12.tmp", "other.tmp", "other.tmp.old", "notes.txt")
+			touch(t, dir, 0, "helm-v3.4.0.part")
+
+			if err := tt.sweep(dir); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := names(t, dir); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("left %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    int64
+		wantErr bool
+	}{
+		{"1024", 1024, false},
+		{"2GB", 2e9, false},
+		{"2GiB", 2 << 30, false},
+		{"500MB", 500e6, false},
+		{"1.5G", 3 << 29, false},
+		{" 10 KiB ", 10 << 10, false},
+		{"12B", 12, false},
+		{"lots", 0, true},
+		{"-1GB", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseSize(tt.s)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseSize(%q) error = %v, wantErr %t", tt.s, err, tt.wantErr)
+			continue
+		}
+
+		if got != tt.want {
+			t.Errorf("parseSize(%q) = %d, want %d", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestEvictMaxSize(t *testing.T) {
+	// Last used, from least recently: v2.14.0, v2.16.0 (only ever
+	// downloaded), v2.15.0, v2.16.12.
+	used := map[string]time.Duration{
+		"v2.14.0":  4 * time.Hour,
+		"v2.15.0":  time.Hour,
+		"v2.16.0":  0,
+		"v2.16.12": time.Minute,
+	}
+
+	tests := []struct {
+		name    string
+		maxSize string
+		keep    string
+		want    []string
+	}{
+		{"unlimited", "", "", []string{"v2.14.0", "v2.15.0", "v2.16.0", "v2.16.12"}},
+		{"fits", "400B", "", []string{"v2.14.0", "v2.15.0", "v2.16.0", "v2.16.12"}},
+		{"least recently used", "300B", "", []string{"v2.15.0", "v2.16.0", "v2.16.12"}},
+		{"by last use over download", "200B", "", []string{"v2.15.0", "v2.16.12"}},
+		{"kept", "250B", "v2.14.0", []string{"v2.14.0", "v2.16.12"}},
+		{"too small for the kept", "10B", "v2.14.0", []string{"v2.14.0"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setenv(t, "HELM_WRAPPER_MAX_CACHE_SIZE", tt.maxSize)
+			unsetenv(t, "HELM_WRAPPER_KEEP_LATEST")
+			unsetenv(t, "HELM_WRAPPER_MAX_AGE")
+
+			dir := tempDir(t)
+			fill(t, dir, used)
+
+			if err := evict(dir, tt.keep); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := cachedVersions(t, dir); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("evict() left %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGC(t *testing.T) {
+	used := map[string]time.Duration{
+		"v2.14.0":  4 * time.Hour,
+		"v2.15.0":  3 * time.Hour,
+		"v2.16.12": 2 * time.Minute,
+		"v3.4.0":   time.Minute,
+	}
+
+	tests := []struct {
+		name    string
+		env     string
+		args    []string
+		want    []string
+		wantOut string
+		wantErr bool
+	}{
+		{"nothing to do", "", nil, []string{"v2.14.0", "v2.15.0", "v2.16.12", "v3.4.0"}, "", false},
+		{"keep latest", "", []string{"--keep-latest", "2"}, []string{"v2.14.0", "v2.16.12", "v3.4.0"}, "removed helm v2.15.0 (100 B)", false},
+		{"combined", "", []string{"--max-age", "2h", "--max-size", "250B"}, []string{"v2.14.0", "v3.4.0"}, "reclaimed 200 B", false},
+		{"from the environment", "1", nil, []string{"v2.14.0", "v3.4.0"}, "removed helm v2.16.12", false},
+		{"flags over the environment", "1", []string{"--keep-latest", "10"}, []string{"v2.14.0", "v2.15.0", "v2.16.12", "v3.4.0"}, "", false},
+		{"dry run", "", []string{"--keep-latest", "1", "--dry-run"}, []string{"v2.14.0", "v2.15.0", "v2.16.12", "v3.4.0"}, "would reclaim 200 B", false},
+		{"negative", "", []string{"--keep-latest", "-1"}, []string{"v2.14.0", "v2.15.0", "v2.16.12", "v3.4.0"}, "", true},
+		{"invalid size", "", []string{"--max-size", "big"}, []string{"v2.14.0", "v2.15.0", "v2.16.12", "v3.4.0"}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setenv(t, "HELM_WRAPPER_KEEP_LATEST", tt.env)
+			unsetenv(t, "HELM_WRAPPER_MAX_AGE")
+			unsetenv(t, "HELM_WRAPPER_MAX_CACHE_SIZE")
+
+			// The client default is never removed.
+			old := settings
+			t.Cleanup(func() { settings = old })
+			settings.Version = "v2.14.0"
+
+			dir := tempDir(t)
+			fill(t, dir, used)
+
+			var err error
+			out := captureStdout(t, func() { err = gc(dir, tt.args) })
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("gc() error = %v, wantErr %t", err, tt.wantErr)
+			}
+
+			if !strings.Contains(out, tt.wantOut) || tt.wantOut == "" && out != "" {
+				t.Errorf("gc() printed %q, want %q", out, tt.wantOut)
+			}
+
+			if got := cachedVersions(t, dir); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("gc() left %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGCDryRun(t *testing.T) {
+	used := map[string]time.Duration{
+		"v2.14.0":  4 * time.Hour,
+		"v2.15.0":  3 * time.Hour,
+		"v2.16.12": 2 * time.Minute,
+		"v3.4.0":   time.Minute,
+	}
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"keep latest", []string{"--keep-latest", "1"}},
+		{"max age", []string{"--max-age", "1h"}},
+		{"max size", []string{"--max-size", "150B"}},
+		{"nothing to do", []string{"--keep-latest", "10"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetenv(t, "HELM_WRAPPER_KEEP_LATEST")
+			unsetenv(t, "HELM_WRAPPER_MAX_AGE")
+			unsetenv(t, "HELM_WRAPPER_MAX_CACHE_SIZE")
+
+			dry, dir := tempDir(t), tempDir(t)
+			fill(t, dry, used)
+			fill(t, dir, used)
+			before := names(t, dry)
+
+			var err error
+			dryOut := captureStdout(t, func() { err = gc(dry, append(tt.args, "--dry-run")) })
+			if err != nil {
+				t.Fatal(err)
+			}
+			realOut := captureStdout(t, func() { err = gc(dir, tt.args) })
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := names(t, dry); !reflect.DeepEqual(got, before) {
+				t.Errorf("gc --dry-run changed the cache from %v to %v", before, got)
+			}
+
+			want := strings.NewReplacer("removed", "would remove", "reclaimed", "would reclaim", dir, dry).Replace(realOut)
+			if dryOut != want {
+				t.Errorf("gc --dry-run printed\n%s\nwant what gc did\n%s", dryOut, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// pinPrefix starts each pin in HELM_WRAPPER_CERT_PIN, in the format used by
+// HPKP and curl's --pinnedpubkey.
+const pinPrefix = "sha256/"
+
+// certPins are base64 sha256 hashes of certificate public keys.
+type certPins map[string]bool
+
+// loadCertPins returns the pins in HELM_WRAPPER_CERT_PIN, a comma separated
+// list of sha256/<base64 hash of the public key> so that a key can be rotated
+// by pinning both for a while. The hash can be had with
+//
+//	openssl x509 -pubkey -noout -in cert.pem | openssl pkey -pubin -outform der |
+//		openssl dgst -sha256 -binary | base64
+func loadCertPins() (certPins, error) {
+	s := os.Getenv("HELM_WRAPPER_CERT_PIN")
+	if s == "" {
+		return nil, nil
+	}
+
+	pins := certPins{}
+	for _, pin := range strings.Split(s, ",") {
+		pin = strings.TrimSpace(pin)
+		b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, pinPrefix))
+		if !strings.HasPrefix(pin, pinPrefix) || err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("invalid HELM_WRAPPER_CERT_PIN %q, expected %s<base64 sha256 of the public key>", pin, pinPrefix)
+		}
+		pins[base64.StdEncoding.EncodeToString(b)] = true
+	}
+
+	return pins, nil
+}
+
+// verify accepts a served certificate chain holding a pinned public key. It's
+// called after the usual verification against the trusted CAs, for every TLS
+// connection to the mirror host, so a mirror redirecting to itself under
+// another name needs that name to be the configured one. Client connections
+// don't resume sessions, so no handshake skips it.
+func (pins certPins) verify(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+
+		sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		if pins[base64.StdEncoding.EncodeToString(sum[:])] {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("no certificate served matches HELM_WRAPPER_CERT_PIN")
+}
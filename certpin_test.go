@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// restoreTransport puts the shared transport back at the end of t.
+func restoreTransport(t *testing.T) {
+	old := transport
+	t.Cleanup(func() { transport = old })
+}
+
+// pinOf returns the HELM_WRAPPER_CERT_PIN entry of the key srv serves.
+func pinOf(srv *httptest.Server) string {
+	sum := sha256.Sum256(srv.Certificate().RawSubjectPublicKeyInfo)
+	return pinPrefix + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func TestCertPin(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("archive"))
+	}))
+	defer srv.Close()
+	trust(t, srv)
+
+	old := settings
+	defer func() { settings = old }()
+	settings.Mirror = srv.URL
+
+	other := pinPrefix + base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+
+	tests := []struct {
+		name    string
+		pins    string
+		wantErr bool
+	}{
+		{"unpinned", "", false},
+		{"pinned", pinOf(srv), false},
+		{"rotating", other + "," + pinOf(srv), false},
+		{"mismatched", other, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			restoreTransport(t)
+			setenv(t, "HELM_WRAPPER_CERT_PIN", tt.pins)
+			if err := setupTransport(); err != nil {
+				t.Fatal(err)
+			}
+
+			resp, err := newClient(0).Get(srv.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Get() error = %v, wantErr %t", err, tt.wantErr)
+			}
+
+			if err != nil && !strings.Contains(err.Error(), "HELM_WRAPPER_CERT_PIN") {
+				t.Errorf("Get() error = %v, want it to name HELM_WRAPPER_CERT_PIN", err)
+			}
+		})
+	}
+}
+
+func TestCertPinMirrorOnly(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	mirror := httptest.NewTLSServer(handler)
+	defer mirror.Close()
+	// api.github.com, for releases and self-updates.
+	github := httptest.NewTLSServer(handler)
+	defer github.Close()
+	trust(t, mirror)
+
+	restoreTransport(t)
+	old := settings
+	defer func() { settings = old }()
+	settings.Mirror = mirror.URL
+	setenv(t, "HELM_WRAPPER_CERT_PIN", pinPrefix+base64.StdEncoding.EncodeToString(make([]byte, sha256.Size)))
+	if err := setupTransport(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		url     string
+		wantErr bool
+	}{
+		{"mirror", mirror.URL, true},
+		{"other host", github.URL, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := newClient(0).Get(tt.url)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Get() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadCertPins(t *testing.T) {
+	valid := pinPrefix + base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+
+	tests := []struct {
+		env     string
+		want    int
+		wantErr bool
+	}{
+		{"", 0, false},
+		{valid, 1, false},
+		{valid + ", " + valid, 1, false},
+		{strings.TrimPrefix(valid, pinPrefix), 0, true},
+		{pinPrefix + "c2hvcnQ=", 0, true},
+		{"sha256/not base64", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.env, func(t *testing.T) {
+			setenv(t, "HELM_WRAPPER_CERT_PIN", tt.env)
+
+			pins, err := loadCertPins()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadCertPins() error = %v, wantErr %t", err, tt.wantErr)
+			}
+
+			if len(pins) != tt.want {
+				t.Errorf("loadCertPins() = %d pins, want %d", len(pins), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// fetchChecksum returns the hex sha256 from a sha256sum style file at url.
+func fetchChecksum(c *http.Client, url string) (string, error) {
+	resp, err := c.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("couldn't download checksum %s: %q", url, resp.Status)
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<10))
+	if err != nil {
+		return "", err
+	}
+	// Drain the rest so the connection can be reused.
+	io.Copy(ioutil.Discard, resp.Body)
+
+	fields := strings.Fields(string(b))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("empty checksum %s", url)
+	}
+
+	return strings.ToLower(fields[0]), nil
+}
+
+// manifests caches the checksum manifests fetched by version, as prefetch
+// verifies several archives of the same release.
+var manifests = struct {
+	sync.Mutex
+	byVersion map[string]map[string]string
+}{byVersion: map[string]map[string]string{}}
+
+// publishedChecksum returns the checksum of the release file name of helm v.
+// It's taken from the local index if that has it, then from the checksum
+// manifest of the release if HELM_WRAPPER_CHECKSUM_MANIFEST names one, with %s
+// standing for the version, e.g. helm-%s-checksums.txt, and otherwise from the
+// .sha256 file next to it.
+func publishedChecksum(c *http.Client, v, name string) (string, error) {
+	idx, err := loadIndex()
+	if err != nil {
+		return "", err
+	}
+
+	if idx != nil {
+		if sum, ok := idx.checksum(name); ok {
+			return strings.ToLower(sum), nil
+		}
+	}
+
+	pattern := os.Getenv("HELM_WRAPPER_CHECKSUM_MANIFEST")
+	if pattern == "" {
+		return fetchChecksum(c, mirrorURL(name)+".sha256")
+	}
+
+	manifests.Lock()
+	defer manifests.Unlock()
+
+	sums, ok := manifests.byVersion[v]
+	if !ok {
+		url := mirrorURL(fmt.Sprintf(pattern, v))
+		resp, err := c.Get(url)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("couldn't download checksum manifest %s: %q", url, resp.Status)
+		}
+
+		if sums, err = parseManifest(io.LimitReader(resp.Body, 1<<20)); err != nil {
+			return "", fmt.Errorf("invalid checksum manifest %s: %v", url, err)
+		}
+		manifests.byVersion[v] = sums
+	}
+
+	sum, ok := sums[name]
+	if !ok {
+		return "", fmt.Errorf("no checksum for %s in the helm %s checksum manifest", name, v)
+	}
+
+	return sum, nil
+}
+
+// parseManifest maps file names to their hex sha256 in a sha256sum style
+// manifest, with lines like "<sha256>  <name>" or "<sha256> *<name>".
+func parseManifest(r io.Reader) (map[string]string, error) {
+	sums := map[string]string{}
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 2 || len(fields[0]) != sha256.Size*2 {
+			return nil, fmt.Errorf("line %d: expected <sha256> <name>", line)
+		}
+
+		sums[path.Base(strings.TrimPrefix(fields[1], "*"))] = strings.ToLower(fields[0])
+	}
+
+	return sums, s.Err()
+}
+
+// hashFile returns the hex sha256 of the file at path.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	return hashReader(f)
+}
+
+func hashReader(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := copyBuffered(h, r); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// verifyArchive checks got, the sha256 the helm v archive was downloaded with,
+// against the checksum published next to it and, if there's one, the checksum
+// pinned for it in the config file. A published checksum disagreeing with the
+// pinned one fails, while one that can't be fetched is only a warning when
+// there's a pin.
+func verifyArchive(c *http.Client, v, got string) error {
+	pinned, ok := settings.Checksums[archiveName(v)]
+	if !ok {
+		return verifySum(c, v, archiveName(v), got)
+	}
+	pinned = strings.ToLower(pinned)
+
+	upstream, err := publishedChecksum(c, v, archiveName(v))
+	switch {
+	case err != nil:
+		log.Printf("couldn't fetch the published checksum of helm %s (%v), using the pinned one", v, err)
+	case upstream != pinned:
+		return fmt.Errorf("published checksum %s of %s disagrees with the pinned %s", upstream, archiveName(v), pinned)
+	}
+
+	if got != pinned {
+		return fmt.Errorf("checksum mismatch for %s: got %s, want %s", archiveName(v), got, pinned)
+	}
+
+	return nil
+}
+
+// verifySum checks got, the sha256 the release file name of helm v was
+// downloaded with, against its published checksum. Downloads fail when it
+// can't be fetched, unless HELM_WRAPPER_REQUIRE_CHECKSUM is false, for mirrors
+// that don't publish checksums, which makes that a warning.
+func verifySum(c *http.Client, v, name, got string) error {
+	want, err := publishedChecksum(c, v, name)
+	if err != nil && !requireChecksum() {
+		log.Printf("couldn't fetch the published checksum of %s (%v), not verifying it", name, err)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%v; set HELM_WRAPPER_REQUIRE_CHECKSUM=false if the mirror doesn't publish checksums", err)
+	}
+
+	if got != want {
+		return fmt.Errorf("checksum mismatch for %s: got %s, want %s", name, got, want)
+	}
+
+	return nil
+}
+
+// requireChecksum reports whether downloads need a published checksum, which
+// is unless HELM_WRAPPER_REQUIRE_CHECKSUM is false.
+func requireChecksum() bool {
+	b, err := strconv.ParseBool(os.Getenv("HELM_WRAPPER_REQUIRE_CHECKSUM"))
+	return err != nil || b
+}
+
+// verify checks the helm binary the wrapper runs, cached or the system helm,
+// against the one in its upstream archive, which is downloaded again and
+// verified against its published checksum. The version defaults to the one
+// the wrapper would run.
+func verify(dir string, args []string) error {
+	var v string
+	if len(args) > 0 {
+		v = canonical(args[0])
+	} else {
+		var err error
+		if v, _, err = resolveArgs(dir, nil); err != nil {
+			return err
+		}
+	}
+
+	bin := helmBin(dir, v)
+	want, err := hashFile(bin)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("helm %s isn't cached", v)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := useScratch(dir); err != nil {
+		return err
+	}
+
+	// Checking against an unverified download proves nothing.
+	os.Setenv("HELM_WRAPPER_REQUIRE_CHECKSUM", "true")
+	if err := download(v); err != nil {
+		return err
+	}
+	defer os.Remove(archivePath(v))
+
+	f, err := os.Open(archivePath(v))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var got string
+	err = extractBin(f, func(r io.Reader) error {
+		got, err = hashReader(r)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if got != want {
+		return fmt.Errorf("helm %s: MISMATCH, %s has sha256 %s, upstream has %s", v, bin, want, got)
+	}
+
+	fmt.Printf("helm %s: OK\n", v)
+	return nil
+}
+
+// sha256Cmd prints the sha256 of the helm binary of the given version the
+// wrapper runs, cached or the system helm, defaulting to the version it would
+// run, in sha256sum format, e.g. to record which binary a deployment used.
+func sha256Cmd(dir string, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: helm-wrapper sha256 [version]")
+	}
+
+	var v string
+	if len(args) == 1 {
+		v = canonical(args[0])
+	} else {
+		var err error
+		if v, _, err = resolveArgs(dir, nil); err != nil {
+			return err
+		}
+	}
+
+	bin := helmBin(dir, v)
+	sum, err := hashFile(bin)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("helm %s isn't cached", v)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s  %s\n", sum, bin)
+	return nil
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	sumA = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	sumB = "fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"
+)
+
+// mirrorServer serves files by name as the mirror for the rest of t.
+func mirrorServer(t *testing.T, files map[string]string) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := files[strings.TrimPrefix(r.URL.Path, "/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+
+	old := settings
+	t.Cleanup(func() { settings = old })
+	settings.Mirror = srv.URL
+
+	return srv
+}
+
+func TestVerifySum(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		require string
+		got     string
+		wantErr bool
+	}{
+		{"match", map[string]string{"helm.tar.gz.sha256": sumA + "  helm.tar.gz\n"}, "", sumA, false},
+		{"upper case", map[string]string{"helm.tar.gz.sha256": strings.ToUpper(sumA)}, "", sumA, false},
+		{"mismatch", map[string]string{"helm.tar.gz.sha256": sumB}, "", sumA, true},
+		{"mismatch not required", map[string]string{"helm.tar.gz.sha256": sumB}, "false", sumA, true},
+		{"missing", nil, "", sumA, true},
+		{"missing required", nil, "true", sumA, true},
+		{"missing not required", nil, "false", sumA, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetIndex(t)
+			srv := mirrorServer(t, tt.files)
+			setenv(t, "HELM_WRAPPER_REQUIRE_CHECKSUM", tt.require)
+
+			err := verifySum(srv.Client(), "v3.4.0", "helm.tar.gz", tt.got)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifySum() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyArchive(t *testing.T) {
+	name := archiveName("v3.4.0")
+
+	tests := []struct {
+		name      string
+		published string
+		pinned    string
+		got       string
+		wantErr   bool
+	}{
+		{"pin matches", sumA, sumA, sumA, false},
+		{"upper case pin", sumA, strings.ToUpper(sumA), sumA, false},
+		{"pin mismatches archive", sumA, sumA, sumB, true},
+		{"pin disagrees with published", sumB, sumA, sumA, true},
+		{"published missing", "", sumA, sumA, false},
+		{"published missing mismatch", "", sumA, sumB, true},
+		{"unpinned", sumA, "", sumA, false},
+		{"unpinned mismatch", sumA, "", sumB, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetIndex(t)
+			files := map[string]string{}
+			if tt.published != "" {
+				files[name+".sha256"] = tt.published + "  " + name + "\n"
+			}
+			srv := mirrorServer(t, files)
+			setenv(t, "HELM_WRAPPER_REQUIRE_CHECKSUM", "")
+			settings.Checksums = nil
+			if tt.pinned != "" {
+				settings.Checksums = map[string]string{name: tt.pinned}
+			}
+
+			err := verifyArchive(srv.Client(), "v3.4.0", tt.got)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyArchive() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseManifest(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "sha256sum",
+			in:   "# helm v3.4.0\n" + sumA + "  helm-v3.4.0-linux-amd64.tar.gz\n\n" + strings.ToUpper(sumB) + " *dist/helm-v3.4.0-darwin-amd64.tar.gz\n",
+			want: map[string]string{
+				"helm-v3.4.0-linux-amd64.tar.gz":  sumA,
+				"helm-v3.4.0-darwin-amd64.tar.gz": sumB,
+			},
+		},
+		{name: "short sum", in: "abc helm.tar.gz\n", wantErr: true},
+		{name: "no name", in: sumA + "\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseManifest(strings.NewReader(tt.in))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseManifest() error = %v, wantErr %t", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseManifest() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPublishedChecksumManifest(t *testing.T) {
+	resetIndex(t)
+	srv := mirrorServer(t, map[string]string{
+		"helm-v3.4.0-checksums.txt": sumA + "  helm-v3.4.0-linux-amd64.tar.gz\n",
+	})
+	setenv(t, "HELM_WRAPPER_CHECKSUM_MANIFEST", "helm-%s-checksums.txt")
+
+	got, err := publishedChecksum(srv.Client(), "v3.4.0", "helm-v3.4.0-linux-amd64.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got != sumA {
+		t.Errorf("publishedChecksum() = %q, want %q", got, sumA)
+	}
+
+	if _, err := publishedChecksum(srv.Client(), "v3.4.0", "helm-v3.4.0-windows-amd64.zip"); err == nil {
+		t.Error("publishedChecksum() of a file missing from the manifest succeeded")
+	}
+}
+
+func TestVerifyAssetsAgainstManifest(t *testing.T) {
+	resetIndex(t)
+	manifests.byVersion = map[string]map[string]string{}
+	t.Cleanup(func() { manifests.byVersion = map[string]map[string]string{} })
+	setenv(t, "HELM_WRAPPER_CHECKSUM_MANIFEST", "helm-%s-checksums.txt")
+	unsetenv(t, "HELM_WRAPPER_REQUIRE_CHECKSUM")
+
+	var fetches int
+	manifest := sumA + "  helm-v3.4.0-linux-amd64.tar.gz\n" +
+		sumB + "  helm-v3.4.0-darwin-arm64.tar.gz\n" +
+		sumA + "  helm-v3.4.0-windows-amd64.zip\n"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/helm-v3.4.0-checksums.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		fetches++
+		w.Write([]byte(manifest))
+	}))
+	t.Cleanup(srv.Close)
+
+	old := settings
+	t.Cleanup(func() { settings = old })
+	settings.Mirror = srv.URL
+
+	tests := []struct {
+		name    string
+		got     string
+		wantErr bool
+	}{
+		{"helm-v3.4.0-linux-amd64.tar.gz", sumA, false},
+		{"helm-v3.4.0-darwin-arm64.tar.gz", sumB, false},
+		{"helm-v3.4.0-windows-amd64.zip", sumB, true},
+		{"helm-v3.4.0-linux-s390x.tar.gz", sumA, true},
+	}
+
+	for _, tt := range tests {
+		err := verifySum(srv.Client(), "v3.4.0", tt.name, tt.got)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("verifySum() of %s error = %v, wantErr %t", tt.name, err, tt.wantErr)
+		}
+	}
+
+	if fetches != 1 {
+		t.Errorf("checksum manifest fetched %d times, want once for all assets", fetches)
+	}
+}
+
+func TestSha256Cmd(t *testing.T) {
+	setenv(t, "HELM_WRAPPER_USE_SYSTEM_HELM", "false")
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    string
+		wantErr bool
+	}{
+		{"version", []string{"v2.16.12"}, "v2.16.12", false},
+		{"without the v", []string{"2.16.12"}, "v2.16.12", false},
+		{"default", nil, "v3.4.0", false},
+		{"uncached", []string{"v2.14.3"}, "", true},
+		{"usage", []string{"v2.16.12", "v3.4.0"}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := settings
+			t.Cleanup(func() { settings = old })
+			settings.Strategy = "pin"
+			settings.Version = "v3.4.0"
+
+			dir := tempDir(t)
+			for _, v := range []string{"v2.16.12", "v3.4.0"} {
+				if err := ioutil.WriteFile(binPath(dir, v), []byte("helm "+v), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var err error
+			out := captureStdout(t, func() { err = sha256Cmd(dir, tt.args) })
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("sha256Cmd() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			sum := sha256.Sum256([]byte("helm " + tt.want))
+			if want := hex.EncodeToString(sum[:]) + "  " + binPath(dir, tt.want) + "\n"; out != want {
+				t.Errorf("sha256Cmd() printed %q, want %q", out, want)
+			}
+		})
+	}
+}
+
+func TestDownloadVerifiesStreamedSum(t *testing.T) {
+	archive, sum := helmArchive(t, "#!/bin/sh\n")
+
+	tests := []struct {
+		name      string
+		published string
+		wantErr   bool
+	}{
+		{"matching", sum, false},
+		{"matching sha256sum line", sum + "  " + archiveName("v2.16.12") + "\n", false},
+		{"mismatched", sumA, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetIndex(t)
+			useTempScratch(t)
+			unsetenv(t, "HELM_WRAPPER_CHECKSUM_MANIFEST")
+			srv := mirrorServer(t, map[string]string{
+				archiveName("v2.16.12"):             archive,
+				archiveName("v2.16.12") + ".sha256": tt.published,
+			})
+
+			// The sum fetch computes on the way is the one of what it wrote.
+			path := filepath.Join(tempDir(t), "helm.tar.gz")
+			_, streamed, err := fetch(srv.Client(), srv.URL+"/"+archiveName("v2.16.12"), path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if written, err := hashFile(path); err != nil || streamed != written || streamed != sum {
+				t.Errorf("fetch() hashed %s, wrote %s (%v), want %s", streamed, written, err, sum)
+			}
+
+			err = download("v2.16.12")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("download() error = %v, wantErr %t", err, tt.wantErr)
+			}
+
+			_, statErr := os.Stat(archivePath("v2.16.12"))
+			if tt.wantErr != os.IsNotExist(statErr) {
+				t.Errorf("archive left after download() error %v: %v", err, statErr)
+			}
+		})
+	}
+}
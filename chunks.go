@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// downloadChunks returns how many parallel range requests archives are
+// downloaded with, set with HELM_WRAPPER_DOWNLOAD_CHUNKS, e.g. for high-latency
+// links. It's 1, a single stream, by default and with
+// HELM_WRAPPER_DOWNLOAD_RATE_LIMIT, which caps the throughput chunks are for.
+func downloadChunks() (int, error) {
+	n, err := envInt("HELM_WRAPPER_DOWNLOAD_CHUNKS", 1)
+	if err != nil || os.Getenv("HELM_WRAPPER_DOWNLOAD_RATE_LIMIT") != "" {
+		return 1, err
+	}
+
+	return n, nil
+}
+
+// fetchArchive is fetch, in downloadChunks parallel range requests where the
+// server supports them.
+func fetchArchive(c *http.Client, url, path string) (int64, string, error) {
+	chunks, err := downloadChunks()
+	if err != nil {
+		return 0, "", err
+	}
+
+	if chunks == 1 {
+		return fetch(c, url, path)
+	}
+
+	resp, err := c.Head(url)
+	if err != nil {
+		return 0, "", err
+	}
+	resp.Body.Close()
+
+	size := resp.ContentLength
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Accept-Ranges") != "bytes" || size < int64(chunks) {
+		debugf("%s can't be downloaded in ranges, using a single stream", url)
+		return fetch(c, url, path)
+	}
+
+	if err := fetchRanges(c, url, path, size, chunks); err != nil {
+		return 0, "", err
+	}
+
+	// The parts arrive out of order, so the file is hashed once assembled.
+	sum, err := hashFile(path)
+	return size, sum, err
+}
+
+// fetchRanges downloads the size bytes of url to path in chunks parallel
+// range requests, each writing its part of the file in place.
+func fetchRanges(c *http.Client, url, path string, size int64, chunks int) error {
+	if err := checkSpace(filepath.Dir(path), size); err != nil {
+		return err
+	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if err := out.Truncate(size); err != nil {
+		return err
+	}
+
+	part := size / int64(chunks)
+	errs := make([]error, chunks)
+	var wg sync.WaitGroup
+	for i := 0; i < chunks; i++ {
+		start, end := int64(i)*part, int64(i+1)*part-1
+		if i == chunks-1 {
+			end = size - 1
+		}
+
+		wg.Add(1)
+		go func(i int, start, end int64) {
+			defer wg.Done()
+			errs[i] = fetchRange(c, url, out, start, end)
+		}(i, start, end)
+	}
+	wg.Wait()
+
+	var failed []string
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+
+	if len(failed) != 0 {
+		return fmt.Errorf("couldn't download %s: %s", url, strings.Join(failed, "; "))
+	}
+
+	return out.Close()
+}
+
+// fetchRange downloads bytes start to end, inclusive, of url into out at the
+// same offset.
+func fetchRange(c *http.Client, url string, out *os.File, start, end int64) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept-Encoding", "identity")
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return &statusError{url: url, status: resp.Status, code: resp.StatusCode}
+	}
+
+	n, err := copyBuffered(&offsetWriter{f: out, off: start}, resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if n != end-start+1 {
+		return fmt.Errorf("got %d bytes of range %d-%d", n, start, end)
+	}
+
+	return nil
+}
+
+// offsetWriter writes to f sequentially from off.
+type offsetWriter struct {
+	f   *os.File
+	off int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.f.WriteAt(p, w.off)
+	w.off += int64(n)
+	return n, err
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFetchArchiveChunks(t *testing.T) {
+	archive := bytes.Repeat([]byte("helm archive "), 1000)
+
+	tests := []struct {
+		name       string
+		chunks     string
+		rateLimit  string
+		serve      string
+		size       int
+		wantRanges int32
+		wantErr    bool
+	}{
+		{"single stream by default", "", "", "ranges", len(archive), 0, false},
+		{"chunked", "4", "", "ranges", len(archive), 4, false},
+		{"uneven chunks", "7", "", "ranges", len(archive), 7, false},
+		{"rate limited", "4", "1048576", "ranges", len(archive), 0, false},
+		{"no range support", "4", "", "plain", len(archive), 0, false},
+		{"smaller than the chunks", "8", "", "ranges", 5, 0, false},
+		{"ranges ignored", "4", "", "ignores ranges", len(archive), 4, true},
+		{"invalid chunks", "0", "", "ranges", len(archive), 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setenv(t, "HELM_WRAPPER_DOWNLOAD_CHUNKS", tt.chunks)
+			setenv(t, "HELM_WRAPPER_DOWNLOAD_RATE_LIMIT", tt.rateLimit)
+			content := archive[:tt.size]
+
+			var ranges int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Range") != "" {
+					atomic.AddInt32(&ranges, 1)
+				}
+
+				switch tt.serve {
+				case "ranges":
+					http.ServeContent(w, r, "archive", time.Time{}, bytes.NewReader(content))
+				case "ignores ranges":
+					w.Header().Set("Accept-Ranges", "bytes")
+					w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+					w.Write(content)
+				default:
+					w.Write(content)
+				}
+			}))
+			defer srv.Close()
+
+			path := filepath.Join(tempDir(t), "archive")
+			n, got, err := fetchArchive(newClient(0), srv.URL, path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("fetchArchive() error = %v, wantErr %t", err, tt.wantErr)
+			}
+
+			if r := atomic.LoadInt32(&ranges); r != tt.wantRanges {
+				t.Errorf("fetchArchive() made %d range requests, want %d", r, tt.wantRanges)
+			}
+
+			if err != nil {
+				return
+			}
+
+			want := sha256.Sum256(content)
+			if n != int64(len(content)) || got != hex.EncodeToString(want[:]) {
+				t.Errorf("fetchArchive() = %d, %s, want %d, %x", n, got, len(content), want)
+			}
+
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, content) {
+				t.Error("fetchArchive() assembled a different archive")
+			}
+		})
+	}
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// commands are the wrapper's own management commands. They're only available
+// when the wrapper is invoked as helm-wrapper, so that e.g. `helm list` is
+// still forwarded to helm.
+var commands = map[string]func(dir string, args []string) error{
+	"list":             list,
+	"migrate-cache":    migrateCache,
+	"plugin-env":       pluginEnvCmd,
+	"which":            which,
+	"cache-dir":        cacheDirCmd,
+	"doctor":           doctor,
+	"download":         downloadArchive,
+	"env":              env,
+	"fsck":             fsck,
+	"gc":               gc,
+	"prefetch":         prefetch,
+	"reset-cache":      resetCache,
+	"resolved-version": resolvedVersion,
+	"self-update":      selfUpdate,
+	"sha256":           sha256Cmd,
+	"tillers":          tillers,
+	"verify":           verify,
+}
+
+// outputFlags parses the --output flag shared by the management commands and
+// returns the remaining arguments.
+func outputFlags(name string, args []string) (string, []string, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	output := fs.String("output", "text", "output format: text or json")
+	if err := fs.Parse(args); err != nil {
+		return "", nil, err
+	}
+
+	switch *output {
+	case "text", "json":
+		return *output, fs.Args(), nil
+	}
+
+	return "", nil, fmt.Errorf("unknown output format %q", *output)
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+type cachedVersion struct {
+	Version string    `json:"version"`
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+}
+
+// cached returns the helm binaries for the host platform present in dir.
+func cached(dir string) ([]cachedVersion, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var vs []cachedVersion
+	for _, f := range files {
+		if !strings.HasPrefix(f.Name(), "helm-") || isPartial(f.Name()) {
+			continue
+		}
+
+		v, p := splitKey(strings.TrimSuffix(strings.TrimPrefix(f.Name(), "helm-"), ".exe"))
+		if p != host {
+			continue
+		}
+
+		c := cachedVersion{
+			Version: v,
+			Path:    filepath.Join(dir, f.Name()),
+			Size:    f.Size(),
+			ModTime: f.ModTime(),
+		}
+
+		// With HELM_WRAPPER_VERSION_DIRS, versions are directories holding
+		// the binary along with the rest of their archive.
+		switch {
+		case f.IsDir():
+			if c.Size, err = dirSize(c.Path); err != nil {
+				return nil, err
+			}
+		case !f.Mode().IsRegular():
+			continue
+		}
+
+		vs = append(vs, c)
+	}
+
+	return vs, nil
+}
+
+// dirSize returns the total size of the files in dir.
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if fi.Mode().IsRegular() {
+			size += fi.Size()
+		}
+
+		return nil
+	})
+
+	return size, err
+}
+
+func list(dir string, args []string) error {
+	output, _, err := outputFlags("list", args)
+	if err != nil {
+		return err
+	}
+
+	vs, err := cached(dir)
+	if err != nil {
+		return err
+	}
+
+	if output == "json" {
+		if vs == nil {
+			vs = []cachedVersion{}
+		}
+		return printJSON(vs)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tSIZE\tMODIFIED\tPATH")
+	for _, v := range vs {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", v.Version, v.Size, v.ModTime.Format(time.RFC3339), v.Path)
+	}
+
+	return w.Flush()
+}
+
+// which prints the helm binary that the given helm arguments would run.
+func which(dir string, args []string) error {
+	output, args, err := outputFlags("which", args)
+	if err != nil {
+		return err
+	}
+
+	v, _, err := resolveArgs(dir, args)
+	if err != nil {
+		return err
+	}
+
+	path := helmBin(dir, v)
+	if output == "json" {
+		return printJSON(struct {
+			Version string `json:"version"`
+			Path    string `json:"path"`
+		}{v, path})
+	}
+
+	fmt.Println(path)
+	return nil
+}
+
+// cacheDirCmd prints the absolute cache directory, resolved from the
+// environment, the config file and the default like everywhere else, e.g. for
+// CI to cache exactly that path between runs.
+func cacheDirCmd(dir string, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("usage: helm-wrapper cache-dir")
+	}
+
+	fmt.Println(dir)
+	return nil
+}
+
+// resolvedVersion prints just the helm version the given helm arguments would
+// run, e.g. for HELM_VERSION=$(helm-wrapper resolved-version upgrade -n ns).
+// What the wrapper logs while resolving it, debugging included, is dropped so
+// that a script sees nothing else, but a failure is still reported.
+func resolvedVersion(dir string, args []string) error {
+	out := log.Writer()
+	log.SetOutput(ioutil.Discard)
+	v, _, err := resolveArgs(dir, args)
+	log.SetOutput(out)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(v)
+	return nil
+}
+
+// doctorSchema is the version of the doctor's JSON report, bumped whenever a
+// field changes meaning or goes away, so monitoring can rely on it.
+const doctorSchema = 1
+
+// doctorReport is the doctor's JSON report.
+type doctorReport struct {
+	Schema int     `json:"schemaVersion"`
+	OK     bool    `json:"ok"`
+	Checks []check `json:"checks"`
+}
+
+type check struct {
+	Name string `json:"name"`
+	OK   bool   `json:"ok"`
+	// Code is "ok" or a stable identifier of the failure, for alerting.
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// newCheck returns the result of check name, which failed with code if err
+// isn't nil.
+func newCheck(name, code string, err error, msg string) check {
+	if err != nil {
+		return check{Name: name, Code: code, Message: err.Error()}
+	}
+
+	return check{Name: name, OK: true, Code: "ok", Message: msg}
+}
+
+// checks runs the doctor's diagnostics.
+func checks(dir string) []check {
+	var cs []check
+
+	f, err := ioutil.TempFile(dir, ".doctor")
+	if err == nil {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	cs = append(cs, newCheck("cache", "cache_unwritable", err, fmt.Sprintf("%s is writable", dir)))
+
+	_, err = kubeconfig("").ClientConfig()
+	cs = append(cs, newCheck("kubeconfig", "kubeconfig_invalid", err, "kubeconfig is valid"))
+
+	var ok bool
+	clientset, err := newClientset("")
+	if err == nil {
+		ok, err = checkTiller(context.Background(), clientset)
+	}
+	msg := "tiller not found"
+	if ok {
+		msg = "tiller found"
+	}
+	cs = append(cs, newCheck("tiller", "detection_failed", err, msg))
+
+	c := newClient(time.Second * 10)
+	url := archiveURL(knownVersion)
+	resp, err := c.Head(url)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("%s returned %q", url, resp.Status)
+		}
+	}
+	cs = append(cs, newCheck("upstream", "upstream_unreachable", err, fmt.Sprintf("%s is reachable", mirrorBase())))
+
+	return cs
+}
+
+// doctor runs the diagnostics and fails if any of them does. Its JSON report
+// follows doctorSchema.
+func doctor(dir string, args []string) error {
+	output, _, err := outputFlags("doctor", args)
+	if err != nil {
+		return err
+	}
+
+	cs := checks(dir)
+	var failed int
+	for _, c := range cs {
+		if !c.OK {
+			failed++
+		}
+	}
+
+	if output == "json" {
+		if err := printJSON(doctorReport{Schema: doctorSchema, OK: failed == 0, Checks: cs}); err != nil {
+			return err
+		}
+	} else {
+		for _, c := range cs {
+			status := "ok"
+			if !c.OK {
+				status = "FAIL"
+			}
+			fmt.Printf("%-10s %-4s %s\n", c.Name, status, c.Message)
+		}
+	}
+
+	if failed != 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(cs))
+	}
+
+	return nil
+}
+
+type setting struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// env prints the effective configuration and where each setting came from,
+// like `go env`.
+func env(dir string, args []string) error {
+	output, _, err := outputFlags("env", args)
+	if err != nil {
+		return err
+	}
+
+	detection := "auto"
+	detectionSource := "default"
+	if v, ok := os.LookupEnv("HELM_WRAPPER_NO_DETECT"); ok {
+		detection, detectionSource = "no-detect="+v, "env"
+	}
+
+	ss := []setting{
+		{"cacheDir", dir, settings.source("cacheDir")},
+		{"version", settings.Version, settings.source("version")},
+		{"mirror", mirrorBase(), settings.source("mirror")},
+		{"timeout", settings.Timeout, settings.source("timeout")},
+		{"namespace", settings.Namespace, settings.source("namespace")},
+		{"selector", settings.Selector, settings.source("selector")},
+		{"strategy", settings.Strategy, settings.source("strategy")},
+		{"detection", detection, detectionSource},
+		{"cacheUpstream", settings.CacheUpstream, settings.source("cacheUpstream")},
+	}
+
+	if output == "json" {
+		return printJSON(ss)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tVALUE\tSOURCE")
+	for _, s := range ss {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", s.Name, s.Value, s.Source)
+	}
+
+	return w.Flush()
+}
+
+// downloadArchive saves a verified helm release archive under its published
+// name into a directory, which defaults to the current one. Unlike the cache,
+// it keeps the whole archive, e.g. for seeding a mirror.
+func downloadArchive(dir string, args []string) error {
+	fs := flag.NewFlagSet("download", flag.ContinueOnError)
+	goos := fs.String("os", host.os, "OS of the archive")
+	goarch := fs.String("arch", host.arch, "architecture of the archive")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		return fmt.Errorf("usage: helm-wrapper download [--os os] [--arch arch] <version> [dest-dir]")
+	}
+
+	dest := "."
+	if fs.NArg() == 2 {
+		dest = fs.Arg(1)
+	}
+
+	if err := dirs(dest); err != nil {
+		return err
+	}
+
+	v := canonical(fs.Arg(0))
+	name := platform{*goos, *goarch}.archive(v)
+	path := filepath.Join(dest, name)
+	url := mirrorURL(name)
+	c, err := downloadClient()
+	if err != nil {
+		return err
+	}
+
+	_, sum, err := fetch(c, url, path+".part")
+	if err != nil {
+		os.Remove(path + ".part")
+		return err
+	}
+
+	if err := verifySum(c, v, name, sum); err != nil {
+		os.Remove(path + ".part")
+		return err
+	}
+
+	if err := os.Rename(path+".part", path); err != nil {
+		return err
+	}
+
+	fmt.Println(path)
+	return nil
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// useConfig loads the settings afresh from the environment and the config
+// file content, if any, restoring them after t.
+func useConfig(t *testing.T, content string) {
+	t.Helper()
+
+	old := settings
+	t.Cleanup(func() { settings = old })
+	settings.sources = nil
+
+	if content == "" {
+		unsetenv(t, "HELM_WRAPPER_CONFIG")
+	} else {
+		file := filepath.Join(tempDir(t), "config.yaml")
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		setenv(t, "HELM_WRAPPER_CONFIG", file)
+	}
+
+	if err := loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCacheDirCmd(t *testing.T) {
+	home := tempDir(t)
+	fromConfig := tempDir(t)
+	fromEnv := tempDir(t)
+	link := filepath.Join(tempDir(t), "cache")
+	if err := os.Symlink(fromEnv, link); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		config string
+		env    string
+		want   string
+	}{
+		{"default", "", "", filepath.Join(home, ".helm-wrapper", "bin")},
+		{"config file", "cacheDir: " + fromConfig + "\n", "", fromConfig},
+		{"environment over config file", "cacheDir: " + fromConfig + "\n", fromEnv, fromEnv},
+		{"environment variables expanded", "", "${HOME}/cache", filepath.Join(home, "cache")},
+		{"symlink resolved", "", link, fromEnv},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setenv(t, "HOME", home)
+			setenv(t, "HELM_WRAPPER_BIN_DIR", tt.env)
+			useConfig(t, tt.config)
+
+			dir, err := cacheDir()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			out := captureStdout(t, func() { err = cacheDirCmd(dir, nil) })
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := strings.TrimSuffix(out, "\n"); got != tt.want {
+				t.Errorf("cache-dir printed %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if err := cacheDirCmd(home, []string{"extra"}); err == nil {
+		t.Error("cache-dir with arguments succeeded")
+	}
+}
+
+func TestDoctor(t *testing.T) {
+	tests := []struct {
+		name       string
+		missingDir bool
+		kubeconfig string
+		denied     bool
+		archived   bool
+		want       map[string]string
+	}{
+		{
+			name:       "healthy",
+			kubeconfig: "    insecure-skip-tls-verify: true\n",
+			archived:   true,
+			want:       map[string]string{"cache": "ok", "kubeconfig": "ok", "tiller": "ok", "upstream": "ok"},
+		},
+		{
+			name:       "missing cache directory",
+			missingDir: true,
+			kubeconfig: "    insecure-skip-tls-verify: true\n",
+			archived:   true,
+			want:       map[string]string{"cache": "cache_unwritable", "kubeconfig": "ok", "tiller": "ok", "upstream": "ok"},
+		},
+		{
+			name:       "invalid kubeconfig",
+			kubeconfig: "    certificate-authority: /nonexistent/ca.crt\n",
+			archived:   true,
+			want:       map[string]string{"cache": "ok", "kubeconfig": "kubeconfig_invalid", "tiller": "ok", "upstream": "ok"},
+		},
+		{
+			name:       "pods forbidden",
+			kubeconfig: "    insecure-skip-tls-verify: true\n",
+			denied:     true,
+			archived:   true,
+			want:       map[string]string{"cache": "ok", "kubeconfig": "ok", "tiller": "detection_failed", "upstream": "ok"},
+		},
+		{
+			name:       "archive missing upstream",
+			kubeconfig: "    insecure-skip-tls-verify: true\n",
+			want:       map[string]string{"cache": "ok", "kubeconfig": "ok", "tiller": "ok", "upstream": "upstream_unreachable"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeKubeconfig(t, tt.kubeconfig)
+
+			clientset := fake.NewSimpleClientset(tillerPod("kube-system", "tiller-deploy-1", "v2.16.12"))
+			if tt.denied {
+				clientset.PrependReactor("list", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+					return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: "pods"}, "", nil)
+				})
+			}
+			old := newClientset
+			t.Cleanup(func() { newClientset = old })
+			newClientset = func(string) (kubernetes.Interface, error) {
+				return clientset, nil
+			}
+
+			files := map[string]string{}
+			if tt.archived {
+				files[archiveName(knownVersion)] = "archive"
+			}
+			mirrorServer(t, files)
+
+			dir := tempDir(t)
+			if tt.missingDir {
+				dir = filepath.Join(dir, "missing")
+			}
+
+			var err error
+			out := captureStdout(t, func() { err = doctor(dir, []string{"-output", "json"}) })
+
+			var report doctorReport
+			if err := json.Unmarshal([]byte(out), &report); err != nil {
+				t.Fatalf("doctor printed invalid JSON %q: %v", out, err)
+			}
+
+			healthy := true
+			for _, code := range tt.want {
+				healthy = healthy && code == "ok"
+			}
+			if (err == nil) != healthy || report.OK != healthy {
+				t.Errorf("doctor() = %v, reported ok %t, want ok %t", err, report.OK, healthy)
+			}
+			if report.Schema != doctorSchema {
+				t.Errorf("doctor reported schema %d, want %d", report.Schema, doctorSchema)
+			}
+
+			got := map[string]string{}
+			for _, c := range report.Checks {
+				got[c.Name] = c.Code
+				if c.OK != (c.Code == "ok") || c.Message == "" {
+					t.Errorf("doctor reported check %+v", c)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("doctor reported %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDoctorText(t *testing.T) {
+	writeKubeconfig(t, "    insecure-skip-tls-verify: true\n")
+	fakeCluster(t)
+	mirrorServer(t, nil)
+
+	var err error
+	out := captureStdout(t, func() { err = doctor(tempDir(t), nil) })
+	if err == nil || !strings.Contains(err.Error(), "1 of 4 checks failed") {
+		t.Errorf("doctor() = %v, want 1 of 4 checks failed", err)
+	}
+
+	for _, want := range []string{"cache      ok", "tiller     ok   tiller not found", "upstream   FAIL"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("doctor printed\n%s\nwant %q", out, want)
+		}
+	}
+}
+
+func TestDownloadArchive(t *testing.T) {
+	const archive = "archive"
+	sum := sha256.Sum256([]byte(archive))
+	good := hex.EncodeToString(sum[:])
+	name := archiveName("v3.4.0")
+
+	tests := []struct {
+		name      string
+		dest      string
+		existing  string
+		published string
+		want      string
+		wantErr   bool
+	}{
+		{"default directory", "", "", good, archive, false},
+		{"explicit path", filepath.Join("archives", "helm"), "", good, archive, false},
+		{"existing file", "", "stale", good, archive, false},
+		{"cleaned up on failure", "", "", sumB, "", true},
+		{"existing file kept on failure", "", "stale", sumB, "stale", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetIndex(t)
+			mirrorServer(t, map[string]string{name: archive, name + ".sha256": tt.published})
+
+			wd, err := os.Getwd()
+			if err != nil {
+				t.Fatal(err)
+			}
+			dir := tempDir(t)
+			if err := os.Chdir(dir); err != nil {
+				t.Fatal(err)
+			}
+			defer os.Chdir(wd)
+
+			path := filepath.Join(tt.dest, name)
+			if tt.existing != "" {
+				if err := ioutil.WriteFile(path, []byte(tt.existing), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			args := []string{"v3.4.0"}
+			if tt.dest != "" {
+				args = append(args, tt.dest)
+			}
+
+			var runErr error
+			out := captureStdout(t, func() { runErr = downloadArchive(dir, args) })
+			if (runErr != nil) != tt.wantErr {
+				t.Fatalf("downloadArchive() error = %v, wantErr %t", runErr, tt.wantErr)
+			}
+
+			if !tt.wantErr && out != path+"\n" {
+				t.Errorf("downloadArchive() printed %q, want %q", out, path+"\n")
+			}
+
+			b, err := ioutil.ReadFile(path)
+			if tt.want == "" && !os.IsNotExist(err) {
+				t.Errorf("failed download left %s behind: %q, %v", path, b, err)
+			}
+			if tt.want != "" && string(b) != tt.want {
+				t.Errorf("%s holds %q, %v, want %q", path, b, err, tt.want)
+			}
+
+			var want []string
+			if tt.want != "" {
+				want = []string{name}
+			}
+			if got := names(t, filepath.Join(dir, tt.dest)); !reflect.DeepEqual(got, want) {
+				t.Errorf("download left %v in the destination, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestResolvedVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    string
+		wantErr bool
+	}{
+		{"pinned", []string{"+2.16.12", "upgrade", "web", "stable/web"}, "v2.16.12\n", false},
+		{"unknown version", []string{"+v9.9.9", "upgrade", "web", "stable/web"}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTempScratch(t)
+			setenv(t, "HELM_WRAPPER_USE_SYSTEM_HELM", "false")
+			// Logs every download, and more.
+			setenv(t, "HELM_WRAPPER_DEBUG", "true")
+			var downloads int
+			countingMirror(t, "#!/bin/sh\necho 'Client: v2.16.12+g47f0b88'\n", &downloads)
+			dir := tempDir(t)
+
+			var out string
+			var err error
+			stderr := captureStderr(t, func() {
+				out = captureStdout(t, func() { err = resolvedVersion(dir, tt.args) })
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolvedVersion() error = %v, wantErr %t", err, tt.wantErr)
+			}
+
+			if out != tt.want {
+				t.Errorf("resolvedVersion() printed %q, want %q", out, tt.want)
+			}
+			if stderr != "" {
+				t.Errorf("resolvedVersion() wrote %q to stderr, want nothing", stderr)
+			}
+		})
+	}
+}
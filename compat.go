@@ -0,0 +1,38 @@
+package main
+
+import (
+	"log"
+
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+// majorOnly maps helm subcommands that exist in only one major version to it.
+var majorOnly = map[string]uint{
+	"home":      2,
+	"init":      2,
+	"reset":     2,
+	"serve":     2,
+	"chart":     3,
+	"env":       3,
+	"pull":      3,
+	"registry":  3,
+	"show":      3,
+	"uninstall": 3,
+}
+
+// checkCompat warns when the subcommand in args doesn't exist in helm v,
+// suggesting a version that has it.
+func checkCompat(v string, args []string) {
+	sub := subcommand(args)
+	want, ok := majorOnly[sub]
+	if !ok {
+		return
+	}
+
+	sv, err := version.ParseSemantic(v)
+	if err != nil || sv.Major() == want {
+		return
+	}
+
+	log.Printf("helm %s has no %s command, which is helm %d only; run e.g. helm +latest-%d %s", v, sub, want, want, sub)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckCompat(t *testing.T) {
+	tests := []struct {
+		v    string
+		args []string
+		want string
+	}{
+		{"v3.4.0", []string{"install", "web", "./chart"}, ""},
+		{"v2.16.12", []string{"install", "web", "./chart"}, ""},
+		{"v3.4.0", []string{"init", "--client-only"}, "helm v3.4.0 has no init command, which is helm 2 only; run e.g. helm +latest-2 init"},
+		{"v2.16.12", []string{"--kube-context", "prod", "uninstall", "web"}, "helm v2.16.12 has no uninstall command, which is helm 3 only"},
+		{"v2.16.12", []string{"reset"}, ""},
+		{"v3.4.0", []string{"pull", "stable/nginx"}, ""},
+		{"latest", []string{"init"}, ""},
+	}
+
+	for _, tt := range tests {
+		got := captureStderr(t, func() { checkCompat(tt.v, tt.args) })
+		if tt.want == "" && got != "" || !strings.Contains(got, tt.want) {
+			t.Errorf("checkCompat(%s, %q) warned %q, want %q", tt.v, tt.args, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const bashCompletion = `_helm_wrapper() {
+	if [ "$COMP_CWORD" -eq 1 ]; then
+		COMPREPLY=($(compgen -W "%s" -- "${COMP_WORDS[1]}"))
+	fi
+}
+complete -F _helm_wrapper helm-wrapper
+`
+
+const zshCompletion = `#compdef helm-wrapper
+
+_arguments '1:command:(%s)'
+`
+
+const fishCompletion = `complete -c helm-wrapper -f -n '__fish_use_subcommand' -a '%s'
+`
+
+// The completion command refers to commands, so it's registered here rather
+// than in the map itself, which would be an initialization cycle.
+func init() {
+	commands["completion"] = completion
+}
+
+// completion prints a completion script for the wrapper's own commands for
+// the given shell, e.g. `source <(helm-wrapper completion bash)`.
+func completion(dir string, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: helm-wrapper completion <bash|zsh|fish>")
+	}
+
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	words := strings.Join(names, " ")
+
+	switch args[0] {
+	case "bash":
+		fmt.Printf(bashCompletion, words)
+	case "zsh":
+		fmt.Printf(zshCompletion, words)
+	case "fish":
+		fmt.Printf(fishCompletion, words)
+	default:
+		return fmt.Errorf("unsupported shell %q, must be one of bash, zsh or fish", args[0])
+	}
+
+	return nil
+}
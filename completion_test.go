@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestCompletion(t *testing.T) {
+	tests := []struct {
+		args    []string
+		want    string
+		wantErr bool
+	}{
+		{[]string{"bash"}, "complete -F _helm_wrapper helm-wrapper", false},
+		{[]string{"zsh"}, "#compdef helm-wrapper", false},
+		{[]string{"fish"}, "complete -c helm-wrapper", false},
+		{[]string{"powershell"}, "", true},
+		{nil, "", true},
+	}
+
+	for _, tt := range tests {
+		var err error
+		out := captureStdout(t, func() { err = completion("", tt.args) })
+		if (err != nil) != tt.wantErr {
+			t.Errorf("completion(%q) error = %v, wantErr %t", tt.args, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		if !strings.Contains(out, tt.want) {
+			t.Errorf("completion(%q) = %q, want it to contain %q", tt.args, out, tt.want)
+		}
+		for name := range commands {
+			if !strings.Contains(out, name) {
+				t.Errorf("completion(%q) doesn't complete %s", tt.args, name)
+			}
+		}
+	}
+}
+
+func TestBashCompletionParses(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash isn't installed")
+	}
+
+	out := captureStdout(t, func() {
+		if err := completion("", []string{"bash"}); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	script := out + `COMP_WORDS=(helm-wrapper pre); COMP_CWORD=1; _helm_wrapper; echo "${COMPREPLY[@]}"`
+	got, err := exec.Command(bash, "-c", script).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.TrimSpace(string(got)) != "prefetch" {
+		t.Errorf("completing pre gave %q, want prefetch", got)
+	}
+}
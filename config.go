@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// config holds the wrapper's settings. Each of them is read from the config
+// file, which is HELM_WRAPPER_CONFIG or ~/.helm-wrapper/config.yaml, and can
+// be overridden by its environment variable:
+//
+//	version:       HELM_WRAPPER_VERSION           client default helm version, latest unless set
+//	mirror:        HELM_WRAPPER_MIRROR            base URL helm archives are downloaded from, may hold user:password@
+//	namespace:     HELM_WRAPPER_TILLER_NAMESPACE  namespace Tiller is looked up in
+//	selector:      HELM_WRAPPER_TILLER_SELECTOR   label selector of Tiller pods
+//	timeout:       HELM_WRAPPER_TIMEOUT           download timeout
+//	cacheDir:      HELM_WRAPPER_BIN_DIR           directory helm binaries are kept in
+//	strategy:      HELM_WRAPPER_STRATEGY          resolution strategy: annotation, configmap, pin, probe, release or tiller
+//	cacheUpstream: HELM_WRAPPER_CACHE_UPSTREAM    internal cache downloaded archives are uploaded to
+//
+// The config file can also map namespaces to the helm version used for
+// commands in them, ahead of any detection:
+//
+//	namespaces:
+//	  legacy: v2.14.3
+//
+// and pin the sha256 of release archives, which downloads are then checked
+// against along with the published checksum:
+//
+//	checksums:
+//	  helm-v2.16.12-linux-amd64.tar.gz: <hex sha256>
+//
+// and list the contexts that mutating commands need confirming for with
+// HELM_WRAPPER_PROTECT set:
+//
+//	protectedContexts:
+//	  - production
+//
+// and set the end of life dates of helm release lines, warned about once when
+// run:
+//
+//	eol:
+//	  v3.5: 2021-09-13
+//
+// and set environment variables for the helm versions in a range, unless
+// they're already set:
+//
+//	env:
+//	  - versions: ">=3.7 <3.8"
+//	    vars:
+//	      HELM_EXPERIMENTAL_OCI: "1"
+type config struct {
+	Version       string `json:"version"`
+	Mirror        string `json:"mirror"`
+	Namespace     string `json:"namespace"`
+	Selector      string `json:"selector"`
+	Timeout       string `json:"timeout"`
+	CacheDir      string `json:"cacheDir"`
+	Strategy      string `json:"strategy"`
+	CacheUpstream string `json:"cacheUpstream"`
+
+	Namespaces map[string]string `json:"namespaces"`
+	Checksums  map[string]string `json:"checksums"`
+
+	ProtectedContexts []string `json:"protectedContexts"`
+
+	EOL map[string]string `json:"eol"`
+
+	Env []envRule `json:"env"`
+
+	timeout time.Duration
+	// sources records where each setting, by its key, was last set from.
+	sources map[string]string
+}
+
+// settings is the effective configuration, populated by loadConfig.
+var settings = config{
+	Version:   defaultVersion,
+	Mirror:    "https://get.helm.sh",
+	Namespace: "kube-system",
+	Selector:  "app=helm,name=tiller",
+	Timeout:   "120s",
+	CacheDir:  "${HOME}/.helm-wrapper/bin",
+	Strategy:  "tiller",
+}
+
+// loadConfig applies the config file and then the environment on top of the
+// defaults in settings.
+func loadConfig() error {
+	path, explicit := os.LookupEnv("HELM_WRAPPER_CONFIG")
+	if !explicit {
+		path = os.ExpandEnv("${HOME}/.helm-wrapper/config.yaml")
+	}
+
+	b, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+		var file config
+		if err := yaml.UnmarshalStrict(b, &file); err != nil {
+			return fmt.Errorf("invalid config %s: %v", path, err)
+		}
+		settings.merge(file, "config")
+	case explicit || !os.IsNotExist(err):
+		return err
+	}
+
+	settings.merge(config{
+		Version:       os.Getenv("HELM_WRAPPER_VERSION"),
+		Mirror:        os.Getenv("HELM_WRAPPER_MIRROR"),
+		Namespace:     os.Getenv("HELM_WRAPPER_TILLER_NAMESPACE"),
+		Selector:      os.Getenv("HELM_WRAPPER_TILLER_SELECTOR"),
+		Timeout:       os.Getenv("HELM_WRAPPER_TIMEOUT"),
+		CacheDir:      os.Getenv("HELM_WRAPPER_BIN_DIR"),
+		Strategy:      os.Getenv("HELM_WRAPPER_STRATEGY"),
+		CacheUpstream: os.Getenv("HELM_WRAPPER_CACHE_UPSTREAM"),
+	}, "env")
+
+	settings.timeout, err = time.ParseDuration(settings.Timeout)
+	if err != nil {
+		return fmt.Errorf("invalid timeout %q: %v", settings.Timeout, err)
+	}
+
+	return nil
+}
+
+// merge overrides c with the non-empty settings of o, which come from source.
+func (c *config) merge(o config, source string) {
+	if c.sources == nil {
+		c.sources = map[string]string{}
+	}
+
+	set := func(key string, dst *string, src string) {
+		if src != "" {
+			*dst = src
+			c.sources[key] = source
+		}
+	}
+
+	set("version", &c.Version, o.Version)
+	set("mirror", &c.Mirror, o.Mirror)
+	set("namespace", &c.Namespace, o.Namespace)
+	set("selector", &c.Selector, o.Selector)
+	set("timeout", &c.Timeout, o.Timeout)
+	set("cacheDir", &c.CacheDir, o.CacheDir)
+	set("strategy", &c.Strategy, o.Strategy)
+	set("cacheUpstream", &c.CacheUpstream, o.CacheUpstream)
+
+	if len(o.Namespaces) != 0 {
+		c.Namespaces = o.Namespaces
+		c.sources["namespaces"] = source
+	}
+
+	if len(o.Checksums) != 0 {
+		c.Checksums = o.Checksums
+		c.sources["checksums"] = source
+	}
+
+	if len(o.ProtectedContexts) != 0 {
+		c.ProtectedContexts = o.ProtectedContexts
+		c.sources["protectedContexts"] = source
+	}
+
+	if len(o.EOL) != 0 {
+		c.EOL = o.EOL
+		c.sources["eol"] = source
+	}
+
+	if len(o.Env) != 0 {
+		c.Env = o.Env
+		c.sources["env"] = source
+	}
+}
+
+// source returns where the setting key came from: default, config or env.
+func (c *config) source(key string) string {
+	if s, ok := c.sources[key]; ok {
+		return s
+	}
+
+	return "default"
+}
+
+// envBool reports whether the environment variable name is set to a true
+// value, as understood by strconv.ParseBool.
+func envBool(name string) bool {
+	b, _ := strconv.ParseBool(os.Getenv(name))
+	return b
+}
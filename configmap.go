@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// versionKey is the ConfigMap key holding the helm version of a cluster.
+const versionKey = "helm-version"
+
+// configMapResolver resolves to the version cluster admins set in the
+// helm-version key of a ConfigMap, kube-system/helm-wrapper unless given as
+// <namespace>/<name> with HELM_WRAPPER_VERSION_CONFIGMAP. Without one, it
+// falls back to Tiller detection.
+type configMapResolver struct {
+	dir         string
+	kubeContext string
+}
+
+func (r configMapResolver) Resolve(ctx context.Context) (string, error) {
+	namespace, name, err := versionConfigMap()
+	if err != nil {
+		return "", err
+	}
+
+	clientset, err := newClientset(r.kubeContext)
+	if noContext(err) {
+		log.Printf("no current kube-context; skipping ConfigMap detection")
+		return "", errUndetected
+	}
+	if err != nil {
+		return "", err
+	}
+
+	v, ok, err := clusterVersion(ctx, clientset, namespace, name)
+	if err != nil {
+		return "", err
+	}
+
+	if !ok {
+		debugf("no %s in ConfigMap %s/%s, detecting Tiller", versionKey, namespace, name)
+		return tillerResolver{dir: r.dir, kubeContext: r.kubeContext}.Resolve(ctx)
+	}
+
+	return pin(v, r.dir)
+}
+
+// versionConfigMap returns the namespace and name of the ConfigMap holding
+// the cluster's helm version.
+func versionConfigMap() (string, string, error) {
+	s := os.Getenv("HELM_WRAPPER_VERSION_CONFIGMAP")
+	if s == "" {
+		return "kube-system", "helm-wrapper", nil
+	}
+
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid HELM_WRAPPER_VERSION_CONFIGMAP %q, must be <namespace>/<name>", s)
+	}
+
+	return parts[0], parts[1], nil
+}
+
+// clusterVersion returns the helm version set in the ConfigMap namespace/name,
+// and whether there's one.
+func clusterVersion(ctx context.Context, clientset kubernetes.Interface, namespace, name string) (string, bool, error) {
+	cm, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	v := strings.TrimSpace(cm.Data[versionKey])
+	return v, v != "", nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestVersionConfigMap(t *testing.T) {
+	tests := []struct {
+		env           string
+		wantNamespace string
+		wantName      string
+		wantErr       bool
+	}{
+		{"", "kube-system", "helm-wrapper", false},
+		{"platform/helm", "platform", "helm", false},
+		{"helm", "", "", true},
+		{"platform/", "", "", true},
+		{"a/b/c", "", "", true},
+	}
+
+	for _, tt := range tests {
+		setenv(t, "HELM_WRAPPER_VERSION_CONFIGMAP", tt.env)
+
+		namespace, name, err := versionConfigMap()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("versionConfigMap() of %q error = %v, wantErr %t", tt.env, err, tt.wantErr)
+			continue
+		}
+
+		if namespace != tt.wantNamespace || name != tt.wantName {
+			t.Errorf("versionConfigMap() of %q = %s/%s, want %s/%s", tt.env, namespace, name, tt.wantNamespace, tt.wantName)
+		}
+	}
+}
+
+func TestClusterVersion(t *testing.T) {
+	blank := versionConfigMapOf(" ")
+	other := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "helm-wrapper"},
+		Data:       map[string]string{"kubectl-version": "v1.18.3"},
+	}
+
+	tests := []struct {
+		name    string
+		objects []runtime.Object
+		denied  bool
+		want    string
+		wantOK  bool
+		wantErr bool
+	}{
+		{"set", []runtime.Object{versionConfigMapOf(" v3.4.0\n")}, false, "v3.4.0", true, false},
+		{"no ConfigMap", nil, false, "", false, false},
+		{"blank", []runtime.Object{blank}, false, "", false, false},
+		{"other keys", []runtime.Object{other}, false, "", false, false},
+		{"forbidden", nil, true, "", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(tt.objects...)
+			if tt.denied {
+				clientset.PrependReactor("get", "configmaps", func(k8stesting.Action) (bool, runtime.Object, error) {
+					return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: "configmaps"}, "helm-wrapper", nil)
+				})
+			}
+
+			got, ok, err := clusterVersion(context.Background(), clientset, "kube-system", "helm-wrapper")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("clusterVersion() error = %v, wantErr %t", err, tt.wantErr)
+			}
+
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("clusterVersion() = %q, %t, want %q, %t", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
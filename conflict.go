@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+// Conflict policies, chosen with HELM_WRAPPER_CONFLICT, for when a namespace's
+// pinned version and the one detected by the resolution strategy disagree.
+const (
+	// preferPin runs the pinned version without asking the cluster. It's the
+	// default.
+	preferPin = "prefer-pin"
+	// preferServer runs the detected version.
+	preferServer = "prefer-server"
+	// conflictError refuses to run either.
+	conflictError = "error"
+	// pinOrNewerServer runs the pinned version unless the detected one is
+	// newer, so the client is never older than the server.
+	pinOrNewerServer = "pin-or-newer-server"
+)
+
+// conflictPolicy returns the conflict policy set with HELM_WRAPPER_CONFLICT
+// or, under the name it was first asked for, HELM_WRAPPER_POLICY.
+func conflictPolicy() (string, error) {
+	name := "HELM_WRAPPER_CONFLICT"
+	p := os.Getenv(name)
+	if p == "" {
+		name = "HELM_WRAPPER_POLICY"
+		p = os.Getenv(name)
+	}
+
+	switch p {
+	case "":
+		return preferPin, nil
+	case preferPin, preferServer, conflictError, pinOrNewerServer:
+		return p, nil
+	default:
+		return "", fmt.Errorf("invalid %s %q, must be one of %s, %s, %s or %s", name, p, preferPin, preferServer, pinOrNewerServer, conflictError)
+	}
+}
+
+// reconcile returns the version to run given the pinned and detected ones.
+func reconcile(policy, pinned, detected, namespace string) (string, error) {
+	if detected == pinned {
+		return pinned, nil
+	}
+
+	switch policy {
+	case preferServer:
+		debugf("namespace %s is pinned to helm %s, but %s was detected, using %s", namespace, pinned, detected, detected)
+		return detected, nil
+	case conflictError:
+		return "", fmt.Errorf("namespace %s is pinned to helm %s, but %s was detected", namespace, pinned, detected)
+	case pinOrNewerServer:
+		pv, err := version.ParseSemantic(pinned)
+		if err != nil {
+			return "", err
+		}
+
+		dv, err := version.ParseSemantic(detected)
+		if err != nil {
+			return "", err
+		}
+
+		if pv.LessThan(dv) {
+			debugf("namespace %s is pinned to helm %s, but the newer %s was detected, using %s", namespace, pinned, detected, detected)
+			return detected, nil
+		}
+	}
+
+	return pinned, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestReconcile(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   string
+		pinned   string
+		detected string
+		want     string
+		wantErr  bool
+	}{
+		{"agree", conflictError, "v2.16.12", "v2.16.12", "v2.16.12", false},
+		{"prefer pin", preferPin, "v2.14.3", "v2.16.12", "v2.14.3", false},
+		{"prefer server", preferServer, "v2.14.3", "v2.16.12", "v2.16.12", false},
+		{"prefer server detecting the client default", preferServer, "v2.14.3", "v2.16.12", "v2.16.12", false},
+		{"error", conflictError, "v2.14.3", "v2.16.12", "", true},
+		{"error detecting the client default", conflictError, "v2.14.3", "v2.16.12", "", true},
+		{"newer server", pinOrNewerServer, "v2.14.3", "v2.16.12", "v2.16.12", false},
+		{"older server", pinOrNewerServer, "v2.16.12", "v2.14.3", "v2.16.12", false},
+		{"same server", pinOrNewerServer, "v2.16.12", "v2.16.12", "v2.16.12", false},
+		{"released server", pinOrNewerServer, "v2.16.12-rc.1", "v2.16.12", "v2.16.12", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := reconcile(tt.policy, tt.pinned, tt.detected, "legacy")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("reconcile() error = %v, wantErr %t", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("reconcile() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConflictPolicy(t *testing.T) {
+	tests := []struct {
+		conflict string
+		policy   string
+		want     string
+		wantErr  bool
+	}{
+		{"", "", preferPin, false},
+		{"prefer-server", "", preferServer, false},
+		{"pin-or-newer-server", "", pinOrNewerServer, false},
+		{"error", "", conflictError, false},
+		{"newest", "", "", true},
+		{"", "pin-or-newer-server", pinOrNewerServer, false},
+		{"", "newest", "", true},
+		{"prefer-server", "pin-or-newer-server", preferServer, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.conflict+"/"+tt.policy, func(t *testing.T) {
+			setenv(t, "HELM_WRAPPER_CONFLICT", tt.conflict)
+			setenv(t, "HELM_WRAPPER_POLICY", tt.policy)
+
+			got, err := conflictPolicy()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("conflictPolicy() error = %v, wantErr %t", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("conflictPolicy() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveUndetected(t *testing.T) {
+	dir := tempDir(t)
+	for _, v := range []string{"v2.14.3", "v2.16.12"} {
+		if err := ioutil.WriteFile(binPath(dir, v), []byte("#!/bin/sh\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	old := settings
+	t.Cleanup(func() { settings = old })
+	settings.Version = "v2.16.12"
+	settings.Strategy = "pin"
+	settings.Namespaces = map[string]string{"legacy": "v2.14.3"}
+	setenv(t, "HELM_WRAPPER_CONFLICT", conflictError)
+
+	tests := []struct {
+		namespace string
+		want      string
+	}{
+		{"legacy", "v2.14.3"},
+		{"default", "v2.16.12"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.namespace, func(t *testing.T) {
+			got, err := resolve(dir, target{namespace: tt.namespace})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tt.want {
+				t.Errorf("resolve() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+)
+
+// helmDebug is set by followDebug when the wrapper's debugging follows helm's.
+var helmDebug bool
+
+// debugf logs wrapper diagnostics when HELM_WRAPPER_DEBUG is set. Helm's own
+// --debug is passed on to helm untouched and doesn't turn them on, unless
+// HELM_WRAPPER_DEBUG_FOLLOWS_HELM is set.
+func debugf(format string, args ...interface{}) {
+	if helmDebug || envBool("HELM_WRAPPER_DEBUG") {
+		log.Printf(format, args...)
+	}
+}
+
+// followDebug turns on the wrapper's debugging when helm args carry --debug
+// and HELM_WRAPPER_DEBUG_FOLLOWS_HELM is set.
+func followDebug(args []string) {
+	helmDebug = envBool("HELM_WRAPPER_DEBUG_FOLLOWS_HELM") && hasFlag(args, "--debug")
+}
+
+// quiet silences everything the wrapper itself logs, including debugging and
+// fatal errors, when HELM_WRAPPER_QUIET is set, which wins over
+// HELM_WRAPPER_DEBUG. The wrapper's other stderr output, such as usage and
+// prompts, goes through log.Writer() so it's silenced too. Helm's own output
+// and exit code are left alone, and failures of the wrapper still exit with
+// their exit codes.
+func quiet() {
+	if envBool("HELM_WRAPPER_QUIET") {
+		log.SetOutput(ioutil.Discard)
+	}
+}
+
+// humanSize formats n bytes for people, e.g. 12.3 MB.
+func humanSize(n int64) string {
+	switch {
+	case n >= 1e9:
+		return fmt.Sprintf("%.1f GB", float64(n)/1e9)
+	case n >= 1e6:
+		return fmt.Sprintf("%.1f MB", float64(n)/1e6)
+	case n >= 1e3:
+		return fmt.Sprintf("%.1f kB", float64(n)/1e3)
+	}
+
+	return fmt.Sprintf("%d B", n)
+}
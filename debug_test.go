@@ -0,0 +1,152 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// captureStderr returns what f writes to stderr, directly or through the
+// standard logger.
+func captureStderr(t *testing.T, f func()) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	oldStderr, oldLog := os.Stderr, log.Writer()
+	os.Stderr = w
+	log.SetOutput(w)
+	defer func() {
+		os.Stderr = oldStderr
+		log.SetOutput(oldLog)
+	}()
+
+	f()
+	w.Close()
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(b)
+}
+
+func TestQuiet(t *testing.T) {
+	old := settings
+	t.Cleanup(func() { settings = old })
+	settings.ProtectedContexts = []string{"production"}
+
+	setenv(t, "HELM_WRAPPER_DEBUG", "true")
+	setenv(t, "HELM_WRAPPER_PRINT_COMMAND", "true")
+	setenv(t, "HELM_WRAPPER_PROTECT", "true")
+	pretendTerminal(t, true)
+
+	wrapper := func() {
+		debugf("resolving")
+		log.Printf("couldn't record use of helm")
+		pluginArgs([]string{"--help"})
+		printCommand("/bin/helm", []string{"list"})
+	}
+
+	for _, quietly := range []bool{false, true} {
+		if quietly {
+			setenv(t, "HELM_WRAPPER_QUIET", "true")
+		}
+
+		got := captureStderr(t, func() {
+			quiet()
+			wrapper()
+		})
+
+		if quietly && got != "" {
+			t.Errorf("quiet wrapper wrote %q to stderr", got)
+		}
+		if !quietly && got == "" {
+			t.Error("wrapper wrote nothing to stderr without HELM_WRAPPER_QUIET")
+		}
+	}
+
+	var err error
+	got := captureStderr(t, func() {
+		quiet()
+		err = protect([]string{"--kube-context", "production", "delete", "web"})
+	})
+
+	if got != "" {
+		t.Errorf("quiet protect wrote %q to stderr", got)
+	}
+	if err == nil {
+		t.Error("quiet protect allowed a mutating command against a protected context")
+	}
+}
+
+func TestHelmDebugIsSeparate(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    string
+		debug   string
+		follows string
+		want    bool
+	}{
+		{"helm debug", "--debug upgrade web ./chart", "", "", false},
+		{"wrapper debug", "upgrade web ./chart", "true", "", true},
+		{"both", "upgrade --debug web ./chart", "true", "", true},
+		{"following helm", "upgrade --debug web ./chart", "", "true", true},
+		{"following without helm debug", "upgrade web ./chart", "", "true", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Cleanup(func() { helmDebug = false })
+			setenv(t, "HELM_WRAPPER_DEBUG", tt.debug)
+			setenv(t, "HELM_WRAPPER_DEBUG_FOLLOWS_HELM", tt.follows)
+
+			old := settings
+			t.Cleanup(func() { settings = old })
+			settings.Strategy = "pin"
+			settings.Version = "v3.4.0"
+
+			dir := tempDir(t)
+			out := filepath.Join(dir, "args")
+			if err := ioutil.WriteFile(binPath(dir, "v3.4.0"), []byte("#!/bin/sh\necho \"$@\" > \"$OUT\"\n"), 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			args := strings.Fields(tt.args)
+			var v string
+			var forwarded []string
+			var err error
+			got := captureStderr(t, func() {
+				followDebug(args)
+				debugf("wrapper diagnostics")
+				v, forwarded, err = resolveArgs(dir, args)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if strings.Contains(got, "wrapper diagnostics") != tt.want {
+				t.Errorf("wrapper logged %q, want its debugging on: %t", got, tt.want)
+			}
+
+			if code, err := run(binPath(dir, v), forwarded, []string{"OUT=" + out}); err != nil || code != 0 {
+				t.Fatalf("run() = %d, %v", code, err)
+			}
+			b, err := ioutil.ReadFile(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.TrimSpace(string(b)) != tt.args {
+				t.Errorf("helm got %q, want %q untouched", strings.TrimSpace(string(b)), tt.args)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+// detectTTL returns how long a detected server version is trusted before
+// Tiller is asked again, set with HELM_WRAPPER_DETECT_TTL. Without it
+// detection isn't cached.
+func detectTTL() (time.Duration, error) {
+	s := os.Getenv("HELM_WRAPPER_DETECT_TTL")
+	if s == "" {
+		return 0, nil
+	}
+
+	ttl, err := time.ParseDuration(s)
+	if err != nil || ttl < 0 {
+		return 0, fmt.Errorf("invalid HELM_WRAPPER_DETECT_TTL %q", s)
+	}
+
+	return ttl, nil
+}
+
+// currentContext returns kubeContext, or the name of the kubeconfig's current
+// context if it's empty.
+func currentContext(kubeContext string) string {
+	if kubeContext != "" {
+		return kubeContext
+	}
+
+	config, err := kubeconfig("").RawConfig()
+	if err != nil {
+		return ""
+	}
+
+	return config.CurrentContext
+}
+
+// detectDir returns the directory holding the detection cache in dir.
+func detectDir(dir string) string {
+	return filepath.Join(dir, ".detect")
+}
+
+// detectPath returns the detection cache entry of kubeContext.
+func detectPath(dir, kubeContext string) string {
+	return filepath.Join(detectDir(dir), url.PathEscape(kubeContext))
+}
+
+// noneDetected is what the detection cache holds for a context where no
+// version was detected.
+const noneDetected = "none"
+
+// cachedDetection returns the version detected for kubeContext, or "" if none
+// was, if that was less than ttl ago.
+func cachedDetection(dir, kubeContext string, ttl time.Duration) (string, bool) {
+	path := detectPath(dir, kubeContext)
+	fi, err := os.Stat(path)
+	if err != nil || time.Since(fi.ModTime()) >= ttl {
+		return "", false
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+
+	// Anything that isn't a version, e.g. left by an older wrapper, is a miss
+	// and detected again.
+	v := strings.TrimSpace(string(b))
+	if v == noneDetected {
+		return "", true
+	}
+
+	if _, err := version.ParseSemantic(v); err != nil {
+		return "", false
+	}
+
+	return v, true
+}
+
+// storeDetection records v as the version detected for kubeContext, where ""
+// means none was. It's written to a temporary file renamed into place, so
+// concurrent runs never read a partial entry.
+func storeDetection(dir, kubeContext, v string) error {
+	if err := dirs(detectDir(dir)); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(detectDir(dir), ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if v == "" {
+		v = noneDetected
+	}
+
+	if _, err := tmp.WriteString(v); err != nil {
+		return err
+	}
+
+	if err := tmp.Chmod(0644); err != nil {
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), detectPath(dir, kubeContext))
+}
+
+// resetCache clears the detection cache, leaving downloaded binaries alone,
+// so that e.g. a Tiller upgrade is picked up straight away.
+func resetCache(dir string, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("usage: helm-wrapper reset-cache")
+	}
+
+	return os.RemoveAll(detectDir(dir))
+}
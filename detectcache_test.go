@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDetectionCache(t *testing.T) {
+	tests := []struct {
+		name   string
+		stored string
+		want   string
+	}{
+		{"version", "v2.16.12", "v2.16.12"},
+		{"nothing detected", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := tempDir(t)
+
+			if err := storeDetection(dir, "staging", tt.stored); err != nil {
+				t.Fatal(err)
+			}
+
+			got, ok := cachedDetection(dir, "staging", time.Hour)
+			if !ok || got != tt.want {
+				t.Errorf("cachedDetection() = %q, %t, want %q, true", got, ok, tt.want)
+			}
+
+			if _, ok := cachedDetection(dir, "staging", 0); ok {
+				t.Error("cachedDetection() hit with an expired ttl")
+			}
+
+			if _, ok := cachedDetection(dir, "production", time.Hour); ok {
+				t.Error("cachedDetection() hit for another context")
+			}
+		})
+	}
+}
+
+func TestCachedDetectionIgnoresGarbage(t *testing.T) {
+	dir := tempDir(t)
+	if err := dirs(detectDir(dir)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(detectPath(dir, "staging"), []byte("Client: &version"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, ok := cachedDetection(dir, "staging", time.Hour); ok {
+		t.Errorf("cachedDetection() = %q, true, want a miss", v)
+	}
+}
+
+func TestConcurrentDetection(t *testing.T) {
+	dir := tempDir(t)
+	stored := map[string]bool{"v2.14.3": true, "v2.16.12": true, "": true}
+	vs := []string{"v2.14.3", "v2.16.12", ""}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func(v string) {
+			defer wg.Done()
+			if err := storeDetection(dir, "staging", v); err != nil {
+				errs <- err
+			}
+		}(vs[i%len(vs)])
+		go func() {
+			defer wg.Done()
+			v, ok := cachedDetection(dir, "staging", time.Hour)
+			if ok && !stored[v] || !ok && v != "" {
+				errs <- fmt.Errorf("cachedDetection() = %q, %t, a partial entry", v, ok)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if got := names(t, detectDir(dir)); len(got) != 1 || got[0] != "staging" {
+		t.Errorf("detection cache holds %v, want only the staging entry", got)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+// eolDates are the end of life dates of helm release lines, by major version,
+// e.g. v2, or minor version, e.g. v3.5. The eol map of the config file adds to
+// and overrides them.
+var eolDates = map[string]string{
+	"v2": "2020-11-13",
+}
+
+// warnEOL warns, once per version, when helm v is past its end of life,
+// unless HELM_WRAPPER_SUPPRESS_EOL_WARN is set. It never stops v from running.
+func warnEOL(dir, v string) {
+	if envBool("HELM_WRAPPER_SUPPRESS_EOL_WARN") {
+		return
+	}
+
+	line, date, ok := eol(v)
+	if !ok || time.Now().Before(date) {
+		return
+	}
+
+	marker := filepath.Join(dir, fmt.Sprintf(".helm-%s.eol", v))
+	if _, err := os.Stat(marker); err == nil {
+		return
+	}
+
+	log.Printf("helm %s is end of life since %s, consider migrating to a supported helm version (set HELM_WRAPPER_SUPPRESS_EOL_WARN to silence this)", line, date.Format("2006-01-02"))
+
+	if f, err := os.Create(marker); err == nil {
+		f.Close()
+	}
+}
+
+// eol returns the release line helm v belongs to and its end of life date, if
+// known, preferring a minor version's date to its major version's.
+func eol(v string) (string, time.Time, bool) {
+	sv, err := version.ParseSemantic(v)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+
+	dates := map[string]string{}
+	for line, date := range eolDates {
+		dates[line] = date
+	}
+	for line, date := range settings.EOL {
+		dates[canonical(line)] = date
+	}
+
+	for _, line := range []string{fmt.Sprintf("v%d.%d", sv.Major(), sv.Minor()), fmt.Sprintf("v%d", sv.Major())} {
+		s, ok := dates[line]
+		if !ok {
+			continue
+		}
+
+		date, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			log.Printf("invalid end of life date %q for helm %s", s, line)
+			return "", time.Time{}, false
+		}
+
+		return line, date, true
+	}
+
+	return "", time.Time{}, false
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEOL(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   map[string]string
+		v        string
+		wantLine string
+		wantDate string
+	}{
+		{"helm 2", nil, "v2.16.12", "v2", "2020-11-13"},
+		{"helm 3", nil, "v3.4.0", "", ""},
+		{"minor line from the config", map[string]string{"3.4": "2021-01-13"}, "v3.4.0", "v3.4", "2021-01-13"},
+		{"minor over major", map[string]string{"v2.16": "2021-06-01"}, "v2.16.12", "v2.16", "2021-06-01"},
+		{"config over builtin", map[string]string{"v2": "2019-01-01"}, "v2.14.3", "v2", "2019-01-01"},
+		{"invalid date", map[string]string{"v3": "soon"}, "v3.4.0", "", ""},
+		{"not a version", nil, "latest", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := settings
+			t.Cleanup(func() { settings = old })
+			settings.EOL = tt.config
+
+			var line string
+			var date time.Time
+			var ok bool
+			captureStderr(t, func() { line, date, ok = eol(tt.v) })
+			if ok != (tt.wantLine != "") {
+				t.Fatalf("eol(%s) = %q, %v, %t, want %q", tt.v, line, date, ok, tt.wantLine)
+			}
+
+			if ok && (line != tt.wantLine || date.Format("2006-01-02") != tt.wantDate) {
+				t.Errorf("eol(%s) = %s, %s, want %s, %s", tt.v, line, date.Format("2006-01-02"), tt.wantLine, tt.wantDate)
+			}
+		})
+	}
+}
+
+func TestWarnEOL(t *testing.T) {
+	tests := []struct {
+		name     string
+		v        string
+		suppress string
+		config   map[string]string
+		want     bool
+	}{
+		{"eol", "v2.16.12", "", nil, true},
+		{"suppressed", "v2.16.12", "true", nil, false},
+		{"supported", "v3.4.0", "", nil, false},
+		{"eol in the future", "v3.4.0", "", map[string]string{"v3": "2999-01-01"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := settings
+			t.Cleanup(func() { settings = old })
+			settings.EOL = tt.config
+			setenv(t, "HELM_WRAPPER_SUPPRESS_EOL_WARN", tt.suppress)
+
+			dir := tempDir(t)
+			for run := 1; run <= 2; run++ {
+				got := captureStderr(t, func() { warnEOL(dir, tt.v) })
+
+				// Only the first run warns.
+				want := tt.want && run == 1
+				if strings.Contains(got, "is end of life since") != want {
+					t.Errorf("run %d warned %q, want a warning: %t", run, got, want)
+				}
+			}
+		})
+	}
+}
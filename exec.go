@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// run executes helm bin with args and the extra environment env, connecting it
+// to the wrapper's standard streams, and returns its exit code. Helm's stderr
+// reaches the user as is, so warnings printed by a successful command aren't
+// lost.
+func run(bin string, args, env []string) (int, error) {
+	cmd := exec.Command(bin, args...)
+	// The streams must stay *os.File: exec then hands their descriptors to
+	// helm rather than copying through pipes, so when they're a terminal helm
+	// gets the terminal itself, which editors and pagers it spawns need.
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	// Plugins call back into helm through HELM_BIN. Point it at the resolved
+	// binary rather than the wrapper, so nested calls run the same version
+	// without repeating Tiller detection.
+	cmd.Env = helmEnv(bin, env)
+
+	timeout, err := execTimeout()
+	if err != nil {
+		return 0, err
+	}
+
+	if timeout == 0 {
+		err = cmd.Run()
+	} else {
+		// Helm runs in its own process group, so that plugins and hooks it
+		// started are killed along with it, unless it's on a terminal:
+		// that would take it off the terminal's foreground, where editors
+		// and pagers it spawns need to be. Signals to the wrapper are
+		// passed on to the group.
+		group := !onTerminal()
+		if group {
+			setProcessGroup(cmd)
+		}
+		if err := cmd.Start(); err != nil {
+			return 0, err
+		}
+		if group {
+			defer forwardSignals(cmd)()
+		}
+
+		t := time.AfterFunc(timeout, func() {
+			if group {
+				killProcessGroup(cmd)
+			} else {
+				cmd.Process.Kill()
+			}
+		})
+		err = cmd.Wait()
+		if !t.Stop() {
+			return 0, withCode(exitTimeout, fmt.Errorf("helm didn't finish within HELM_WRAPPER_EXEC_TIMEOUT %s and was killed", timeout))
+		}
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return 0, nil
+}
+
+// isTerminal reports whether f is a terminal. It's a variable so that tests
+// can pretend it is.
+var isTerminal = func(f *os.File) bool {
+	return terminal.IsTerminal(int(f.Fd()))
+}
+
+// onTerminal reports whether the wrapper's stdin and stdout are a terminal,
+// which helm then gets too.
+func onTerminal() bool {
+	return isTerminal(os.Stdin) && isTerminal(os.Stdout)
+}
+
+// refetch downloads helm v into dir again when running it failed with err
+// because it vanished from the cache after it was resolved, e.g. removed by
+// hand or by another run's eviction, and reports whether it's back. It's off
+// with HELM_WRAPPER_NO_REFETCH, and for a system helm, which isn't the
+// wrapper's to download.
+func refetch(dir, v string, err error) bool {
+	if !os.IsNotExist(err) || envBool("HELM_WRAPPER_NO_REFETCH") {
+		return false
+	}
+
+	if _, ok := systemHelm(v); ok {
+		return false
+	}
+
+	// Helm being there means something else is missing, e.g. the
+	// interpreter of a script.
+	if _, err := os.Stat(newCache(dir).Get(v)); err == nil {
+		return false
+	}
+
+	log.Printf("helm %s vanished from the cache before it could run, downloading it again", v)
+	if err := ensure(v, dir); err != nil {
+		log.Printf("couldn't download helm %s again: %v", v, err)
+		return false
+	}
+
+	return true
+}
+
+// missingBin explains err if it's from helm v's binary bin missing.
+func missingBin(v, bin string, err error) error {
+	if _, statErr := os.Stat(bin); !os.IsNotExist(err) || statErr == nil {
+		return err
+	}
+
+	return fmt.Errorf("couldn't run helm %s, %s is missing (%v), check the cache with helm-wrapper fsck --repair", v, bin, err)
+}
+
+// helmEnv returns the environment helm bin runs with, the wrapper's own plus
+// env.
+func helmEnv(bin string, env []string) []string {
+	return append(append(os.Environ(), env...), "HELM_BIN="+bin)
+}
+
+// execTimeout returns how long helm may run before it's killed, set with
+// HELM_WRAPPER_EXEC_TIMEOUT. Without it there's no limit.
+func execTimeout() (time.Duration, error) {
+	s := os.Getenv("HELM_WRAPPER_EXEC_TIMEOUT")
+	if s == "" {
+		return 0, nil
+	}
+
+	timeout, err := time.ParseDuration(s)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("invalid HELM_WRAPPER_EXEC_TIMEOUT %q", s)
+	}
+
+	return timeout, nil
+}
+
+// childUmask returns the umask helm runs with, set in octal with
+// HELM_WRAPPER_CHILD_UMASK, e.g. 027, so that on shared hosts the files it
+// creates get the intended permissions. Without it helm inherits the
+// wrapper's umask, and ok is false.
+func childUmask() (mask int, ok bool, err error) {
+	s := os.Getenv("HELM_WRAPPER_CHILD_UMASK")
+	if s == "" {
+		return 0, false, nil
+	}
+
+	n, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || n > 0777 {
+		return 0, false, fmt.Errorf("invalid HELM_WRAPPER_CHILD_UMASK %q, must be an octal mode like 027", s)
+	}
+
+	return int(n), true, nil
+}
+
+// replaces reports whether the wrapper should replace itself with helm rather
+// than run it as a child, which is the default where that's supported. Nothing
+// can happen after helm exits then, so it's off with HELM_WRAPPER_AUDIT_LOG,
+// which records helm's exit code, HELM_WRAPPER_EXEC_TIMEOUT, which kills it,
+// tracing, which times it, or HELM_WRAPPER_NO_EXEC.
+func replaces() bool {
+	return os.Getenv("HELM_WRAPPER_AUDIT_LOG") == "" &&
+		os.Getenv("HELM_WRAPPER_EXEC_TIMEOUT") == "" &&
+		!tracing() &&
+		!envBool("HELM_WRAPPER_NO_EXEC")
+}
+
+// printCommand prints the command line helm bin is run with to stderr, quoted
+// for the shell, when HELM_WRAPPER_PRINT_COMMAND is set.
+func printCommand(bin string, args []string) {
+	if !envBool("HELM_WRAPPER_PRINT_COMMAND") {
+		return
+	}
+
+	quoted := []string{shellQuote(bin)}
+	for _, a := range args {
+		quoted = append(quoted, shellQuote(a))
+	}
+
+	fmt.Fprintln(log.Writer(), strings.Join(quoted, " "))
+}
+
+// shellQuote quotes s for POSIX shells, unless it's safe as is.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_=+.,/:@%") == "" {
+		return s
+	}
+
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
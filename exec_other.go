@@ -0,0 +1,35 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// canReplace reports whether replace is supported here.
+const canReplace = false
+
+// replace isn't supported here, so helm always runs as a child.
+func replace(bin string, args, env []string) error {
+	return errors.New("replacing the wrapper with helm isn't supported on this platform")
+}
+
+// setProcessGroup does nothing here.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills cmd, but not what it started.
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
+
+// forwardSignals does nothing here, as helm isn't in a group of its own.
+func forwardSignals(cmd *exec.Cmd) func() {
+	return func() {}
+}
+
+// setUmask isn't supported here.
+func setUmask(mask int) (func(), error) {
+	return nil, errors.New("HELM_WRAPPER_CHILD_UMASK isn't supported on this platform")
+}
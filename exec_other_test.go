@@ -0,0 +1,16 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package main
+
+import "testing"
+
+func TestReplace(t *testing.T) {
+	if canReplace {
+		t.Error("canReplace is set where replace isn't supported")
+	}
+
+	if err := replace("helm", []string{"version"}, nil); err == nil {
+		t.Error("replace() succeeded where it isn't supported")
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRefetch(t *testing.T) {
+	archive, sum := helmArchive(t, "#!/bin/sh\n")
+
+	tests := []struct {
+		name      string
+		err       error
+		present   bool
+		noRefetch bool
+		want      bool
+	}{
+		{"vanished", &os.PathError{Op: "fork/exec", Err: os.ErrNotExist}, false, false, true},
+		{"other failure", errors.New("permission denied"), false, false, false},
+		{"still there", &os.PathError{Op: "fork/exec", Err: os.ErrNotExist}, true, false, false},
+		{"disabled", &os.PathError{Op: "fork/exec", Err: os.ErrNotExist}, false, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTempScratch(t)
+			mirrorServer(t, map[string]string{
+				archiveName("v2.16.12"):             archive,
+				archiveName("v2.16.12") + ".sha256": sum,
+			})
+			setenv(t, "HELM_WRAPPER_NO_REFETCH", "")
+			if tt.noRefetch {
+				setenv(t, "HELM_WRAPPER_NO_REFETCH", "true")
+			}
+
+			dir := tempDir(t)
+			if tt.present {
+				if err := ioutil.WriteFile(binPath(dir, "v2.16.12"), nil, 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if got := refetch(dir, "v2.16.12", tt.err); got != tt.want {
+				t.Fatalf("refetch() = %t, want %t", got, tt.want)
+			}
+
+			if !tt.want {
+				return
+			}
+
+			b, err := ioutil.ReadFile(binPath(dir, "v2.16.12"))
+			if err != nil || string(b) != "#!/bin/sh\n" {
+				t.Errorf("refetched binary = %q, %v, want the archive's", b, err)
+			}
+
+			if err := missingBin("v2.16.12", binPath(dir, "v2.16.12"), tt.err); err != tt.err {
+				t.Errorf("missingBin() = %v once the binary is back, want the error as is", err)
+			}
+		})
+	}
+}
+
+func TestExecTimeout(t *testing.T) {
+	tests := []struct {
+		env     string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"90s", 90 * time.Second, false},
+		{"0", 0, false},
+		{"-1m", 0, true},
+		{"forever", 0, true},
+	}
+
+	for _, tt := range tests {
+		setenv(t, "HELM_WRAPPER_EXEC_TIMEOUT", tt.env)
+
+		got, err := execTimeout()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("execTimeout() of %q error = %v, wantErr %t", tt.env, err, tt.wantErr)
+			continue
+		}
+
+		if got != tt.want {
+			t.Errorf("execTimeout() of %q = %s, want %s", tt.env, got, tt.want)
+		}
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"install", "install"},
+		{"--set=image.tag=v1.2,replicas=3", "--set=image.tag=v1.2,replicas=3"},
+		{"", "''"},
+		{"two words", "'two words'"},
+		{"it's", `'it'\''s'`},
+		{"$HOME", "'$HOME'"},
+		{"a;rm -rf /", "'a;rm -rf /'"},
+	}
+
+	for _, tt := range tests {
+		if got := shellQuote(tt.s); got != tt.want {
+			t.Errorf("shellQuote(%q) = %s, want %s", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestPrintCommand(t *testing.T) {
+	args := []string{"upgrade", "web", "./chart", "--set", "motd=it's $HOME", ""}
+
+	tests := []struct {
+		name  string
+		print string
+		want  string
+	}{
+		{"off", "", ""},
+		{"on", "true", `/usr/local/bin/helm upgrade web ./chart --set 'motd=it'\''s $HOME' ''` + "\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setenv(t, "HELM_WRAPPER_PRINT_COMMAND", tt.print)
+
+			got := captureStderr(t, func() { printCommand("/usr/local/bin/helm", args) })
+			if got != tt.want {
+				t.Errorf("printCommand() printed %q, want %q", got, tt.want)
+			}
+			if got == "" {
+				return
+			}
+
+			// The printed command must give helm the same arguments when
+			// pasted into a shell.
+			script := "printf '%s\\n' " + strings.TrimPrefix(strings.TrimSpace(got), "/usr/local/bin/helm ")
+			out, err := exec.Command("sh", "-c", script).Output()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := strings.Join(args, "\n") + "\n"; string(out) != want {
+				t.Errorf("the shell split the command into %q, want %q", out, want)
+			}
+		})
+	}
+}
+
+func TestChildUmask(t *testing.T) {
+	tests := []struct {
+		env     string
+		want    int
+		wantOK  bool
+		wantErr bool
+	}{
+		{"", 0, false, false},
+		{"027", 027, true, false},
+		{"0077", 077, true, false},
+		{"0", 0, true, false},
+		{"999", 0, false, true},
+		{"1777", 0, false, true},
+		{"u=rwx", 0, false, true},
+	}
+
+	for _, tt := range tests {
+		setenv(t, "HELM_WRAPPER_CHILD_UMASK", tt.env)
+
+		got, ok, err := childUmask()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("childUmask() of %q error = %v, wantErr %t", tt.env, err, tt.wantErr)
+			continue
+		}
+
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("childUmask() of %q = %#o, %t, want %#o, %t", tt.env, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestReplaces(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		tracing bool
+		want    bool
+	}{
+		{"default", nil, false, true},
+		{"audit log", map[string]string{"HELM_WRAPPER_AUDIT_LOG": "/var/log/helm.log"}, false, false},
+		{"exec timeout", map[string]string{"HELM_WRAPPER_EXEC_TIMEOUT": "1m"}, false, false},
+		{"no exec", map[string]string{"HELM_WRAPPER_NO_EXEC": "true"}, false, false},
+		{"exec", map[string]string{"HELM_WRAPPER_NO_EXEC": "false"}, false, true},
+		{"tracing", nil, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"HELM_WRAPPER_AUDIT_LOG", "HELM_WRAPPER_EXEC_TIMEOUT", "HELM_WRAPPER_NO_EXEC", "OTEL_EXPORTER_OTLP_ENDPOINT", "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"} {
+				unsetenv(t, name)
+			}
+			for name, value := range tt.env {
+				setenv(t, name, value)
+			}
+			var e spanExporter
+			if tt.tracing {
+				e = &memoryExporter{}
+			}
+			withExporter(t, e)
+
+			if got := replaces(); got != tt.want {
+				t.Errorf("replaces() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
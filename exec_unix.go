@@ -0,0 +1,62 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+)
+
+// canReplace reports whether replace is supported here.
+const canReplace = true
+
+// replace replaces the wrapper with helm bin, so that signals, the terminal
+// and the exit code are helm's own, and only returns on failure.
+func replace(bin string, args, env []string) error {
+	return syscall.Exec(bin, append([]string{bin}, args...), helmEnv(bin, env))
+}
+
+// setProcessGroup makes cmd start in a process group of its own.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcessGroup kills the process group cmd leads.
+func killProcessGroup(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+// forwardSignals passes SIGINT and SIGTERM the wrapper gets on to the process
+// group cmd leads, which doesn't get them from the terminal or from CI
+// cancelling a job, until the returned func is called.
+func forwardSignals(cmd *exec.Cmd) func() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-sigs:
+				syscall.Kill(-cmd.Process.Pid, sig.(syscall.Signal))
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigs)
+		close(done)
+	}
+}
+
+// setUmask sets the wrapper's umask, which helm inherits whether it replaces
+// the wrapper or runs as a child, and returns a func restoring the old one.
+func setUmask(mask int) (func(), error) {
+	old := syscall.Umask(mask)
+	return func() { syscall.Umask(old) }, nil
+}
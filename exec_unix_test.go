@@ -0,0 +1,325 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRunProcessGroup(t *testing.T) {
+	tests := []struct {
+		name     string
+		tty      bool
+		timeout  string
+		ownGroup bool
+	}{
+		{"no timeout", false, "", false},
+		{"timeout", false, "1m", true},
+		{"timeout on a terminal", true, "1m", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pretendTerminal(t, tt.tty)
+			setenv(t, "HELM_WRAPPER_EXEC_TIMEOUT", tt.timeout)
+
+			dir := tempDir(t)
+			out := filepath.Join(dir, "pgid")
+			bin := filepath.Join(dir, "helm")
+			if err := ioutil.WriteFile(bin, []byte("#!/bin/sh\nps -o pgid= -p $$ > \"$PGID_FILE\"\n"), 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			code, err := run(bin, nil, []string{"PGID_FILE=" + out})
+			if err != nil || code != 0 {
+				t.Fatalf("run() = %d, %v", code, err)
+			}
+
+			b, err := ioutil.ReadFile(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pgid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := pgid != syscall.Getpgrp(); got != tt.ownGroup {
+				t.Errorf("helm ran in its own process group: %t, want %t", got, tt.ownGroup)
+			}
+		})
+	}
+}
+
+func TestOnTerminal(t *testing.T) {
+	f, err := ioutil.TempFile(tempDir(t), "stdin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if isTerminal(f) {
+		t.Error("isTerminal() of a file = true")
+	}
+
+	for _, tty := range []bool{false, true} {
+		pretendTerminal(t, tty)
+		if got := onTerminal(); got != tty {
+			t.Errorf("onTerminal() = %t, want %t", got, tty)
+		}
+	}
+}
+
+func TestHelmBinForPlugins(t *testing.T) {
+	tests := []struct {
+		name    string
+		inherit string
+	}{
+		{"unset", ""},
+		{"wrapper's own", "/usr/local/bin/helm"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setenv(t, "HELM_BIN", tt.inherit)
+
+			dir := tempDir(t)
+			out := filepath.Join(dir, "helm-bin")
+			bin := filepath.Join(dir, "helm")
+			if err := ioutil.WriteFile(bin, []byte("#!/bin/sh\nprintf '%s' \"$HELM_BIN\" > \"$OUT\"\n"), 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			code, err := run(bin, []string{"plugin", "list"}, []string{"OUT=" + out})
+			if err != nil || code != 0 {
+				t.Fatalf("run() = %d, %v", code, err)
+			}
+
+			b, err := ioutil.ReadFile(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(b) != bin {
+				t.Errorf("helm ran with HELM_BIN=%q, want %q", b, bin)
+			}
+		})
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   string
+		timeout  string
+		want     int
+		wantCode int
+	}{
+		{"no timeout", "exit 3", "", 3, 0},
+		{"in time", "exit 3", "1m", 3, 0},
+		{"killed", "sleep 30", "100ms", 0, exitTimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pretendTerminal(t, false)
+			setenv(t, "HELM_WRAPPER_EXEC_TIMEOUT", tt.timeout)
+
+			bin := filepath.Join(tempDir(t), "helm")
+			if err := ioutil.WriteFile(bin, []byte("#!/bin/sh\n"+tt.script+"\n"), 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			start := time.Now()
+			got, err := run(bin, nil, nil)
+			if code := exitCode(err); code != tt.wantCode {
+				t.Fatalf("run() error = %v, want exit code %d", err, tt.wantCode)
+			}
+			if got != tt.want {
+				t.Errorf("run() = %d, want %d", got, tt.want)
+			}
+
+			if time.Since(start) > 10*time.Second {
+				t.Errorf("run() took %s, helm wasn't killed", time.Since(start))
+			}
+		})
+	}
+}
+
+func TestRunTimeoutKillsChildren(t *testing.T) {
+	pretendTerminal(t, false)
+	setenv(t, "HELM_WRAPPER_EXEC_TIMEOUT", "200ms")
+
+	dir := tempDir(t)
+	out := filepath.Join(dir, "pid")
+	bin := filepath.Join(dir, "helm")
+	// Like a plugin or hook helm started, which outlives a kill of helm alone.
+	script := "#!/bin/sh\nsleep 30 &\necho $! > \"$PID_FILE\"\nwait\n"
+	if err := ioutil.WriteFile(bin, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := run(bin, nil, []string{"PID_FILE=" + out}); exitCode(err) != exitTimeout {
+		t.Fatalf("run() error = %v, want a timeout", err)
+	}
+
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The killed child is reaped by init, which may take a moment.
+	for i := 0; i < 50; i++ {
+		if syscall.Kill(pid, 0) != nil {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	syscall.Kill(pid, syscall.SIGKILL)
+	t.Errorf("helm's child %d outlived the timeout", pid)
+}
+
+func TestSetUmask(t *testing.T) {
+	tests := []struct {
+		mask int
+		want os.FileMode
+	}{
+		{022, 0644},
+		{027, 0640},
+		{077, 0600},
+	}
+
+	for _, tt := range tests {
+		t.Run(strconv.FormatInt(int64(tt.mask), 8), func(t *testing.T) {
+			before := syscall.Umask(022)
+			syscall.Umask(before)
+
+			dir := tempDir(t)
+			created := filepath.Join(dir, "values.yaml")
+			bin := filepath.Join(dir, "helm")
+			if err := ioutil.WriteFile(bin, []byte("#!/bin/sh\ntouch \"$CREATED\"\n"), 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			restore, err := setUmask(tt.mask)
+			if err != nil {
+				t.Fatal(err)
+			}
+			code, err := run(bin, nil, []string{"CREATED=" + created})
+			restore()
+			if err != nil || code != 0 {
+				t.Fatalf("run() = %d, %v", code, err)
+			}
+
+			fi, err := os.Stat(created)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi.Mode().Perm() != tt.want {
+				t.Errorf("helm created a file with mode %v, want %v", fi.Mode().Perm(), tt.want)
+			}
+
+			if after := syscall.Umask(before); after != before {
+				t.Errorf("umask after restoring = %#o, want %#o", after, before)
+			}
+		})
+	}
+}
+
+func TestRunForwardsSignals(t *testing.T) {
+	pretendTerminal(t, false)
+	setenv(t, "HELM_WRAPPER_EXEC_TIMEOUT", "30s")
+
+	// Keep the test binary alive when signalling it.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	dir := tempDir(t)
+	started := filepath.Join(dir, "started")
+	forwarded := filepath.Join(dir, "forwarded")
+	bin := filepath.Join(dir, "helm")
+	// Like a hook helm started, which gets the signal through the group.
+	script := `#!/bin/sh
+sh -c 'trap "touch \"$FORWARDED\"; exit" TERM; while :; do sleep 0.1; done' &
+touch "$STARTED"
+wait
+`
+	if err := ioutil.WriteFile(bin, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for i := 0; i < 100; i++ {
+			if _, err := os.Stat(started); err == nil {
+				break
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		// Let the hook set up its trap.
+		time.Sleep(200 * time.Millisecond)
+		syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	}()
+
+	start := time.Now()
+	code, err := run(bin, nil, []string{"STARTED=" + started, "FORWARDED=" + forwarded})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code == 0 || time.Since(start) > 10*time.Second {
+		t.Errorf("run() = %d after %s, want helm terminated by the signal", code, time.Since(start))
+	}
+
+	for i := 0; i < 50; i++ {
+		if _, err := os.Stat(forwarded); err == nil {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Error("helm's child didn't get the signal sent to the wrapper")
+}
+
+func TestReplace(t *testing.T) {
+	// Replacing the test binary itself would end the tests, so it's done in
+	// a child running just this test.
+	if bin := os.Getenv("HELM_WRAPPER_TEST_REPLACE"); bin != "" {
+		err := replace(bin, []string{"status", "web"}, []string{"FROM_WRAPPER=yes"})
+		os.Stderr.WriteString(err.Error())
+		os.Exit(2)
+	}
+
+	bin := filepath.Join(tempDir(t), "helm")
+	script := "#!/bin/sh\necho \"$$ $* $FROM_WRAPPER $HELM_BIN\"\nexit 7\n"
+	if err := ioutil.WriteFile(bin, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestReplace$")
+	cmd.Env = append(os.Environ(), "HELM_WRAPPER_TEST_REPLACE="+bin)
+	out, err := cmd.Output()
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok || exitErr.ExitCode() != 7 {
+		t.Fatalf("the replaced wrapper ended with %v, want helm's exit status 7", err)
+	}
+
+	want := strconv.Itoa(cmd.Process.Pid) + " status web yes " + bin + "\n"
+	if string(out) != want {
+		t.Errorf("helm printed %q, want %q from the wrapper's own process", out, want)
+	}
+}
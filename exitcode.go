@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+)
+
+// Exit codes of failures of the wrapper itself, kept apart from helm's own,
+// which are 0 and 1, so scripts can tell the two apart.
+const (
+	// exitInternal is for failures not covered below, e.g. of management
+	// commands.
+	exitInternal = 70
+	// exitConfig is for an invalid config file, environment or cache
+	// directory.
+	exitConfig = 71
+	// exitResolve is for failing to decide which helm version to run, e.g.
+	// when Tiller detection fails.
+	exitResolve = 72
+	// exitDownload is for failing to download, verify or unpack helm.
+	exitDownload = 73
+	// exitExec is for failing to start helm.
+	exitExec = 74
+	// exitTimeout is for helm killed after HELM_WRAPPER_EXEC_TIMEOUT.
+	exitTimeout = 75
+	// exitRefused is for commands against a protected context that weren't
+	// confirmed.
+	exitRefused = 76
+	// exitDenied is for commands forbidden by HELM_WRAPPER_DENY_COMMANDS.
+	exitDenied = 77
+)
+
+// codedError is an error with the exit code it should end the wrapper with.
+type codedError struct {
+	code int
+	err  error
+}
+
+func (e *codedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *codedError) Unwrap() error {
+	return e.err
+}
+
+// withCode returns err with the exit code code, unless it's nil or already has
+// one.
+func withCode(code int, err error) error {
+	var ce *codedError
+	if err == nil || errors.As(err, &ce) {
+		return err
+	}
+
+	return &codedError{code: code, err: err}
+}
+
+// fatal logs err and exits with its exit code, or exitInternal if it has none.
+func fatal(err error) {
+	log.Println(err)
+	flushTraces()
+
+	var ce *codedError
+	if errors.As(err, &ce) {
+		os.Exit(ce.code)
+	}
+
+	os.Exit(exitInternal)
+}
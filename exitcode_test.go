@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestExitCodes(t *testing.T) {
+	archive, sum := helmArchive(t, "#!/bin/sh\necho 'Client: v2.16.12+g47f0b88'\n")
+	name := archiveName("v2.16.12")
+
+	tests := []struct {
+		name    string
+		files   map[string]string
+		size    string
+		detect  bool
+		want    int
+		wantErr string
+	}{
+		{"download", nil, "", false, exitDownload, "404 Not Found"},
+		{"checksum", map[string]string{name: archive, name + ".sha256": sumB}, "", false, exitDownload, "checksum mismatch"},
+		{"space", map[string]string{name + ".sha256": sum}, "1152921504606846976", false, exitDownload, "insufficient disk space"},
+		{"detection", map[string]string{name: archive, name + ".sha256": sum}, "", true, exitResolve, "connection refused"},
+		{"success", map[string]string{name: archive, name + ".sha256": sum}, "", false, 0, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTempScratch(t)
+			resetRetries(t)
+			setenv(t, "HELM_WRAPPER_RETRY_DELAY", "0s")
+			setenv(t, "HELM_WRAPPER_USE_SYSTEM_HELM", "false")
+			srv := mirrorServer(t, tt.files)
+			settings.Version = "v2.16.12"
+			if tt.size != "" {
+				if _, ok := freeSpace(scratch); !ok {
+					t.Skip("free space can't be told on this platform")
+				}
+				// An archive larger than any disk.
+				srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if strings.TrimPrefix(r.URL.Path, "/") != name {
+						http.NotFound(w, r)
+						return
+					}
+					w.Header().Set("Content-Length", tt.size)
+				})
+			}
+
+			clientset := fake.NewSimpleClientset()
+			clientset.PrependReactor("list", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+				return true, nil, errors.New("connection refused")
+			})
+			old := newClientset
+			t.Cleanup(func() { newClientset = old })
+			newClientset = func(string) (kubernetes.Interface, error) { return clientset, nil }
+
+			args := []string{"+v2.16.12", "upgrade", "web", "stable/web"}
+			if tt.detect {
+				args = args[1:]
+			}
+
+			_, _, err := resolveArgs(tempDir(t), args)
+			if (err == nil) != (tt.wantErr == "") || err != nil && !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("resolveArgs() error = %v, want %q", err, tt.wantErr)
+			}
+
+			if got := exitCode(err); got != tt.want {
+				t.Errorf("resolveArgs() exit code = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// unTarZip extracts the helm binary from the downloaded helm v archive into
+// cache, or with HELM_WRAPPER_VERSION_DIRS the archive's whole platform
+// directory into a directory of its own in the filesystem cache.
+func unTarZip(v string, cache Cache) (err error) {
+	s := startSpan("extract")
+	s.set("helm.version", v)
+	defer func() { s.finish(err) }()
+
+	f, err := os.Open(archivePath(v))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(archivePath(v))
+	defer f.Close()
+
+	if fc, ok := cache.(fsCache); ok && versionDirs() {
+		return fc.putDir(v, f)
+	}
+
+	return extractBin(f, func(r io.Reader) error {
+		return cache.Put(v, r)
+	})
+}
+
+// extractBin calls fn with the helm binary inside archive f. Archives without
+// an exact match, e.g. repackaged with the binary named helm-v2.16.7, are
+// walked again for their sole helm-prefixed executable.
+func extractBin(f *os.File, fn func(io.Reader) error) error {
+	var found bool
+	var candidates binCandidates
+	err := walkArchive(f, func(name string, mode os.FileMode, r io.Reader) error {
+		if name != binEntry() {
+			candidates.add(name, mode)
+			return nil
+		}
+
+		found = true
+		return fn(r)
+	})
+	if err != nil || found {
+		return err
+	}
+
+	bin, err := candidates.pick()
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	return walkArchive(f, func(name string, _ os.FileMode, r io.Reader) error {
+		if name != bin {
+			return nil
+		}
+
+		return fn(r)
+	})
+}
+
+// binCandidates are the files in the platform directory of an archive that
+// may be a helm binary under another name.
+type binCandidates []string
+
+// add adds the archive entry name if it's an executable whose name starts
+// with helm, or on Windows, where archives don't carry the executable bit, a
+// .exe.
+func (c *binCandidates) add(name string, mode os.FileMode) {
+	base := path.Base(name)
+	if path.Dir(name) != entryDir() || !strings.HasPrefix(base, "helm") {
+		return
+	}
+
+	if mode&0111 != 0 || host.os == "windows" && strings.HasSuffix(base, ".exe") {
+		*c = append(*c, name)
+	}
+}
+
+// pick returns the sole candidate, logging that it's used.
+func (c binCandidates) pick() (string, error) {
+	switch len(c) {
+	case 0:
+		return "", fmt.Errorf("helm binary not found in archive for %s/%s, archive layout may have changed", host.os, host.arch)
+	case 1:
+		log.Printf("%s not found in the helm archive, using %s", binEntry(), c[0])
+		return c[0], nil
+	}
+
+	return "", fmt.Errorf("helm binary not found in archive for %s/%s, and it's ambiguous which of %s it is", host.os, host.arch, strings.Join(c, ", "))
+}
+
+// walkArchive calls fn with the name, mode and contents of each regular file
+// in archive f. The archive may be a gzipped tarball or a zip file, as published
+// for Windows, which is told apart by its magic bytes.
+func walkArchive(f *os.File, fn func(name string, mode os.FileMode, r io.Reader) error) error {
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return fmt.Errorf("couldn't read helm archive: %v", err)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	switch {
+	case bytes.Equal(magic, []byte("PK\x03\x04")):
+		return unZip(f, fn)
+	case bytes.Equal(magic[:2], []byte{0x1f, 0x8b}):
+		return unTar(f, fn)
+	}
+
+	return fmt.Errorf("helm archive is neither a tar.gz nor a zip file")
+}
+
+// binEntry returns the name of the helm binary inside release archives.
+func binEntry() string {
+	return path.Join(entryDir(), binName())
+}
+
+// entryDir returns the directory holding the files for the host platform
+// inside release archives.
+func entryDir() string {
+	return fmt.Sprintf("%s-%s", host.os, host.arch)
+}
+
+// binName returns the file name of the helm binary.
+func binName() string {
+	if host.os == "windows" {
+		return "helm.exe"
+	}
+
+	return "helm"
+}
+
+func unTar(r io.Reader, fn func(string, os.FileMode, io.Reader) error) error {
+	archive, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	tr := tar.NewReader(archive)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if err := fn(header.Name, header.FileInfo().Mode(), tr); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func unZip(f *os.File, fn func(string, os.FileMode, io.Reader) error) error {
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	zr, err := zip.NewReader(f, fi.Size())
+	if err != nil {
+		return err
+	}
+
+	for _, zf := range zr.File {
+		if !zf.Mode().IsRegular() {
+			continue
+		}
+
+		rc, err := zf.Open()
+		if err != nil {
+			return err
+		}
+
+		err = fn(zf.Name, zf.Mode(), rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// versionDirs reports whether each helm version is kept in a directory of its
+// own, along with the other files of its archive, as set with
+// HELM_WRAPPER_VERSION_DIRS. Helm still runs in the user's working directory.
+func versionDirs() bool {
+	return envBool("HELM_WRAPPER_VERSION_DIRS")
+}
+
+// extractDir extracts the files for the host platform in archive f into the
+// directory of helm v in dir. They're extracted into a partial directory
+// renamed into place once complete.
+func extractDir(f *os.File, v, dir string) error {
+	tmp, err := ioutil.TempDir(dir, fmt.Sprintf("helm-%s-*.part", v))
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	var found bool
+	var candidates binCandidates
+	err = walkArchive(f, func(name string, fmode os.FileMode, r io.Reader) error {
+		rel := strings.TrimPrefix(path.Clean(name), entryDir()+"/")
+		if rel == path.Clean(name) || strings.HasPrefix(rel, "../") {
+			return nil
+		}
+
+		dst := filepath.Join(tmp, filepath.FromSlash(rel))
+		if err := dirs(filepath.Dir(dst)); err != nil {
+			return err
+		}
+
+		mode := os.FileMode(0644)
+		if name == binEntry() {
+			found = true
+			mode = 0755
+		} else {
+			candidates.add(name, fmode)
+		}
+
+		out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+
+		if _, err := copyBuffered(out, r); err != nil {
+			return err
+		}
+
+		return out.Close()
+	})
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		bin, err := candidates.pick()
+		if err != nil {
+			return err
+		}
+
+		rel := filepath.FromSlash(strings.TrimPrefix(bin, entryDir()+"/"))
+		if err := os.Rename(filepath.Join(tmp, rel), filepath.Join(tmp, binName())); err != nil {
+			return err
+		}
+
+		if err := os.Chmod(filepath.Join(tmp, binName()), 0755); err != nil {
+			return err
+		}
+	}
+
+	// Another run may have extracted the same version meanwhile.
+	err = os.Rename(tmp, filepath.Dir(binPath(dir, v)))
+	if err != nil {
+		if _, statErr := os.Stat(binPath(dir, v)); statErr == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+// writeBin writes the helm v binary read from r into dir.
+func writeBin(r io.Reader, v, dir string) error {
+	// The binary is written to a partial file that's made executable before
+	// it's renamed into place, so the cache never holds a truncated or
+	// non-executable helm, even if the wrapper is killed halfway.
+	ofile, err := ioutil.TempFile(dir, fmt.Sprintf("helm-%s-*.part", v))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(ofile.Name())
+	defer ofile.Close()
+
+	if _, err := copyBuffered(ofile, r); err != nil {
+		return err
+	}
+
+	if err := ofile.Chmod(0755); err != nil {
+		return err
+	}
+
+	if err := ofile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(ofile.Name(), binPath(dir, v))
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// tarGz returns a helm style archive holding files, by name, with executable
+// ones marked by a trailing "*" on their name.
+func tarGz(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for _, name := range names {
+		mode := int64(0644)
+		if strings.HasSuffix(name, "*") {
+			mode = 0755
+		}
+
+		body := files[name]
+		hdr := &tar.Header{Name: strings.TrimSuffix(name, "*"), Mode: mode, Size: int64(len(body)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// helmArchive returns the release archive of a helm binary with contents bin
+// for the host platform, and its hex sha256.
+func helmArchive(t *testing.T, bin string) (string, string) {
+	t.Helper()
+
+	b := tarGz(t, map[string]string{binEntry() + "*": bin, entryDir() + "/LICENSE": "license"})
+	sum := sha256.Sum256(b)
+	return string(b), hex.EncodeToString(sum[:])
+}
+
+// useTempScratch downloads archives to a directory of their own for the rest
+// of t.
+func useTempScratch(t *testing.T) {
+	old := scratch
+	t.Cleanup(func() { scratch = old })
+	scratch = tempDir(t)
+}
+
+func TestExtractBin(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		want    string
+		wantErr string
+	}{
+		{"release", map[string]string{binEntry() + "*": "helm", entryDir() + "/README.md": "readme"}, "helm", ""},
+		{"renamed", map[string]string{entryDir() + "/helm-v2.16.7*": "renamed", entryDir() + "/helm.md": "docs"}, "renamed", ""},
+		{"other platform", map[string]string{"plan9-mips/helm*": "helm"}, "", "helm binary not found in archive for " + host.String() + ", archive layout may have changed"},
+		{"not executable", map[string]string{entryDir() + "/helm-v2.16.7": "renamed"}, "", "archive layout may have changed"},
+		{"ambiguous", map[string]string{entryDir() + "/helm2*": "2", entryDir() + "/helm3*": "3"}, "", "ambiguous which of " + entryDir() + "/helm2, " + entryDir() + "/helm3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(tempDir(t), "archive.tar.gz")
+			if err := ioutil.WriteFile(path, tarGz(t, tt.files), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			var got string
+			err = extractBin(f, func(r io.Reader) error {
+				b, err := ioutil.ReadAll(r)
+				got = string(b)
+				return err
+			})
+			if err == nil && tt.wantErr != "" || err != nil && (tt.wantErr == "" || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("extractBin() error = %v, want %q", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("extractBin() found %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnTarZip(t *testing.T) {
+	tests := []struct {
+		name        string
+		versionDirs bool
+		want        []string
+	}{
+		{"binary", false, []string{".helm-v2.16.12.used", ".manifest.json", "helm-v2.16.12"}},
+		{"version dirs", true, []string{".helm-v2.16.12.used", ".manifest.json", "helm-v2.16.12"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTempScratch(t)
+			setenv(t, "HELM_WRAPPER_VERSION_DIRS", strconv.FormatBool(tt.versionDirs))
+
+			archive, _ := helmArchive(t, "#!/bin/sh\n")
+			if err := ioutil.WriteFile(archivePath("v2.16.12"), []byte(archive), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			dir := tempDir(t)
+			if err := unTarZip("v2.16.12", newCache(dir)); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := names(t, dir); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("cache holds %v, want %v", got, tt.want)
+			}
+
+			b, err := ioutil.ReadFile(binPath(dir, "v2.16.12"))
+			if err != nil || string(b) != "#!/bin/sh\n" {
+				t.Errorf("binary = %q, %v, want the archive's", b, err)
+			}
+
+			if _, err := os.Stat(archivePath("v2.16.12")); !os.IsNotExist(err) {
+				t.Errorf("archive left behind: %v", err)
+			}
+		})
+	}
+}
+
+// zipFile returns a zip archive holding files, by name, as helm publishes for
+// Windows.
+func zipFile(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range names {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(files[name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestWalkArchive(t *testing.T) {
+	files := map[string]string{"windows-amd64/helm.exe": "helm", "windows-amd64/LICENSE": "license"}
+
+	tests := []struct {
+		name    string
+		archive []byte
+		want    string
+		wantErr bool
+	}{
+		{"tar.gz", tarGz(t, files), "windows-amd64/LICENSE=license,windows-amd64/helm.exe=helm", false},
+		{"zip", zipFile(t, files), "windows-amd64/LICENSE=license,windows-amd64/helm.exe=helm", false},
+		{"neither", []byte("<html>not found</html>"), "", true},
+		{"empty", nil, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(tempDir(t), "archive")
+			if err := ioutil.WriteFile(path, tt.archive, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			var got []string
+			err = walkArchive(f, func(name string, _ os.FileMode, r io.Reader) error {
+				b, err := ioutil.ReadAll(r)
+				got = append(got, name+"="+string(b))
+				return err
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("walkArchive() error = %v, wantErr %t", err, tt.wantErr)
+			}
+
+			if s := strings.Join(got, ","); s != tt.want {
+				t.Errorf("walkArchive() walked %s, want %s", s, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractBinWindows(t *testing.T) {
+	forceHost(t, platform{"windows", "amd64"})
+
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{"release", map[string]string{"windows-amd64/helm.exe": "helm", "windows-amd64/README.md": "readme"}, "helm"},
+		{"renamed", map[string]string{"windows-amd64/helm-v3.4.0.exe": "renamed", "windows-amd64/helm.md": "docs"}, "renamed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(tempDir(t), "archive.zip")
+			if err := ioutil.WriteFile(path, zipFile(t, tt.files), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			var got string
+			err = extractBin(f, func(r io.Reader) error {
+				b, err := ioutil.ReadAll(r)
+				got = string(b)
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tt.want {
+				t.Errorf("extractBin() found %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// observedReader reads a binary in chunks, calling observe before each, like
+// another run looking at the cache while it's written.
+type observedReader struct {
+	chunks  []string
+	err     error
+	observe func()
+}
+
+func (r *observedReader) Read(p []byte) (int, error) {
+	r.observe()
+	if len(r.chunks) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		return 0, io.EOF
+	}
+
+	n := copy(p, r.chunks[0])
+	r.chunks[0] = r.chunks[0][n:]
+	if r.chunks[0] == "" {
+		r.chunks = r.chunks[1:]
+	}
+
+	return n, nil
+}
+
+func TestWriteBinIsAtomic(t *testing.T) {
+	tests := []struct {
+		name string
+		// err interrupts the write after all chunks, like the wrapper
+		// being killed halfway.
+		err  error
+		want bool
+	}{
+		{"complete", nil, true},
+		{"interrupted", errors.New("connection reset"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := tempDir(t)
+			path := binPath(dir, "v2.16.12")
+
+			var observed int
+			r := &observedReader{
+				chunks: []string{"#!/bin/sh\n", "echo helm\n", "exit 0\n"},
+				err:    tt.err,
+				observe: func() {
+					observed++
+					if fi, err := os.Stat(path); err == nil {
+						t.Errorf("cache holds helm mid-write, mode %v, before it's complete", fi.Mode())
+					}
+				},
+			}
+
+			err := writeBin(r, "v2.16.12", dir)
+			if (err == nil) != tt.want {
+				t.Fatalf("writeBin() error = %v, want success %t", err, tt.want)
+			}
+			if observed < 3 {
+				t.Fatalf("cache observed %d times during the write, want every chunk", observed)
+			}
+
+			fi, err := os.Stat(path)
+			if !tt.want {
+				if err == nil {
+					t.Errorf("interrupted write left helm in the cache")
+				}
+				if got := names(t, dir); len(got) != 0 {
+					t.Errorf("interrupted write left %v behind", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if fi.Mode().Perm() != 0755 {
+				t.Errorf("cached helm has mode %v, want executable", fi.Mode())
+			}
+			if b, _ := ioutil.ReadFile(path); string(b) != "#!/bin/sh\necho helm\nexit 0\n" {
+				t.Errorf("cached helm = %q, want all of it", b)
+			}
+		})
+	}
+}
+
+// tree returns the files under dir, by slash separated path relative to it,
+// with executables marked by a trailing "*".
+func tree(t *testing.T, dir string) []string {
+	t.Helper()
+
+	var files []string
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&0111 != 0 {
+			rel += "*"
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+
+	return files
+}
+
+func TestExtractDir(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  []string
+	}{
+		{
+			name: "release",
+			files: map[string]string{
+				binEntry() + "*":                    "helm",
+				entryDir() + "/LICENSE":             "license",
+				entryDir() + "/scripts/completion*": "completion",
+			},
+			want: []string{"helm-v2.16.12/LICENSE", "helm-v2.16.12/helm*", "helm-v2.16.12/scripts/completion"},
+		},
+		{
+			name: "other platforms and escapes",
+			files: map[string]string{
+				binEntry() + "*":                 "helm",
+				"plan9-mips/helm*":               "plan9",
+				entryDir() + "/../../escaped":    "escaped",
+				"README.md":                      "readme",
+				entryDir() + "/docs/../NOTES.md": "notes",
+			},
+			want: []string{"helm-v2.16.12/NOTES.md", "helm-v2.16.12/helm*"},
+		},
+		{
+			name: "renamed",
+			files: map[string]string{
+				entryDir() + "/helm-v2.16.12*": "helm",
+				entryDir() + "/LICENSE":        "license",
+			},
+			want: []string{"helm-v2.16.12/LICENSE", "helm-v2.16.12/helm*"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setenv(t, "HELM_WRAPPER_VERSION_DIRS", "true")
+
+			path := filepath.Join(tempDir(t), "archive.tar.gz")
+			if err := ioutil.WriteFile(path, tarGz(t, tt.files), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			root := tempDir(t)
+			dir := filepath.Join(root, "cache")
+			if err := os.Mkdir(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := extractDir(f, "v2.16.12", dir); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := tree(t, root); strings.Join(got, ",") != "cache/"+strings.Join(tt.want, ",cache/") {
+				t.Errorf("extracted %v, want %v in the cache", got, tt.want)
+			}
+
+			if b, err := ioutil.ReadFile(binPath(dir, "v2.16.12")); err != nil || string(b) != "helm" {
+				t.Errorf("binary = %q, %v, want the archive's helm", b, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package main
+
+// freeSpace can't tell the free space on this platform.
+func freeSpace(path string) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,17 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package main
+
+import "syscall"
+
+// freeSpace returns the space available to unprivileged users on the
+// filesystem of path, and whether it could be determined.
+func freeSpace(path string) (uint64, bool) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, false
+	}
+
+	return uint64(st.Bavail) * uint64(st.Bsize), true
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// manifestEntry records what a cached helm binary looked like when it was
+// extracted.
+type manifestEntry struct {
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// manifestMu serialises this run's updates of the cache manifest.
+var manifestMu sync.Mutex
+
+// manifestPath returns the cache manifest of dir, mapping cache keys to their
+// manifestEntry.
+func manifestPath(dir string) string {
+	return filepath.Join(dir, ".manifest.json")
+}
+
+func readManifest(dir string) (map[string]manifestEntry, error) {
+	m := map[string]manifestEntry{}
+	b, err := ioutil.ReadFile(manifestPath(dir))
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("invalid cache manifest %s: %v", manifestPath(dir), err)
+	}
+
+	return m, nil
+}
+
+// updateManifest applies fn to the cache manifest of dir and writes it back
+// through a temporary file renamed into place.
+func updateManifest(dir string, fn func(map[string]manifestEntry)) error {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+
+	m, err := readManifest(dir)
+	if err != nil {
+		return err
+	}
+	fn(m)
+
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, ".manifest-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := tmp.Write(b); err != nil {
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), manifestPath(dir))
+}
+
+// record adds the cached helm v binary in dir to the cache manifest.
+func record(dir, v string) error {
+	fi, err := os.Stat(binPath(dir, v))
+	if err != nil {
+		return err
+	}
+
+	sum, err := hashFile(binPath(dir, v))
+	if err != nil {
+		return err
+	}
+
+	return updateManifest(dir, func(m map[string]manifestEntry) {
+		m[cacheKey(v)] = manifestEntry{Size: fi.Size(), SHA256: sum}
+	})
+}
+
+// forget removes helm v from the cache manifest of dir.
+func forget(dir, v string) error {
+	return updateManifest(dir, func(m map[string]manifestEntry) {
+		delete(m, cacheKey(v))
+	})
+}
+
+// fsck checks every helm binary for the host platform in the cache manifest
+// against its recorded size and checksum and, with --repair, downloads missing
+// and corrupt ones again. Binaries cached before the manifest existed are
+// reported as untracked, and recorded with --repair.
+func fsck(dir string, args []string) error {
+	fs := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	repair := fs.Bool("repair", false, "download missing and corrupt binaries again")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	m, err := readManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	vs, err := cached(dir)
+	if err != nil {
+		return err
+	}
+
+	status := map[string]string{}
+	paths := map[string]string{}
+	for key := range m {
+		if v, p := splitKey(key); p == host {
+			status[v] = "MISSING"
+		}
+	}
+	for _, c := range vs {
+		paths[c.Version] = c.Path
+		want, ok := m[cacheKey(c.Version)]
+		if !ok {
+			status[c.Version] = "UNTRACKED"
+			continue
+		}
+
+		bin := c.Path
+		if versionDirs() {
+			bin = filepath.Join(c.Path, binName())
+		}
+
+		fi, err := os.Stat(bin)
+		var sum string
+		if err == nil && fi.Size() == want.Size {
+			sum, err = hashFile(bin)
+		}
+		switch {
+		case os.IsNotExist(err):
+		case err != nil:
+			status[c.Version] = fmt.Sprintf("UNREADABLE (%v)", err)
+		case sum != want.SHA256:
+			status[c.Version] = "CORRUPT"
+		default:
+			status[c.Version] = "OK"
+		}
+	}
+
+	var names []string
+	for v := range status {
+		names = append(names, v)
+	}
+	sort.Strings(names)
+
+	var bad int
+	for _, v := range names {
+		s := status[v]
+		if s == "OK" || s == "UNTRACKED" && !*repair {
+			fmt.Printf("helm %s: %s\n", v, s)
+			continue
+		}
+
+		if !*repair {
+			fmt.Printf("helm %s: %s\n", v, s)
+			bad++
+			continue
+		}
+
+		if s == "UNTRACKED" {
+			err = record(dir, v)
+		} else if err = os.RemoveAll(paths[v]); err == nil {
+			err = ensure(v, dir)
+		}
+		if err != nil {
+			fmt.Printf("helm %s: %s, repair failed: %v\n", v, s, err)
+			bad++
+			continue
+		}
+		fmt.Printf("helm %s: %s, repaired\n", v, s)
+	}
+
+	if bad != 0 {
+		return fmt.Errorf("%d cached versions are missing or corrupt", bad)
+	}
+
+	return nil
+}
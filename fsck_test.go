@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFsck(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "check",
+			want: []string{
+				"helm v2.14.3: UNTRACKED",
+				"helm v2.16.12: OK",
+				"helm v3.3.4: MISSING",
+				"helm v3.4.0: CORRUPT",
+			},
+			wantErr: true,
+		},
+		{
+			name: "repair",
+			args: []string{"--repair"},
+			want: []string{
+				"helm v2.14.3: UNTRACKED, repaired",
+				"helm v2.16.12: OK",
+				"helm v3.3.4: MISSING, repaired",
+				"helm v3.4.0: CORRUPT, repaired",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTempScratch(t)
+			setenv(t, "HELM_WRAPPER_USE_SYSTEM_HELM", "false")
+			archive, sum := helmArchive(t, "#!/bin/sh\n")
+			files := map[string]string{}
+			for _, v := range []string{"v3.3.4", "v3.4.0"} {
+				files[archiveName(v)] = archive
+				files[archiveName(v)+".sha256"] = sum
+			}
+			mirrorServer(t, files)
+
+			dir := tempDir(t)
+			for _, v := range []string{"v2.14.3", "v2.16.12", "v3.3.4", "v3.4.0"} {
+				if err := ioutil.WriteFile(binPath(dir, v), []byte("#!/bin/sh\n"), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if v == "v2.14.3" {
+					continue
+				}
+				if err := record(dir, v); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.Remove(binPath(dir, "v3.3.4")); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(binPath(dir, "v3.4.0"), []byte("#!/bin/sh\nexit 1\n"), 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			var err error
+			out := captureStdout(t, func() { err = fsck(dir, tt.args) })
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("fsck() error = %v, wantErr %t", err, tt.wantErr)
+			}
+
+			if got := strings.Split(strings.TrimSpace(out), "\n"); strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("fsck() printed\n%s\nwant\n%s", out, strings.Join(tt.want, "\n"))
+			}
+
+			if !strings.Contains(tt.name, "repair") {
+				return
+			}
+
+			out = captureStdout(t, func() { err = fsck(dir, nil) })
+			if err != nil || strings.Count(out, ": OK") != 4 {
+				t.Errorf("fsck() after repairing = %v, printed\n%s", err, out)
+			}
+		})
+	}
+}
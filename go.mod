@@ -4,10 +4,12 @@ go 1.14
 
 require (
 	github.com/imdario/mergo v0.3.9 // indirect
+	golang.org/x/crypto v0.0.0-20200220183623-bac4c82f6975
 	golang.org/x/oauth2 v0.0.0-20200107190931-bf48bf16ab8d // indirect
-	golang.org/x/time v0.0.0-20200416051211-89c76fbcd5d1 // indirect
-	k8s.io/api v0.18.3 // indirect
+	golang.org/x/time v0.0.0-20200416051211-89c76fbcd5d1
+	k8s.io/api v0.18.3
 	k8s.io/apimachinery v0.18.3
 	k8s.io/client-go v0.18.3
 	k8s.io/utils v0.0.0-20200520001619-278ece378a50 // indirect
+	sigs.k8s.io/yaml v1.2.0
 )
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"sigs.k8s.io/yaml"
+)
+
+// localIndex is a curated list of helm versions read from
+// HELM_WRAPPER_INDEX_FILE, which latest aliases and patch resolution then
+// pick from without going to the network. It's YAML or JSON like:
+//
+//	versions:
+//	  - version: v3.4.0
+//	    checksums:
+//	      helm-v3.4.0-linux-amd64.tar.gz: <hex sha256>
+//	  - version: v2.16.12
+//
+// Versions listed are taken to be downloadable, and their checksums are used
+// instead of the published ones.
+type localIndex struct {
+	Versions []struct {
+		Version   string            `json:"version"`
+		Checksums map[string]string `json:"checksums"`
+	} `json:"versions"`
+}
+
+var index struct {
+	once sync.Once
+	idx  *localIndex
+	err  error
+}
+
+// loadIndex returns the local index, or nil if there's none.
+func loadIndex() (*localIndex, error) {
+	index.once.Do(func() {
+		path := os.Getenv("HELM_WRAPPER_INDEX_FILE")
+		if path == "" {
+			return
+		}
+
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			index.err = err
+			return
+		}
+
+		var idx localIndex
+		if err := yaml.UnmarshalStrict(b, &idx); err != nil {
+			index.err = fmt.Errorf("invalid HELM_WRAPPER_INDEX_FILE %s: %v", path, err)
+			return
+		}
+		index.idx = &idx
+	})
+
+	return index.idx, index.err
+}
+
+// tags returns the versions in the index.
+func (idx *localIndex) tags() []string {
+	var tags []string
+	for _, v := range idx.Versions {
+		tags = append(tags, canonical(v.Version))
+	}
+
+	return tags
+}
+
+// checksum returns the checksum of the release file name in the index.
+func (idx *localIndex) checksum(name string) (string, bool) {
+	for _, v := range idx.Versions {
+		if sum, ok := v.Checksums[name]; ok {
+			return sum, true
+		}
+	}
+
+	return "", false
+}
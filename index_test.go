@@ -0,0 +1,115 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// useIndex points HELM_WRAPPER_INDEX_FILE at an index file holding content
+// for the rest of t.
+func useIndex(t *testing.T, content string) {
+	t.Helper()
+
+	resetIndex(t)
+	path := filepath.Join(tempDir(t), "index.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	setenv(t, "HELM_WRAPPER_INDEX_FILE", path)
+}
+
+func TestLoadIndex(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		wantTags []string
+		wantErr  bool
+	}{
+		{"yaml", "versions:\n  - version: v3.4.0\n    checksums:\n      helm-v3.4.0-linux-amd64.tar.gz: " + sumA + "\n  - version: 2.16.12\n", []string{"v3.4.0", "v2.16.12"}, false},
+		{"json", `{"versions": [{"version": "v3.4.0"}, {"version": "v3.3.4"}]}`, []string{"v3.4.0", "v3.3.4"}, false},
+		{"empty", "", nil, false},
+		{"unknown field", "versions:\n  - version: v3.4.0\n    sha256: " + sumA + "\n", nil, true},
+		{"not an index", "- v3.4.0\n", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useIndex(t, tt.content)
+
+			idx, err := loadIndex()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadIndex() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if got := idx.tags(); !reflect.DeepEqual(got, tt.wantTags) {
+				t.Errorf("tags() = %v, want %v", got, tt.wantTags)
+			}
+		})
+	}
+}
+
+func TestLoadIndexMissingFile(t *testing.T) {
+	resetIndex(t)
+	setenv(t, "HELM_WRAPPER_INDEX_FILE", filepath.Join(tempDir(t), "index.yaml"))
+
+	if _, err := loadIndex(); err == nil {
+		t.Error("loadIndex() of a missing file succeeded")
+	}
+}
+
+func TestOfflineResolution(t *testing.T) {
+	useIndex(t, `versions:
+  - version: v3.4.0
+    checksums:
+      `+archiveName("v3.4.0")+`: `+sumA+`
+  - version: v3.3.4
+  - version: v3.5.0-rc.1
+  - version: v2.16.12
+  - version: v2.16.10
+  - version: v2.15.2
+`)
+	setenv(t, "HELM_WRAPPER_ALWAYS_DOWNLOAD_LATEST_PATCH", "true")
+
+	// Nothing may go to the network.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("resolution requested %s", r.URL)
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	oldSettings, oldURL := settings, releasesURL
+	t.Cleanup(func() { settings, releasesURL = oldSettings, oldURL })
+	settings.Mirror = srv.URL
+	releasesURL = srv.URL + "/releases"
+
+	tests := []struct {
+		name    string
+		resolve func(dir string) (string, error)
+		want    string
+	}{
+		{"latest", func(dir string) (string, error) { return resolveLatest("latest", dir) }, "v3.4.0"},
+		{"latest-2", func(dir string) (string, error) { return resolveLatest("latest-2", dir) }, "v2.16.12"},
+		{"minor pin", func(dir string) (string, error) { return latestPatch("v2.16", dir) }, "v2.16.12"},
+		{"older minor pin", func(dir string) (string, error) { return latestPatch("v2.15", dir) }, "v2.15.2"},
+		{"checksum", func(string) (string, error) { return publishedChecksum(srv.Client(), "v3.4.0", archiveName("v3.4.0")) }, sumA},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.resolve(tempDir(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tt.want {
+				t.Errorf("resolved %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
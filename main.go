@@ -1,9 +1,10 @@
 package main
 
 import (
-	"archive/tar"
-	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -11,227 +12,603 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"runtime"
+	"strconv"
+	"strings"
 	"time"
 
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes"
-	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
-	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/apimachinery/pkg/util/version"
 )
 
+// defaultVersion is the client default helm version unless one is configured:
+// the newest stable release, resolved by resolveLatest.
+const defaultVersion = "latest"
+
 func main() {
-	binDir := os.ExpandEnv("${HOME}/.helm-wrapper/bin")
+	quiet()
+
+	if err := loadConfig(); err != nil {
+		fatal(withCode(exitConfig, err))
+	}
+
+	forcePlatform()
 
-	if err := dirs(binDir); err != nil {
-		log.Fatalln(err)
+	if err := setupTransport(); err != nil {
+		fatal(withCode(exitConfig, err))
 	}
 
-	v := "v2.16.12"
-	ok, err := checkLocal(v, binDir)
+	binDir, err := cacheDir()
 	if err != nil {
-		log.Fatalln(err)
+		fatal(withCode(exitConfig, err))
 	}
 
-	if !ok {
-		if err := download(v); err != nil {
-			log.Fatalln(err)
+	if err := sweep(binDir); err != nil {
+		log.Printf("couldn't remove partial downloads: %v", err)
+	}
+
+	root := startSpan("helm-wrapper")
+
+	name := strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe")
+	if name == "helm-wrapper" && len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			root.set("helm_wrapper.command", os.Args[1])
+			if err := cmd(binDir, os.Args[2:]); err != nil {
+				fatal(err)
+			}
+			flushTraces()
+			return
 		}
+	}
+
+	args := os.Args[1:]
+	if name == "kubectl-helm" {
+		args = pluginArgs(args)
+	}
+
+	followDebug(args)
+
+	if err := deny(args); err != nil {
+		fatal(err)
+	}
+
+	if err := protect(args); err != nil {
+		fatal(err)
+	}
+
+	v, args, err := resolveArgs(binDir, args)
+	if err != nil {
+		fatal(err)
+	}
+
+	if err := markUsed(binDir, v); err != nil {
+		log.Printf("couldn't record use of helm %s: %v", v, err)
+	}
+
+	checkCompat(v, args)
+	warnEOL(binDir, v)
+
+	env, err := pluginEnv(binDir, v)
+	if err != nil {
+		fatal(withCode(exitConfig, err))
+	}
 
-		if err := unTarZip(v, binDir); err != nil {
-			log.Fatalln(err)
+	extra, err := versionEnv(v)
+	if err != nil {
+		fatal(withCode(exitConfig, err))
+	}
+	env = append(env, extra...)
+
+	bin := helmBin(binDir, v)
+	printCommand(bin, args)
+
+	mask, masked, err := childUmask()
+	if err != nil {
+		fatal(withCode(exitConfig, err))
+	}
+
+	restore := func() {}
+	umask := func() {
+		if !masked {
+			return
+		}
+
+		var err error
+		if restore, err = setUmask(mask); err != nil {
+			fatal(withCode(exitConfig, err))
+		}
+	}
+	umask()
+
+	// The child's umask is only for helm, so a binary downloaded again
+	// is written with the wrapper's own.
+	again := func(err error) bool {
+		restore()
+		defer umask()
+		return refetch(binDir, v, err)
+	}
+
+	if canReplace && replaces() {
+		err := replace(bin, args, env)
+		if again(err) {
+			err = replace(bin, args, env)
 		}
+		fatal(withCode(exitExec, missingBin(v, bin, err)))
+	}
+
+	s := startSpan("exec")
+	s.set("helm.version", v)
+	code, err := run(bin, args, env)
+	if again(err) {
+		code, err = run(bin, args, env)
+	}
+	restore()
+	s.set("helm.exit_code", code)
+	s.finish(err)
+	if err != nil {
+		fatal(withCode(exitExec, missingBin(v, bin, err)))
+	}
+
+	if err := audit(v, args, code); err != nil {
+		log.Printf("couldn't write audit log: %v", err)
+	}
+
+	flushTraces()
+	os.Exit(code)
+}
+
+// resolveArgs returns the helm version to run args with, making sure it's
+// present in dir, and args stripped of any +version override. It's the single
+// place a run's version is decided, so that everything after it downloads,
+// reports and runs the same one. Failures to decide end the wrapper with
+// exitResolve, and those to download it with exitDownload.
+func resolveArgs(dir string, args []string) (_ string, _ []string, err error) {
+	s := startSpan("resolve")
+	defer func() { s.finish(err) }()
+
+	v, args := pinArg(args)
+	impersonateFrom(args)
+
+	switch {
+	case v != "":
+		v, err = pin(v, dir)
+	case !detect(args):
+		v, err = local(dir)
+	default:
+		v, err = resolve(dir, parseTarget(args))
+	}
+	if err != nil {
+		return "", nil, withCode(exitResolve, err)
 	}
 
-	server, err := serverVersion(v, binDir)
+	if c := canonical(v); c != v {
+		return "", nil, withCode(exitResolve, fmt.Errorf("internal error: resolved version %q isn't canonical, expected %q", v, c))
+	}
+	s.set("helm.version", v)
+
+	return v, args, nil
+}
+
+// resolve returns the helm version configured for t's namespace or else chosen
+// by the configured resolution strategy for a command talking to t, making
+// sure it's present in dir. Unless HELM_WRAPPER_CONFLICT is prefer-pin, the
+// default, a pinned namespace is still resolved by the strategy and any
+// disagreement settled by the conflict policy. When the strategy detects
+// nothing, the pinned or else the client default version is run.
+func resolve(dir string, t target) (string, error) {
+	pinned, ok := settings.Namespaces[t.namespace]
+	ok = ok && t.namespace != ""
+
+	policy, err := conflictPolicy()
 	if err != nil {
-		log.Fatalln(err)
+		return "", err
+	}
+
+	if ok && policy == preferPin {
+		return pin(pinned, dir)
+	}
+
+	r, err := newResolver(dir, t)
+	if err != nil {
+		return "", err
+	}
+
+	v, err := r.Resolve(context.Background())
+	detected := !errors.Is(err, errUndetected)
+	if detected && err != nil {
+		count("helm_wrapper_detection_failures_total", 1)
+		return "", err
 	}
 
-	if v != server {
-		ok, err := checkLocal(server, binDir)
-		if err != nil {
-			log.Fatalln(err)
+	switch {
+	case ok:
+		if pinned, err = pin(pinned, dir); err != nil {
+			return "", err
 		}
 
-		if !ok {
-			if err := download(server); err != nil {
-				log.Fatalln(err)
-			}
+		if !detected {
+			return pinned, nil
+		}
 
-			if err := unTarZip(server, binDir); err != nil {
-				log.Fatalln(err)
-			}
+		if v, err = reconcile(policy, pinned, v, t.namespace); err != nil {
+			return "", err
 		}
+	case !detected:
+		return local(dir)
 	}
 
-	cmd := exec.Command(fmt.Sprintf("%s/helm-%v", binDir, server), os.Args[1:]...)
-	out, err := cmd.CombinedOutput()
+	return v, ensure(v, dir)
+}
+
+// local returns the client default version, making sure it's present in dir.
+func local(dir string) (string, error) {
+	v, err := clientVersion(dir)
 	if err != nil {
-		fmt.Fprint(os.Stdout, string(out), err)
-		os.Exit(1)
+		return "", err
 	}
 
-	fmt.Fprint(os.Stdout, string(out))
+	return v, ensure(v, dir)
 }
 
-func dirs(path string) error {
-	_, err := os.Stat(path)
-	if err == nil {
+// pin makes sure the explicitly requested helm v, which may be a latest alias,
+// is present in dir, skipping Tiller detection.
+func pin(v, dir string) (string, error) {
+	if _, ok := parseLatest(v); ok {
+		var err error
+		if v, err = resolveLatest(v, dir); err != nil {
+			return "", err
+		}
+	}
+
+	v, err := latestPatch(canonical(v), dir)
+	if err != nil {
+		return "", err
+	}
+
+	return v, ensure(v, dir)
+}
+
+// ensure downloads helm v into dir unless it's already there. Failures to do
+// so end the wrapper with exitDownload.
+func ensure(v, dir string) error {
+	return withCode(exitDownload, fetchBin(v, dir))
+}
+
+// fetchBin is ensure without the exit code.
+func fetchBin(v, dir string) (err error) {
+	if _, ok := systemHelm(v); ok {
 		return nil
 	}
 
-	if !os.IsNotExist(err) {
+	s := startSpan("fetch")
+	s.set("helm.version", v)
+	defer func() { s.finish(err) }()
+
+	cache := newCache(dir)
+	ok, err := cache.Has(v)
+	if err != nil {
 		return err
 	}
+	s.set("cache.hit", ok)
 
-	if err := os.MkdirAll(path, 0755); err != nil {
+	if ok {
+		count("helm_wrapper_cache_hits_total", 1)
+		return nil
+	}
+	count("helm_wrapper_cache_misses_total", 1)
+
+	if err := useScratch(dir); err != nil {
 		return err
 	}
 
-	return nil
+	if !restoreArchive(v, dir) {
+		if err := download(v); err != nil {
+			return err
+		}
+
+		if keepArchives() {
+			if err := keepArchive(v, dir); err != nil {
+				log.Printf("couldn't keep the helm %s archive: %v", v, err)
+			}
+		}
+	}
+
+	return unTarZip(v, cache)
 }
 
-func checkLocal(v, path string) (bool, error) {
-	_, err := os.Stat(fmt.Sprintf("%s/helm-%v", path, v))
-	if err == nil {
-		return true, nil
+// binPath returns the path of the helm v binary in dir, which is in a
+// directory of its own with HELM_WRAPPER_VERSION_DIRS. Binaries for a forced
+// platform carry it in their name.
+func binPath(dir, v string) string {
+	name := fmt.Sprintf("%s/helm-%s", dir, cacheKey(v))
+
+	if versionDirs() {
+		return fmt.Sprintf("%s/%s", name, binName())
 	}
 
-	if !os.IsNotExist(err) {
-		return false, err
+	if host.os == "windows" {
+		name += ".exe"
 	}
 
-	return false, nil
+	return name
 }
 
-func download(v string) error {
-	c := http.Client{
-		Timeout: time.Second * 120,
+// clientVersion returns the helm version used when Tiller can't be found. The
+// configured version may also be one of the latest, latest-2 and latest-3
+// aliases.
+func clientVersion(dir string) (string, error) {
+	v := settings.Version
+	if _, ok := parseLatest(v); ok {
+		return resolveLatest(v, dir)
 	}
 
-	url := fmt.Sprintf("https://get.helm.sh/helm-%s-%s-%s.tar.gz", v, runtime.GOOS, runtime.GOARCH)
-	resp, err := c.Get(url)
+	return latestPatch(canonical(v), dir)
+}
+
+// cacheDir returns the absolute, symlink-free directory helm binaries are kept
+// in, creating it if needed.
+func cacheDir() (string, error) {
+	dir, err := filepath.Abs(os.ExpandEnv(settings.CacheDir))
 	if err != nil {
-		return err
+		return "", err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("couldn't download helm %s: %q", v, resp.Status)
+	if err := dirs(dir); err != nil {
+		return "", err
 	}
 
-	outFile, err := os.Create(fmt.Sprintf("%s/helm-%s.tar.gz", os.TempDir(), v))
+	dir, err = filepath.EvalSymlinks(dir)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	if _, err := io.Copy(outFile, resp.Body); err != nil {
-		return err
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return "", err
 	}
 
-	return nil
+	if !fi.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", dir)
+	}
+
+	return dir, nil
 }
 
-func unTarZip(v, dir string) error {
-	f, err := os.Open(fmt.Sprintf("%s/helm-%s.tar.gz", os.TempDir(), v))
-	if err != nil {
-		return err
+// dirs creates the directory path along with any missing parents. It's safe
+// for concurrent first runs creating the same directory, as MkdirAll succeeds
+// when it already exists.
+func dirs(path string) error {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return fmt.Errorf("couldn't create directory %s, check that it isn't a file and its parent is writable: %v", path, err)
 	}
-	defer os.Remove(fmt.Sprintf("%s/helm-%s.tar.gz", os.TempDir(), v))
-	defer f.Close()
 
-	archive, err := gzip.NewReader(f)
-	if err != nil {
-		return err
+	return nil
+}
+
+// checkLocal reports whether helm v is present in path. With
+// HELM_WRAPPER_VERIFY_ARCH set, a binary built for another platform, e.g. from
+// a home directory synced between machines, is treated as missing, and so is
+// one reporting another version with HELM_WRAPPER_VERIFY_VERSION set, which
+// is skipped when a platform is forced, as its binaries may not run here.
+func checkLocal(v, path string) (bool, error) {
+	bin := binPath(path, v)
+	if isPartial(bin) {
+		return false, nil
 	}
-	defer archive.Close()
 
-	tr := tar.NewReader(archive)
-	for {
-		header, err := tr.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return err
+	fi, err := os.Stat(bin)
+	if err == nil {
+		if fi.IsDir() {
+			return false, fmt.Errorf("%s is a directory, cached with HELM_WRAPPER_VERSION_DIRS set", bin)
 		}
 
-		path := header.Name
+		if envBool("HELM_WRAPPER_VERIFY_ARCH") {
+			ok, err := matchesHost(bin)
+			if err != nil {
+				return false, err
+			}
 
-		if header.Typeflag != tar.TypeReg {
-			continue
+			if !ok {
+				log.Printf("cached helm %s isn't built for %s/%s, downloading it again", v, host.os, host.arch)
+				return false, nil
+			}
 		}
 
-		if path != fmt.Sprintf("%s-%s/helm", runtime.GOOS, runtime.GOARCH) {
-			continue
+		if envBool("HELM_WRAPPER_VERIFY_VERSION") && host == native {
+			got := reportedVersion(bin)
+			if got != v {
+				log.Printf("cached helm %s reports version %q, downloading it again", v, got)
+				return false, nil
+			}
 		}
 
-		ofile, err := os.Create(fmt.Sprintf("%s/helm-%v", dir, v))
-		if err != nil {
-			return err
-		}
-		defer ofile.Close()
+		return true, nil
+	}
 
-		if _, err := io.Copy(ofile, tr); err != nil {
-			return err
+	if !os.IsNotExist(err) {
+		return false, err
+	}
+
+	return false, nil
+}
+
+// reportedVersion returns the version helm bin reports itself, without build
+// metadata, or "" if it can't tell.
+func reportedVersion(bin string) string {
+	out, err := exec.Command(bin, "version", "--client", "--short").Output()
+	if err != nil {
+		return ""
+	}
+
+	// Helm 2 prints e.g. "Client: v2.16.12+g47f0b88" and helm 3
+	// "v3.4.0+g7090a89".
+	for _, f := range strings.Fields(string(out)) {
+		if _, err := version.ParseSemantic(f); err == nil && strings.HasPrefix(f, "v") {
+			return stripBuild(f)
 		}
+	}
 
-		if err := os.Chmod(fmt.Sprintf("%s/helm-%v", dir, v), 0755); err != nil {
-			return err
+	return ""
+}
+
+// newClient returns the HTTP client used for all downloads.
+func newClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout:       timeout,
+		Transport:     mirrorAuth{base: transport},
+		CheckRedirect: checkRedirect,
+	}
+}
+
+func download(v string) (err error) {
+	s := startSpan("download")
+	s.set("helm.version", v)
+	s.set("server.address", mirrorHost())
+	defer func() { s.finish(err) }()
+
+	c, err := downloadClient()
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	var n int64
+	var sum string
+	err = withRetries("helm "+v, func() error {
+		var err error
+		n, sum, err = fetchArchive(c, archiveURL(v), archivePath(v))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	debugf("downloaded helm %s (%s) in %s from %s", v, humanSize(n), time.Since(start).Round(100*time.Millisecond), mirrorHost())
+	s.set("download.bytes", n)
+	count("helm_wrapper_downloads_total", 1)
+	count("helm_wrapper_downloaded_bytes_total", n)
+
+	if err := verifyArchive(c, v, sum); err != nil {
+		os.Remove(archivePath(v))
+		return err
+	}
+
+	if settings.CacheUpstream != "" && settings.CacheUpstream != settings.Mirror {
+		if err := publish(v); err != nil {
+			log.Printf("couldn't publish helm %s to %s: %v", v, settings.CacheUpstream, err)
 		}
 	}
 
 	return nil
 }
 
-func serverVersion(v, dir string) (string, error) {
-	ok, err := checkTiller()
-	if err != nil {
-		return "", err
+// fetch downloads url to path and returns the number of bytes written and
+// their hex sha256, which is computed on the way so checking it doesn't take
+// another read of the file.
+func fetch(c *http.Client, url, path string) (int64, string, error) {
+	// Throttled downloads have no overall timeout, see downloadClient, so any
+	// download is cancelled once it has received nothing for the timeout.
+	// A zero timeout, as for http.Client, means none.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var idle *time.Timer
+	if settings.timeout > 0 {
+		idle = time.AfterFunc(settings.timeout, cancel)
+		defer idle.Stop()
 	}
 
-	if !ok {
-		return v, nil
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, "", err
 	}
+	// Setting Accept-Encoding ourselves stops the transport from transparently
+	// decompressing archives that mirrors serve with Content-Encoding: gzip,
+	// so what's written is exactly the published archive.
+	req.Header.Set("Accept-Encoding", "identity")
 
-	out, err := exec.Command(fmt.Sprintf("%s/helm-%v", dir, v), "version", "--server", "--template", "{{.Server.SemVer}}").CombinedOutput()
+	resp, err := c.Do(req)
 	if err != nil {
-		return "", err
+		return 0, "", err
 	}
+	defer resp.Body.Close()
 
-	return string(out), nil
-}
+	if resp.StatusCode != http.StatusOK {
+		return 0, "", &statusError{url: url, status: resp.Status, code: resp.StatusCode}
+	}
 
-func checkTiller() (bool, error) {
-	homedir, err := os.UserHomeDir()
+	if err := checkSpace(filepath.Dir(path), resp.ContentLength); err != nil {
+		return 0, "", err
+	}
+
+	outFile, err := os.Create(path)
 	if err != nil {
-		return false, err
+		return 0, "", err
 	}
+	defer outFile.Close()
 
-	kubeconfig := filepath.Join(homedir, ".kube", "config")
-	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	body, err := throttle(resp.Body)
 	if err != nil {
-		return false, err
+		return 0, "", err
 	}
 
-	clientset, err := kubernetes.NewForConfig(config)
+	h := sha256.New()
+	n, err := copyBuffered(outFile, io.TeeReader(idleReader{r: body, idle: idle}, h))
 	if err != nil {
-		return false, err
+		if ctx.Err() != nil {
+			return 0, "", fmt.Errorf("download of %s stalled, nothing received for %s", url, settings.timeout)
+		}
+		return 0, "", err
 	}
 
-	listOptions := metav1.ListOptions{
-		LabelSelector: "app=helm,name=tiller",
+	return n, hex.EncodeToString(h.Sum(nil)), outFile.Close()
+}
+
+// available reports whether url can be downloaded, checking with a HEAD
+// request so that missing versions fail fast, and its size, or -1 if unknown.
+// Servers that don't support HEAD are assumed to have it.
+func available(c *http.Client, url string) (bool, int64, error) {
+	resp, err := c.Head(url)
+	if err != nil {
+		return false, 0, err
 	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, resp.ContentLength, nil
+	case http.StatusNotFound:
+		return false, 0, nil
+	case http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return true, -1, nil
+	}
+
+	return false, 0, fmt.Errorf("couldn't check %s: %q", url, resp.Status)
+}
 
-	pods, err := clientset.CoreV1().Pods("kube-system").List(context.TODO(), listOptions)
+// copyBufferSize returns the size of the buffer archives are copied through.
+// It defaults to 1MiB and can be set in bytes with HELM_WRAPPER_COPY_BUFFER.
+func copyBufferSize() (int, error) {
+	s := os.Getenv("HELM_WRAPPER_COPY_BUFFER")
+	if s == "" {
+		return 1 << 20, nil
+	}
+
+	n, err := strconv.Atoi(s)
 	if err != nil {
-		return false, err
+		return 0, fmt.Errorf("invalid HELM_WRAPPER_COPY_BUFFER %q: %v", s, err)
 	}
 
-	if len(pods.Items) == 0 {
-		return false, nil
+	if n < 4<<10 || n > 64<<20 {
+		return 0, fmt.Errorf("HELM_WRAPPER_COPY_BUFFER must be between 4KiB and 64MiB, got %d", n)
+	}
+
+	return n, nil
+}
+
+// copyBuffered copies src to dst through a buffer of copyBufferSize bytes.
+func copyBuffered(dst io.Writer, src io.Reader) (int64, error) {
+	n, err := copyBufferSize()
+	if err != nil {
+		return 0, err
 	}
 
-	return true, nil
+	// Hide dst's ReadFrom, which would otherwise bypass the buffer.
+	return io.CopyBuffer(struct{ io.Writer }{dst}, src, make([]byte, n))
 }
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// setenv sets the environment variable key to value for the rest of t.
+func setenv(t *testing.T, key, value string) {
+	t.Helper()
+
+	old, ok := os.LookupEnv(key)
+	if err := os.Setenv(key, value); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+// unsetenv unsets the environment variable key for the rest of t.
+func unsetenv(t *testing.T, key string) {
+	t.Helper()
+
+	old, ok := os.LookupEnv(key)
+	os.Unsetenv(key)
+
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, old)
+		}
+	})
+}
+
+// tempDir returns a directory removed at the end of t.
+func tempDir(t *testing.T) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "helm-wrapper-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	return dir
+}
+
+// pretendTerminal makes isTerminal report tty for the rest of t.
+func pretendTerminal(t *testing.T, tty bool) {
+	old := isTerminal
+	t.Cleanup(func() { isTerminal = old })
+	isTerminal = func(*os.File) bool { return tty }
+}
+
+// captureStdout returns what f writes to stdout.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	old := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = old }()
+
+	f()
+	w.Close()
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(b)
+}
+
+func TestCheckLocal(t *testing.T) {
+	tests := []struct {
+		name   string
+		verify bool
+		forced bool
+		out    string
+		want   bool
+	}{
+		{"unverified", false, false, "Client: v2.14.3+gabc", true},
+		{"matching", true, false, "Client: v2.16.12+g47f0b88", true},
+		{"mismatched", true, false, "Client: v2.14.3+gabc", false},
+		{"forced platform", true, true, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setenv(t, "HELM_WRAPPER_VERIFY_VERSION", strconv.FormatBool(tt.verify))
+			if tt.forced {
+				forceHost(t, foreign())
+			}
+
+			dir := tempDir(t)
+			fakeHelm(t, dir, "v2.16.12", tt.out, tt.forced)
+
+			got, err := checkLocal("v2.16.12", dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tt.want {
+				t.Errorf("checkLocal() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCopyBufferSize(t *testing.T) {
+	tests := []struct {
+		env     string
+		want    int
+		wantErr bool
+	}{
+		{"", 1 << 20, false},
+		{"65536", 64 << 10, false},
+		{"4096", 4 << 10, false},
+		{"67108864", 64 << 20, false},
+		{"4095", 0, true},
+		{"67108865", 0, true},
+		{"1MiB", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.env, func(t *testing.T) {
+			setenv(t, "HELM_WRAPPER_COPY_BUFFER", tt.env)
+
+			got, err := copyBufferSize()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("copyBufferSize() error = %v, wantErr %t", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("copyBufferSize() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// BenchmarkCopyBuffered copies an archive sized file to disk through buffers
+// of different sizes, against io.Copy's 32KiB.
+func BenchmarkCopyBuffered(b *testing.B) {
+	data := bytes.Repeat([]byte("helm"), 4<<20)
+
+	dir, err := ioutil.TempDir("", "helm-wrapper-bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bench := func(b *testing.B, fn func(io.Writer, io.Reader) (int64, error)) {
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			f, err := os.Create(filepath.Join(dir, "helm.tar.gz"))
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			// Hide the reader's WriteTo, as a network body has none.
+			if _, err := fn(struct{ io.Writer }{f}, struct{ io.Reader }{bytes.NewReader(data)}); err != nil {
+				b.Fatal(err)
+			}
+			f.Close()
+		}
+	}
+
+	b.Run("io.Copy", func(b *testing.B) {
+		bench(b, io.Copy)
+	})
+
+	for _, size := range []string{"65536", "1048576", "8388608"} {
+		b.Run(size, func(b *testing.B) {
+			os.Setenv("HELM_WRAPPER_COPY_BUFFER", size)
+			defer os.Unsetenv("HELM_WRAPPER_COPY_BUFFER")
+			bench(b, copyBuffered)
+		})
+	}
+}
+
+func TestFetchContentEncoding(t *testing.T) {
+	archive, sum := helmArchive(t, "#!/bin/sh\n")
+
+	tests := []struct {
+		name string
+		// always labels the archive Content-Encoding: gzip, as mirrors
+		// serving .tar.gz files from object stores often do, rather than
+		// only gzipping it for clients accepting that.
+		always bool
+	}{
+		{"negotiated", false},
+		{"labelled", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var accepted string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				accepted = r.Header.Get("Accept-Encoding")
+				if tt.always {
+					w.Header().Set("Content-Encoding", "gzip")
+					w.Write([]byte(archive))
+					return
+				}
+
+				if !strings.Contains(accepted, "gzip") {
+					w.Write([]byte(archive))
+					return
+				}
+				w.Header().Set("Content-Encoding", "gzip")
+				gw := gzip.NewWriter(w)
+				gw.Write([]byte(archive))
+				gw.Close()
+			}))
+			t.Cleanup(srv.Close)
+
+			path := filepath.Join(tempDir(t), "helm.tar.gz")
+			n, got, err := fetch(newClient(0), srv.URL, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if accepted != "identity" {
+				t.Errorf("requested Accept-Encoding %q, want identity", accepted)
+			}
+			if n != int64(len(archive)) || got != sum {
+				t.Errorf("fetch() = %d, %s, want the archive's %d, %s", n, got, len(archive), sum)
+			}
+
+			b, err := ioutil.ReadFile(path)
+			if err != nil || string(b) != archive {
+				t.Errorf("fetch() wrote %d bytes, %v, want the archive", len(b), err)
+			}
+		})
+	}
+}
+
+func TestDirs(t *testing.T) {
+	root := tempDir(t)
+	file := filepath.Join(root, "file")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{"new", filepath.Join(root, "a", "b", "c"), false},
+		{"existing", root, false},
+		{"a file", file, true},
+		{"under a file", filepath.Join(file, "bin"), true},
+	}
+
+	for _, tt := range tests {
+		err := dirs(tt.path)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("dirs() of %s error = %v, wantErr %t", tt.name, err, tt.wantErr)
+			continue
+		}
+
+		if err != nil && !strings.Contains(err.Error(), tt.path) {
+			t.Errorf("dirs() of %s error = %v, want it to name the directory", tt.name, err)
+		}
+	}
+}
+
+func TestDirsConcurrently(t *testing.T) {
+	path := filepath.Join(tempDir(t), ".helm-wrapper", "bin")
+
+	errs := make(chan error, 20)
+	for i := 0; i < cap(errs); i++ {
+		go func() { errs <- dirs(path) }()
+	}
+
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("concurrent dirs() = %v", err)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// metrics are the counters written to HELM_WRAPPER_METRICS_FILE, with their
+// help text.
+var metrics = []struct {
+	name, help string
+}{
+	{"helm_wrapper_downloads_total", "Helm archives downloaded."},
+	{"helm_wrapper_downloaded_bytes_total", "Bytes of helm archives downloaded."},
+	{"helm_wrapper_cache_hits_total", "Runs finding their helm version in the cache."},
+	{"helm_wrapper_cache_misses_total", "Runs downloading their helm version."},
+	{"helm_wrapper_detection_failures_total", "Runs failing to resolve the helm version to use."},
+}
+
+// metricsMu serialises this run's updates of the metrics file.
+var metricsMu sync.Mutex
+
+// count adds n to the counter name in HELM_WRAPPER_METRICS_FILE, a
+// Prometheus text file, e.g. for node-exporter's textfile collector, holding
+// counters across all runs. Without it nothing is counted. Updates by runs
+// at the same time may be lost, so the counters are approximate. Failures
+// are only logged.
+func count(name string, n int64) {
+	path := os.Getenv("HELM_WRAPPER_METRICS_FILE")
+	if path == "" {
+		return
+	}
+
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+
+	if err := addMetric(path, name, n); err != nil {
+		log.Printf("couldn't update metrics file %s: %v", path, err)
+	}
+}
+
+func addMetric(path, name string, n int64) error {
+	values := map[string]int64{}
+	f, err := os.Open(path)
+	switch {
+	case err == nil:
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			fields := strings.Fields(s.Text())
+			if len(fields) != 2 || strings.HasPrefix(fields[0], "#") {
+				continue
+			}
+
+			if v, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+				values[fields[0]] = v
+			}
+		}
+		f.Close()
+		if err := s.Err(); err != nil {
+			return err
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	values[name] += n
+
+	var b strings.Builder
+	for _, m := range metrics {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", m.name, m.help, m.name, m.name, values[m.name])
+	}
+
+	// The collector may read the file at any time, so it's replaced whole.
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".helm-wrapper-metrics-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := tmp.WriteString(b.String()); err != nil {
+		return err
+	}
+
+	if err := tmp.Chmod(0644); err != nil {
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
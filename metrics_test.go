@@ -0,0 +1,139 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// readMetrics returns the counters in the metrics file path.
+func readMetrics(t *testing.T, path string) map[string]int64 {
+	t.Helper()
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := map[string]int64{}
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			t.Fatalf("invalid metrics line %q", line)
+		}
+
+		v, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			t.Fatalf("invalid metrics line %q", line)
+		}
+		values[fields[0]] = v
+	}
+
+	return values
+}
+
+func TestCount(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing string
+		counts   map[string]int64
+		want     map[string]int64
+	}{
+		{
+			name:   "new file",
+			counts: map[string]int64{"helm_wrapper_downloads_total": 1, "helm_wrapper_downloaded_bytes_total": 1234},
+			want: map[string]int64{
+				"helm_wrapper_downloads_total":          1,
+				"helm_wrapper_downloaded_bytes_total":   1234,
+				"helm_wrapper_cache_hits_total":         0,
+				"helm_wrapper_cache_misses_total":       0,
+				"helm_wrapper_detection_failures_total": 0,
+			},
+		},
+		{
+			name:     "added to earlier runs",
+			existing: "# HELP helm_wrapper_cache_hits_total Runs finding their helm version in the cache.\nhelm_wrapper_cache_hits_total 41\nhelm_wrapper_cache_misses_total 2\n",
+			counts:   map[string]int64{"helm_wrapper_cache_hits_total": 1},
+			want: map[string]int64{
+				"helm_wrapper_downloads_total":          0,
+				"helm_wrapper_downloaded_bytes_total":   0,
+				"helm_wrapper_cache_hits_total":         42,
+				"helm_wrapper_cache_misses_total":       2,
+				"helm_wrapper_detection_failures_total": 0,
+			},
+		},
+		{
+			name:     "garbage and unknown counters dropped",
+			existing: "helm_wrapper_cache_misses_total lots\nsome_other_total 7\nnot a metric line\n",
+			counts:   map[string]int64{"helm_wrapper_cache_misses_total": 1},
+			want: map[string]int64{
+				"helm_wrapper_downloads_total":          0,
+				"helm_wrapper_downloaded_bytes_total":   0,
+				"helm_wrapper_cache_hits_total":         0,
+				"helm_wrapper_cache_misses_total":       1,
+				"helm_wrapper_detection_failures_total": 0,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := tempDir(t)
+			path := filepath.Join(dir, "helm-wrapper.prom")
+			setenv(t, "HELM_WRAPPER_METRICS_FILE", path)
+			if tt.existing != "" {
+				if err := ioutil.WriteFile(path, []byte(tt.existing), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for name, n := range tt.counts {
+				count(name, n)
+			}
+
+			if got := readMetrics(t, path); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("metrics file holds %v, want %v", got, tt.want)
+			}
+
+			fi, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi.Mode().Perm() != 0644 {
+				t.Errorf("metrics file has mode %v, want -rw-r--r--", fi.Mode().Perm())
+			}
+
+			if got := names(t, dir); !reflect.DeepEqual(got, []string{"helm-wrapper.prom"}) {
+				t.Errorf("metrics left %v behind", got)
+			}
+		})
+	}
+}
+
+func TestCountConcurrently(t *testing.T) {
+	path := filepath.Join(tempDir(t), "helm-wrapper.prom")
+	setenv(t, "HELM_WRAPPER_METRICS_FILE", path)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			count("helm_wrapper_downloads_total", 1)
+		}()
+	}
+	wg.Wait()
+
+	if got := readMetrics(t, path)["helm_wrapper_downloads_total"]; got != 20 {
+		t.Errorf("20 counts in this run added up to %d", got)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// migrateCache moves the cached helm binaries, the detection cache, kept
+// archives and plugins from dir to another directory, e.g. on a larger volume,
+// before HELM_WRAPPER_BIN_DIR is pointed at it. Moved binaries are checked
+// against the cache manifest and recorded in the new one. Versions already in
+// the new directory are left where they are, and so are binaries of other
+// platforms than the host's, which are moved by running it again with the
+// platform forced.
+func migrateCache(dir string, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: helm-wrapper migrate-cache <new-dir>")
+	}
+
+	dest, err := filepath.Abs(args[0])
+	if err != nil {
+		return err
+	}
+
+	if err := dirs(dest); err != nil {
+		return err
+	}
+
+	if dest, err = filepath.EvalSymlinks(dest); err != nil {
+		return err
+	}
+
+	if dest == dir {
+		return fmt.Errorf("%s is already the cache directory", dir)
+	}
+
+	m, err := readManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	vs, err := cached(dir)
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for _, c := range vs {
+		to := filepath.Join(dest, filepath.Base(c.Path))
+		if _, err := os.Stat(to); err == nil {
+			log.Printf("helm %s is already in %s, leaving %s", c.Version, dest, c.Path)
+			continue
+		}
+
+		if err := migrate(dir, dest, c, m); err != nil {
+			log.Printf("couldn't move helm %s: %v", c.Version, err)
+			failed++
+			continue
+		}
+
+		fmt.Printf("moved helm %s to %s\n", c.Version, to)
+	}
+
+	for _, d := range []string{detectDir(dir), filepath.Join(dir, ".archives"), filepath.Join(dir, ".plugins")} {
+		to := filepath.Join(dest, filepath.Base(d))
+		if _, err := os.Stat(to); !os.IsNotExist(err) {
+			continue
+		}
+
+		if err := move(d, to); err != nil && !os.IsNotExist(err) {
+			log.Printf("couldn't move %s: %v", d, err)
+			failed++
+		}
+	}
+
+	if failed != 0 {
+		return fmt.Errorf("%d entries couldn't be moved to %s", failed, dest)
+	}
+
+	return nil
+}
+
+// migrate moves cached helm c from dir to dest, checks it against its entry
+// in m, if there's one, and moves its entry to the manifest of dest.
+func migrate(dir, dest string, c cachedVersion, m map[string]manifestEntry) error {
+	to := filepath.Join(dest, filepath.Base(c.Path))
+	if err := move(c.Path, to); err != nil {
+		return err
+	}
+
+	if err := move(usedPath(dir, c.Version), usedPath(dest, c.Version)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	bin := to
+	if versionDirs() {
+		bin = filepath.Join(to, binName())
+	}
+
+	want, ok := m[cacheKey(c.Version)]
+	if !ok {
+		if err := record(dest, c.Version); err != nil {
+			return err
+		}
+		return forget(dir, c.Version)
+	}
+
+	sum, err := hashFile(bin)
+	if err != nil {
+		return err
+	}
+
+	if sum != want.SHA256 {
+		return fmt.Errorf("%s has sha256 %s after the move, want %s, run helm-wrapper fsck --repair", to, sum, want.SHA256)
+	}
+
+	if err := updateManifest(dest, func(dm map[string]manifestEntry) {
+		dm[cacheKey(c.Version)] = want
+	}); err != nil {
+		return err
+	}
+
+	return forget(dir, c.Version)
+}
+
+// move renames src to dst, falling back to copying and removing src when
+// they're on different filesystems. A copy is only put in place once it's
+// complete.
+func move(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil || os.IsNotExist(err) {
+		return err
+	}
+
+	part := dst + ".part"
+	if err := copyTree(src, part); err != nil {
+		os.RemoveAll(part)
+		return err
+	}
+
+	if err := os.Rename(part, dst); err != nil {
+		os.RemoveAll(part)
+		return err
+	}
+
+	return os.RemoveAll(src)
+}
+
+// copyTree copies the file or directory src to dst, keeping permissions and
+// modification times.
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case fi.IsDir():
+			return os.MkdirAll(target, fi.Mode().Perm())
+		case !fi.Mode().IsRegular():
+			return fmt.Errorf("%s isn't a regular file", path)
+		}
+
+		if err := copyFile(path, target, fi.Mode().Perm()); err != nil {
+			return err
+		}
+
+		return os.Chtimes(target, fi.ModTime(), fi.ModTime())
+	})
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := copyBuffered(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
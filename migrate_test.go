@@ -0,0 +1,194 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMigrateCache(t *testing.T) {
+	tests := []struct {
+		name     string
+		versions map[string]string
+		wantDest []string
+		wantSrc  []string
+		wantErr  bool
+	}{
+		{
+			name:     "fresh destination",
+			versions: map[string]string{"v2.14.3": "tracked", "v3.4.0": "tracked"},
+			wantDest: []string{"v2.14.3", "v3.4.0"},
+		},
+		{
+			name:     "untracked binary",
+			versions: map[string]string{"v2.14.3": "untracked", "v3.4.0": "tracked"},
+			wantDest: []string{"v2.14.3", "v3.4.0"},
+		},
+		{
+			name:     "version already in the destination",
+			versions: map[string]string{"v2.14.3": "tracked", "v3.4.0": "in destination"},
+			wantDest: []string{"v2.14.3", "v3.4.0"},
+			wantSrc:  []string{"v3.4.0"},
+		},
+		{
+			name:     "corrupt binary",
+			versions: map[string]string{"v2.14.3": "tracked", "v3.4.0": "corrupt"},
+			wantDest: []string{"v2.14.3", "v3.4.0"},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, dest := tempDir(t), tempDir(t)
+			for v, state := range tt.versions {
+				fill(t, dir, map[string]time.Duration{v: time.Hour})
+				if state != "untracked" {
+					if err := record(dir, v); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				switch state {
+				case "corrupt":
+					if err := ioutil.WriteFile(binPath(dir, v), []byte("#!/bin/sh\nexit 1\n"), 0755); err != nil {
+						t.Fatal(err)
+					}
+				case "in destination":
+					if err := ioutil.WriteFile(binPath(dest, v), []byte("#!/bin/sh\n"), 0755); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			if err := storeDetection(dir, "prod", "v3.4.0"); err != nil {
+				t.Fatal(err)
+			}
+
+			var err error
+			captureStdout(t, func() { err = migrateCache(dir, []string{dest}) })
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("migrateCache() error = %v, wantErr %t", err, tt.wantErr)
+			}
+
+			if got := cachedVersions(t, dest); !reflect.DeepEqual(got, tt.wantDest) {
+				t.Errorf("migrateCache() left %v in the destination, want %v", got, tt.wantDest)
+			}
+			if got := cachedVersions(t, dir); !reflect.DeepEqual(got, tt.wantSrc) {
+				t.Errorf("migrateCache() left %v in the old directory, want %v", got, tt.wantSrc)
+			}
+			if _, err := os.Stat(detectPath(dest, "prod")); err != nil {
+				t.Errorf("migrateCache() didn't move the detection cache: %v", err)
+			}
+
+			old, err := readManifest(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := captureStdout(t, func() { fsck(dest, nil) })
+			for v, state := range tt.versions {
+				if state != "tracked" && state != "untracked" {
+					continue
+				}
+
+				if _, ok := old[cacheKey(v)]; ok {
+					t.Errorf("the old manifest still has moved helm %s", v)
+				}
+				if _, err := os.Stat(usedPath(dest, v)); err != nil {
+					t.Errorf("migrateCache() didn't move when helm %s was last used: %v", v, err)
+				}
+				if !strings.Contains(out, "helm "+v+": OK") {
+					t.Errorf("fsck() of the destination printed\n%s\nwant helm %s OK", out, v)
+				}
+			}
+		})
+	}
+}
+
+func TestMigrateCacheArgs(t *testing.T) {
+	dir := tempDir(t)
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"no directory", nil},
+		{"two directories", []string{tempDir(t), tempDir(t)}},
+		{"the cache directory", []string{dir}},
+		{"the cache directory through a symlink", []string{symlinkTo(t, dir)}},
+	}
+
+	for _, tt := range tests {
+		if err := migrateCache(dir, tt.args); err == nil {
+			t.Errorf("migrateCache() to %s succeeded", tt.name)
+		}
+	}
+}
+
+// symlinkTo returns a new symlink to dir.
+func symlinkTo(t *testing.T, dir string) string {
+	t.Helper()
+
+	link := filepath.Join(tempDir(t), "link")
+	if err := os.Symlink(dir, link); err != nil {
+		t.Fatal(err)
+	}
+
+	return link
+}
+
+func TestCopyTree(t *testing.T) {
+	src := tempDir(t)
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	files := map[string]os.FileMode{
+		"helm":          0755,
+		"plugins/a.yml": 0600,
+		"plugins/b/c":   0644,
+	}
+	for name, perm := range files {
+		path := filepath.Join(src, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(name), perm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dst := filepath.Join(tempDir(t), "copy")
+	if err := copyTree(src, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, perm := range files {
+		path := filepath.Join(dst, name)
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Errorf("copyTree() didn't copy %s: %v", name, err)
+			continue
+		}
+
+		if fi.Mode().Perm() != perm {
+			t.Errorf("copyTree() copied %s with mode %v, want %v", name, fi.Mode().Perm(), perm)
+		}
+		if !fi.ModTime().Equal(mtime) {
+			t.Errorf("copyTree() copied %s with mtime %v, want %v", name, fi.ModTime(), mtime)
+		}
+		if b, _ := ioutil.ReadFile(path); string(b) != name {
+			t.Errorf("copyTree() copied %s as %q", name, b)
+		}
+	}
+
+	if err := os.Symlink("helm", filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := copyTree(src, filepath.Join(tempDir(t), "copy")); err == nil {
+		t.Error("copyTree() of a symlink succeeded")
+	}
+}
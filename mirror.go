@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// publish uploads the downloaded helm v archive, along with its checksum, to
+// the internal cache at settings.CacheUpstream, so machines using it as their
+// mirror find it there. HELM_WRAPPER_CACHE_UPSTREAM_TOKEN is sent as a bearer
+// token if set.
+func publish(v string) error {
+	f, err := os.Open(archivePath(v))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	sum, err := hashFile(archivePath(v))
+	if err != nil {
+		return err
+	}
+
+	if err := put(archiveName(v), f, fi.Size(), "application/gzip"); err != nil {
+		return err
+	}
+
+	line := fmt.Sprintf("%s  %s\n", sum, archiveName(v))
+	return put(archiveName(v)+".sha256", strings.NewReader(line), int64(len(line)), "text/plain")
+}
+
+// put uploads body as name to settings.CacheUpstream.
+func put(name string, body io.Reader, size int64, contentType string) error {
+	url := fmt.Sprintf("%s/%s", strings.TrimSuffix(settings.CacheUpstream, "/"), name)
+	req, err := http.NewRequest(http.MethodPut, url, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+	if token := os.Getenv("HELM_WRAPPER_CACHE_UPSTREAM_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := newClient(settings.timeout).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("upload of %s failed: %q", name, resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// upload is a PUT received by uploadServer.
+type upload struct {
+	path, auth, contentType, body string
+}
+
+// uploadServer records the PUTs it receives, answering them with status.
+func uploadServer(t *testing.T, status int) (*httptest.Server, func() []upload) {
+	var mu sync.Mutex
+	var uploads []upload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		b, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		uploads = append(uploads, upload{r.URL.Path, r.Header.Get("Authorization"), r.Header.Get("Content-Type"), string(b)})
+		mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, func() []upload {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]upload(nil), uploads...)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	tests := []struct {
+		name     string
+		token    string
+		status   int
+		wantAuth string
+		wantErr  bool
+	}{
+		{"anonymous", "", http.StatusCreated, "", false},
+		{"token", "s3cret", http.StatusOK, "Bearer s3cret", false},
+		{"rejected", "", http.StatusForbidden, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTempScratch(t)
+			setenv(t, "HELM_WRAPPER_CACHE_UPSTREAM_TOKEN", tt.token)
+			srv, uploads := uploadServer(t, tt.status)
+
+			old := settings
+			t.Cleanup(func() { settings = old })
+			settings.CacheUpstream = srv.URL + "/helm/"
+
+			archive, sum := helmArchive(t, "#!/bin/sh\n")
+			if err := ioutil.WriteFile(archivePath("v2.16.12"), []byte(archive), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			err := publish("v2.16.12")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("publish() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			got := uploads()
+			if len(got) != 2 {
+				t.Fatalf("got %d uploads, want the archive and its checksum", len(got))
+			}
+
+			want := []upload{
+				{"/helm/" + archiveName("v2.16.12"), tt.wantAuth, "application/gzip", archive},
+				{"/helm/" + archiveName("v2.16.12") + ".sha256", tt.wantAuth, "text/plain", sum + "  " + archiveName("v2.16.12") + "\n"},
+			}
+			for i := range want {
+				if got[i] != want[i] {
+					t.Errorf("upload %d = %+.80v, want %+.80v", i, got[i], want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDownloadPublishes(t *testing.T) {
+	tests := []struct {
+		name             string
+		upstreamIsMirror bool
+		want             int
+	}{
+		{"write-through", false, 2},
+		{"upstream is the mirror", true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTempScratch(t)
+			var downloads int
+			countingMirror(t, "#!/bin/sh\n", &downloads)
+			srv, uploads := uploadServer(t, http.StatusCreated)
+			settings.CacheUpstream = srv.URL
+			if tt.upstreamIsMirror {
+				settings.CacheUpstream = settings.Mirror
+			}
+
+			if err := download("v2.16.12"); err != nil {
+				t.Fatal(err)
+			}
+
+			got := uploads()
+			if len(got) != tt.want {
+				t.Fatalf("got %d uploads, want %d", len(got), tt.want)
+			}
+			if tt.want != 0 && !strings.HasSuffix(got[0].path, archiveName("v2.16.12")) {
+				t.Errorf("uploaded %s first, want the archive", got[0].path)
+			}
+		})
+	}
+}
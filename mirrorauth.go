@@ -0,0 +1,279 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// publicMirror is helm's own download host, which never gets mirror
+// credentials.
+const publicMirror = "get.helm.sh"
+
+// transport is the base transport of all download clients, set up by
+// setupTransport. Sharing it lets downloads reuse connections, over HTTP/2
+// where the server supports it, e.g. when prefetch fetches several versions.
+var transport http.RoundTripper = http.DefaultTransport
+
+// idleConnsPerHost is how many idle connections to a host are kept for
+// reuse, enough for prefetch's default concurrency along with checksums.
+const idleConnsPerHost = 8
+
+// defaultConcurrency is how many downloads may run at once, and how many
+// connections may be open to a host, unless HELM_WRAPPER_DOWNLOAD_CONCURRENCY
+// says otherwise. It's kept low so that a large prefetch doesn't overwhelm a
+// small internal mirror.
+const defaultConcurrency = 4
+
+// downloadConcurrency returns HELM_WRAPPER_DOWNLOAD_CONCURRENCY, or
+// defaultConcurrency.
+func downloadConcurrency() (int, error) {
+	return envInt("HELM_WRAPPER_DOWNLOAD_CONCURRENCY", defaultConcurrency)
+}
+
+// setupTransport sets up the shared transport, with at most
+// downloadConcurrency connections to each host and, with HELM_WRAPPER_CERT_PIN,
+// only to a mirror host serving a pinned key. It trusts the PEM certificates in
+// HELM_WRAPPER_MIRROR_CA, if set, in addition to the system ones, for mirrors
+// with a private CA. With HELM_WRAPPER_MIRROR_KUBECONFIG_CA it also trusts the
+// certificate authority of the current kubeconfig cluster, for mirrors served
+// from inside the cluster's network. A proxy-url in the kubeconfig isn't
+// supported by its client, so HTTPS_PROXY is still what downloads go through.
+func setupTransport() error {
+	conns, err := downloadConcurrency()
+	if err != nil {
+		return err
+	}
+
+	pins, err := loadCertPins()
+	if err != nil {
+		return err
+	}
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConnsPerHost = idleConnsPerHost
+	t.MaxConnsPerHost = conns
+	pool, err := mirrorCAs()
+	if err != nil {
+		return err
+	}
+	if pool != nil {
+		t.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	transport = t
+
+	if len(pins) != 0 {
+		pinned := t.Clone()
+		if pinned.TLSClientConfig == nil {
+			pinned.TLSClientConfig = &tls.Config{}
+		}
+		pinned.TLSClientConfig.VerifyPeerCertificate = pins.verify
+		transport = pinnedMirror{base: t, pinned: pinned}
+	}
+
+	return nil
+}
+
+// mirrorCAs returns the system certificates along with those of
+// HELM_WRAPPER_MIRROR_CA and HELM_WRAPPER_MIRROR_KUBECONFIG_CA, or nil for
+// just the system ones.
+func mirrorCAs() (*x509.CertPool, error) {
+	path := os.Getenv("HELM_WRAPPER_MIRROR_CA")
+	useKube := envBool("HELM_WRAPPER_MIRROR_KUBECONFIG_CA")
+	if path == "" && !useKube {
+		return nil, nil
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+
+	if path != "" {
+		pem, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in HELM_WRAPPER_MIRROR_CA %s", path)
+		}
+	}
+
+	if useKube {
+		pem, err := kubeconfigCA()
+		if err != nil {
+			return nil, err
+		}
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in the certificate authority of the kubeconfig cluster")
+		}
+	}
+
+	return pool, nil
+}
+
+// pinnedMirror sends requests for the mirror host through pinned, which only
+// connects to a server holding a key in HELM_WRAPPER_CERT_PIN, and the others,
+// e.g. to api.github.com for releases and self-updates, through base.
+type pinnedMirror struct {
+	base, pinned *http.Transport
+}
+
+func (t pinnedMirror) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host == mirrorHost() {
+		return t.pinned.RoundTrip(req)
+	}
+
+	return t.base.RoundTrip(req)
+}
+
+// limitConns sets how many connections the shared transport may open to each
+// host.
+func limitConns(n int) {
+	switch t := transport.(type) {
+	case *http.Transport:
+		t.MaxConnsPerHost = n
+	case pinnedMirror:
+		t.base.MaxConnsPerHost = n
+		t.pinned.MaxConnsPerHost = n
+	}
+}
+
+// kubeconfigCA returns the PEM certificate authority of the current
+// kubeconfig cluster.
+func kubeconfigCA() ([]byte, error) {
+	config, err := kubeconfig("").ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case len(config.CAData) != 0:
+		return config.CAData, nil
+	case config.CAFile != "":
+		return ioutil.ReadFile(config.CAFile)
+	}
+
+	return nil, fmt.Errorf("HELM_WRAPPER_MIRROR_KUBECONFIG_CA is set, but the kubeconfig cluster has no certificate authority")
+}
+
+// mirrorBase returns the configured mirror without any credentials in it.
+func mirrorBase() string {
+	u, err := url.Parse(settings.Mirror)
+	if err != nil || u.User == nil {
+		return strings.TrimSuffix(settings.Mirror, "/")
+	}
+
+	u.User = nil
+	return strings.TrimSuffix(u.String(), "/")
+}
+
+// mirrorAuth adds the mirror's credentials to requests for the mirror host:
+// the headers printed by HELM_WRAPPER_PRE_DOWNLOAD_HOOK, and the user info of
+// the mirror URL as basic auth or HELM_WRAPPER_MIRROR_TOKEN as a bearer token.
+// Requests to other hosts, redirects included, and to get.helm.sh are left
+// alone.
+type mirrorAuth struct {
+	base http.RoundTripper
+}
+
+func (t mirrorAuth) RoundTrip(req *http.Request) (*http.Response, error) {
+	u, err := url.Parse(settings.Mirror)
+	if err != nil || u.Host != req.URL.Host || u.Hostname() == publicMirror || req.Header.Get("Authorization") != "" {
+		return t.base.RoundTrip(req)
+	}
+
+	headers, err := hookHeaders()
+	if err != nil {
+		return nil, err
+	}
+
+	token := os.Getenv("HELM_WRAPPER_MIRROR_TOKEN")
+	if u.User == nil && token == "" && len(headers) == 0 {
+		return t.base.RoundTrip(req)
+	}
+
+	// RoundTrippers mustn't modify the request they're given.
+	req = req.Clone(req.Context())
+	switch {
+	case u.User != nil:
+		password, _ := u.User.Password()
+		req.SetBasicAuth(u.User.Username(), password)
+	case token != "":
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for name, values := range headers {
+		req.Header[name] = values
+	}
+
+	return t.base.RoundTrip(req)
+}
+
+// hook caches the headers of the pre-download hook, which runs at most once
+// per invocation.
+var hook struct {
+	once    sync.Once
+	headers http.Header
+	err     error
+}
+
+// hookHeaders runs the shell command HELM_WRAPPER_PRE_DOWNLOAD_HOOK, if set,
+// e.g. to fetch a short-lived token, and returns the headers it prints, one
+// "Name: value" per line.
+func hookHeaders() (http.Header, error) {
+	hook.once.Do(func() {
+		command := os.Getenv("HELM_WRAPPER_PRE_DOWNLOAD_HOOK")
+		if command == "" {
+			return
+		}
+
+		shell := []string{"sh", "-c"}
+		if native.os == "windows" {
+			shell = []string{"cmd", "/C"}
+		}
+
+		cmd := exec.Command(shell[0], shell[1], command)
+		cmd.Stderr = log.Writer()
+		out, err := cmd.Output()
+		if err != nil {
+			hook.err = fmt.Errorf("HELM_WRAPPER_PRE_DOWNLOAD_HOOK failed: %v", err)
+			return
+		}
+
+		hook.headers, hook.err = parseHeaders(string(out))
+	})
+
+	return hook.headers, hook.err
+}
+
+// parseHeaders parses "Name: value" lines, skipping empty ones.
+func parseHeaders(s string) (http.Header, error) {
+	h := http.Header{}
+	for i, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		// Don't echo the line, it likely holds a secret.
+		parts := strings.SplitN(line, ":", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("HELM_WRAPPER_PRE_DOWNLOAD_HOOK printed an invalid header on line %d, expected Name: value", i+1)
+		}
+
+		h.Add(name, strings.TrimSpace(parts[1]))
+	}
+
+	return h, nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// resetHook runs the pre-download hook afresh, and again after t.
+func resetHook(t *testing.T) {
+	hook.once = sync.Once{}
+	hook.headers, hook.err = nil, nil
+	t.Cleanup(func() {
+		hook.once = sync.Once{}
+		hook.headers, hook.err = nil, nil
+	})
+}
+
+func TestParseHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    http.Header
+		wantErr bool
+	}{
+		{"none", "\n", http.Header{}, false},
+		{"token", "Authorization: Bearer abc:def\n", http.Header{"Authorization": {"Bearer abc:def"}}, false},
+		{"several", "X-Team: platform\n\nx-team: infra\r\nX-Expires:  3600 \n", http.Header{"X-Team": {"platform", "infra"}, "X-Expires": {"3600"}}, false},
+		{"no colon", "Bearer abc\n", nil, true},
+		{"no name", ": abc\n", nil, true},
+		{"space in name", "X Team: platform\n", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseHeaders(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseHeaders() error = %v, wantErr %t", err, tt.wantErr)
+			}
+
+			if err != nil {
+				if strings.Contains(err.Error(), "abc") {
+					t.Errorf("parseHeaders() error %q echoes the secret", err)
+				}
+				return
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("parseHeaders() = %v, want %v", got, tt.want)
+			}
+			for name, values := range tt.want {
+				if strings.Join(got[name], ",") != strings.Join(values, ",") {
+					t.Errorf("parseHeaders() %s = %q, want %q", name, got[name], values)
+				}
+			}
+		})
+	}
+}
+
+func TestMirrorAuth(t *testing.T) {
+	tests := []struct {
+		name     string
+		userinfo string
+		token    string
+		hook     string
+		other    bool
+		want     http.Header
+		wantErr  bool
+	}{
+		{"anonymous", "", "", "", false, http.Header{}, false},
+		{"basic auth", "ci:s3cret", "", "", false, http.Header{"Authorization": {"Basic Y2k6czNjcmV0"}}, false},
+		{"token", "", "t0ken", "", false, http.Header{"Authorization": {"Bearer t0ken"}}, false},
+		{"hook", "", "", "printf 'Authorization: Bearer fr3sh\\nX-Team: platform\\n'", false, http.Header{"Authorization": {"Bearer fr3sh"}, "X-Team": {"platform"}}, false},
+		{"hook over token", "", "t0ken", "echo 'Authorization: Bearer fr3sh'", false, http.Header{"Authorization": {"Bearer fr3sh"}}, false},
+		{"token with hook headers", "", "t0ken", "echo 'X-Team: platform'", false, http.Header{"Authorization": {"Bearer t0ken"}, "X-Team": {"platform"}}, false},
+		{"other host", "ci:s3cret", "t0ken", "echo 'X-Team: platform'", true, http.Header{}, false},
+		{"hook failing", "", "", "exit 1", false, nil, true},
+		{"hook printing garbage", "", "", "echo token", false, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetHook(t)
+			setenv(t, "HELM_WRAPPER_MIRROR_TOKEN", tt.token)
+			setenv(t, "HELM_WRAPPER_PRE_DOWNLOAD_HOOK", tt.hook)
+
+			var got http.Header
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header
+			})
+			mirror := httptest.NewServer(handler)
+			t.Cleanup(mirror.Close)
+			other := httptest.NewServer(handler)
+			t.Cleanup(other.Close)
+
+			old := settings
+			t.Cleanup(func() { settings = old })
+			u, _ := url.Parse(mirror.URL)
+			if tt.userinfo != "" {
+				parts := strings.SplitN(tt.userinfo, ":", 2)
+				u.User = url.UserPassword(parts[0], parts[1])
+			}
+			settings.Mirror = u.String()
+
+			target := mirror.URL
+			if tt.other {
+				target = other.URL
+			}
+
+			resp, err := newClient(0).Get(target + "/helm.tar.gz")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Get() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			resp.Body.Close()
+
+			for _, name := range []string{"Authorization", "X-Team"} {
+				if strings.Join(got[name], ",") != strings.Join(tt.want[name], ",") {
+					t.Errorf("request had %s %q, want %q", name, got[name], tt.want[name])
+				}
+			}
+		})
+	}
+}
+
+func TestHookRunsOnce(t *testing.T) {
+	resetHook(t)
+	count := filepath.Join(tempDir(t), "count")
+	setenv(t, "HELM_WRAPPER_PRE_DOWNLOAD_HOOK", "echo run >> '"+count+"'; echo 'Authorization: Bearer fr3sh'")
+
+	srv := mirrorServer(t, map[string]string{"a": "a", "b": "b"})
+	for _, name := range []string{"a", "b", "a"} {
+		resp, err := newClient(0).Get(srv.URL + "/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	b, err := ioutil.ReadFile(count)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := strings.Count(string(b), "run"); n != 1 {
+		t.Errorf("hook ran %d times, want once per invocation", n)
+	}
+}
+
+// writeKubeconfig points KUBECONFIG at a kubeconfig whose cluster has the
+// given settings.
+func writeKubeconfig(t *testing.T, cluster string) {
+	t.Helper()
+
+	config := `apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: https://kubernetes.example.com
+` + cluster + `contexts:
+- name: test
+  context:
+    cluster: test
+current-context: test
+`
+
+	file := filepath.Join(tempDir(t), "config")
+	if err := ioutil.WriteFile(file, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	setenv(t, "KUBECONFIG", file)
+}
+
+func TestMirrorKubeconfigCA(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("archive"))
+	}))
+	defer srv.Close()
+
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	caFile := filepath.Join(tempDir(t), "ca.crt")
+	if err := ioutil.WriteFile(caFile, ca, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		useKube    string
+		cluster    string
+		wantSetup  bool
+		wantGetErr bool
+	}{
+		{"not trusted", "", "    certificate-authority-data: " + base64.StdEncoding.EncodeToString(ca) + "\n", true, true},
+		{"certificate-authority-data", "true", "    certificate-authority-data: " + base64.StdEncoding.EncodeToString(ca) + "\n", true, false},
+		{"certificate-authority", "true", "    certificate-authority: " + caFile + "\n", true, false},
+		{"no certificate authority", "true", "    insecure-skip-tls-verify: true\n", false, false},
+		{"not a certificate", "true", "    certificate-authority-data: " + base64.StdEncoding.EncodeToString([]byte("junk")) + "\n", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			restoreTransport(t)
+			unsetenv(t, "HELM_WRAPPER_MIRROR_CA")
+			unsetenv(t, "HELM_WRAPPER_CERT_PIN")
+			setenv(t, "HELM_WRAPPER_MIRROR_KUBECONFIG_CA", tt.useKube)
+			writeKubeconfig(t, tt.cluster)
+
+			err := setupTransport()
+			if (err == nil) != tt.wantSetup {
+				t.Fatalf("setupTransport() error = %v, want success %t", err, tt.wantSetup)
+			}
+			if err != nil {
+				return
+			}
+
+			resp, err := newClient(0).Get(srv.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err != nil) != tt.wantGetErr {
+				t.Errorf("Get() error = %v, wantErr %t", err, tt.wantGetErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// platform is an OS and architecture helm is released for.
+type platform struct {
+	os   string
+	arch string
+}
+
+// native is the platform the wrapper runs on.
+var native = platform{runtime.GOOS, runtime.GOARCH}
+
+// host is the platform helm is downloaded, cached and run for, which is native
+// unless forced otherwise by forcePlatform.
+var host = native
+
+// forcePlatform makes host the platform set with HELM_WRAPPER_FORCE_OS and
+// HELM_WRAPPER_FORCE_ARCH, e.g. to run amd64 helm under emulation. Binaries of
+// a forced platform are cached apart from native ones, see cacheKey.
+func forcePlatform() {
+	if goos := os.Getenv("HELM_WRAPPER_FORCE_OS"); goos != "" {
+		host.os = goos
+	}
+
+	if goarch := os.Getenv("HELM_WRAPPER_FORCE_ARCH"); goarch != "" {
+		host.arch = goarch
+	}
+
+	if host != native {
+		debugf("forcing helm for %s", host)
+	}
+}
+
+// knownOS are the operating systems Go builds for, which tell a platform
+// suffix apart from the tail of a prerelease version.
+var knownOS = map[string]bool{
+	"aix":       true,
+	"android":   true,
+	"darwin":    true,
+	"dragonfly": true,
+	"freebsd":   true,
+	"illumos":   true,
+	"ios":       true,
+	"js":        true,
+	"linux":     true,
+	"netbsd":    true,
+	"openbsd":   true,
+	"plan9":     true,
+	"solaris":   true,
+	"windows":   true,
+}
+
+// cacheKey returns the name helm v is cached under for the host platform,
+// which is the version itself, or with the platform appended when it's
+// forced, e.g. v2.16.12-linux-amd64. Binaries, their used markers and their
+// cache manifest entries all go by it, so binaries of different platforms
+// don't clash.
+func cacheKey(v string) string {
+	if host != native {
+		return fmt.Sprintf("%s-%s-%s", v, host.os, host.arch)
+	}
+
+	return v
+}
+
+// splitKey splits a cache key into the version and the platform it's for.
+func splitKey(key string) (string, platform) {
+	parts := strings.Split(key, "-")
+	if n := len(parts); n > 2 && knownOS[parts[n-2]] {
+		return strings.Join(parts[:n-2], "-"), platform{parts[n-2], parts[n-1]}
+	}
+
+	return key, native
+}
+
+// archive returns the file name of the helm v archive for p.
+func (p platform) archive(v string) string {
+	return fmt.Sprintf("helm-%s-%s-%s%s", v, p.os, p.arch, p.ext())
+}
+
+// ext returns the extension of helm archives for p, which is .zip on Windows
+// and .tar.gz everywhere else.
+func (p platform) ext() string {
+	if p.os == "windows" {
+		return ".zip"
+	}
+
+	return ".tar.gz"
+}
+
+func (p platform) String() string {
+	return p.os + "/" + p.arch
+}
+
+// archiveName returns the file name of the helm v archive for the host
+// platform.
+func archiveName(v string) string {
+	return host.archive(v)
+}
+
+// mirrorURL returns the download location of the release file name.
+func mirrorURL(name string) string {
+	return fmt.Sprintf("%s/%s", mirrorBase(), name)
+}
+
+// mirrorHost returns the host helm archives are downloaded from.
+func mirrorHost() string {
+	if u, err := url.Parse(settings.Mirror); err == nil && u.Host != "" {
+		return u.Host
+	}
+
+	return settings.Mirror
+}
+
+// archiveURL returns the download location of helm v for the host platform.
+func archiveURL(v string) string {
+	return mirrorURL(archiveName(v))
+}
+
+// archivePath returns where the helm v archive is downloaded to. The .tmp
+// suffix keeps it from being taken for a binary when the scratch directory is
+// the cache.
+func archivePath(v string) string {
+	return scratchPath(fmt.Sprintf("helm-%s%s.tmp", v, host.ext()))
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// forceHost makes p the host platform for the rest of t.
+func forceHost(t *testing.T, p platform) {
+	old := host
+	t.Cleanup(func() { host = old })
+	host = p
+}
+
+// foreign returns a platform other than the native one.
+func foreign() platform {
+	if native.arch == "s390x" {
+		return platform{"linux", "ppc64le"}
+	}
+
+	return platform{"linux", "s390x"}
+}
+
+func TestSplitKey(t *testing.T) {
+	tests := []struct {
+		key      string
+		wantV    string
+		wantPlat platform
+	}{
+		{"v2.16.12", "v2.16.12", native},
+		{"v3.0.0-rc.1", "v3.0.0-rc.1", native},
+		{"v3.0.0-beta-2", "v3.0.0-beta-2", native},
+		{"v2.16.12-linux-arm64", "v2.16.12", platform{"linux", "arm64"}},
+		{"v3.0.0-rc.1-darwin-amd64", "v3.0.0-rc.1", platform{"darwin", "amd64"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			v, p := splitKey(tt.key)
+			if v != tt.wantV || p != tt.wantPlat {
+				t.Errorf("splitKey() = %q, %v, want %q, %v", v, p, tt.wantV, tt.wantPlat)
+			}
+		})
+	}
+}
+
+func TestForcedPlatformCache(t *testing.T) {
+	dir := tempDir(t)
+	write := func(v string) {
+		if err := ioutil.WriteFile(binPath(dir, v), []byte(v), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := record(dir, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("v2.16.12")
+	forceHost(t, foreign())
+	write("v2.16.12")
+	write("v3.4.0")
+
+	vs, err := cached(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, c := range vs {
+		got = append(got, c.Version)
+	}
+	sort.Strings(got)
+	if want := []string{"v2.16.12", "v3.4.0"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("cached() = %v, want %v", got, want)
+	}
+
+	if err := fsck(dir, nil); err != nil {
+		t.Errorf("fsck() = %v, want every version OK", err)
+	}
+
+	// --repair records untracked binaries under the same keys.
+	if err := os.Remove(manifestPath(dir)); err != nil {
+		t.Fatal(err)
+	}
+	if err := fsck(dir, []string{"--repair"}); err != nil {
+		t.Fatal(err)
+	}
+	m, err := readManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if want := []string{cacheKey("v2.16.12"), cacheKey("v3.4.0")}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("manifest keys = %v, want %v", keys, want)
+	}
+
+	removed, err := prune(dir, "", retention{keepLatest: 1}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0].Version != "v2.16.12" {
+		t.Fatalf("prune() removed %v, want the forced v2.16.12", removed)
+	}
+
+	host = native
+	if _, err := os.Stat(binPath(dir, "v2.16.12")); err != nil {
+		t.Errorf("native v2.16.12 was removed: %v", err)
+	}
+}
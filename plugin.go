@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+const pluginUsage = `Usage: kubectl helm [+version] <helm command> [flags]
+
+kubectl helm runs the helm version matching the Tiller of the cluster, which is
+found through KUBECONFIG and --context like kubectl does. helm's own usage
+follows.
+`
+
+// pluginArgs adapts the arguments of the wrapper running as the kubectl-helm
+// plugin. kubectl's --context is translated to helm's --kube-context, and the
+// plugin's usage is printed ahead of helm's help.
+func pluginArgs(args []string) []string {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		fmt.Fprint(log.Writer(), pluginUsage)
+	}
+
+	out := make([]string, 0, len(args))
+	for i, a := range args {
+		if a == "--" {
+			return append(out, args[i:]...)
+		}
+
+		switch {
+		case a == "--context":
+			out = append(out, "--kube-context")
+		case strings.HasPrefix(a, "--context="):
+			out = append(out, "--kube-context="+strings.TrimPrefix(a, "--context="))
+		default:
+			out = append(out, a)
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPluginArgs(t *testing.T) {
+	tests := []struct {
+		args        string
+		want        string
+		kubeContext string
+		namespace   string
+		usage       bool
+	}{
+		{"--context prod status web", "--kube-context prod status web", "prod", "", false},
+		{"status web --context=prod", "status web --kube-context=prod", "prod", "", false},
+		{"--namespace team upgrade web stable/web", "--namespace team upgrade web stable/web", "", "team", false},
+		{"upgrade web stable/web --namespace=team", "upgrade web stable/web --namespace=team", "", "team", false},
+		{"list -n team", "list -n team", "", "team", false},
+		{"--context prod --namespace team list", "--kube-context prod --namespace team list", "prod", "team", false},
+		{"--kube-context prod list", "--kube-context prod list", "prod", "", false},
+		{"list -- --context prod", "list -- --context prod", "", "", false},
+		{"help", "help", "", "", true},
+		{"--help", "--help", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.args, func(t *testing.T) {
+			var got []string
+			stderr := captureStderr(t, func() { got = pluginArgs(strings.Fields(tt.args)) })
+
+			if want := strings.Fields(tt.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("pluginArgs(%q) = %q, want %q", tt.args, got, want)
+			}
+
+			target := parseTarget(got)
+			if target.kubeContext != tt.kubeContext || target.namespace != tt.namespace {
+				t.Errorf("pluginArgs(%q) targets context %q, namespace %q, want %q, %q", tt.args, target.kubeContext, target.namespace, tt.kubeContext, tt.namespace)
+			}
+
+			if usage := strings.Contains(stderr, "kubectl helm"); usage != tt.usage {
+				t.Errorf("pluginArgs(%q) printed the plugin's usage: %t, want %t", tt.args, usage, tt.usage)
+			}
+		})
+	}
+}
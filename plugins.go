@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+// pluginVar returns the environment variable helm v reads its plugin
+// directory from, which is HELM_PLUGIN for helm 2 and HELM_PLUGINS after.
+func pluginVar(v string) (string, error) {
+	sv, err := version.ParseSemantic(v)
+	if err != nil {
+		return "", err
+	}
+
+	if sv.Major() == 2 {
+		return "HELM_PLUGIN", nil
+	}
+
+	return "HELM_PLUGINS", nil
+}
+
+// pluginsDir returns the directory holding the plugin directories of each
+// helm major version, HELM_WRAPPER_PLUGINS_DIR or else, with
+// HELM_WRAPPER_SCOPE_PLUGINS, .plugins in the cache directory dir. It reports
+// false when neither is set and helm finds its plugins where it always does.
+func pluginsDir(dir string) (string, bool) {
+	if root := os.Getenv("HELM_WRAPPER_PLUGINS_DIR"); root != "" {
+		return root, true
+	}
+
+	if envBool("HELM_WRAPPER_SCOPE_PLUGINS") {
+		return filepath.Join(dir, ".plugins"), true
+	}
+
+	return "", false
+}
+
+// pluginEnv returns the environment giving helm v its own plugin directory
+// under pluginsDir, one per major version, so that e.g. a helm 2 plugin is
+// never loaded by helm 3. It's opt-in, as plugins already installed in the
+// user's helm home would no longer be found, and nothing is changed when the
+// user already chose a plugin directory.
+func pluginEnv(dir, v string) ([]string, error) {
+	name, err := pluginVar(v)
+	if err != nil {
+		return nil, err
+	}
+
+	root, ok := pluginsDir(dir)
+	if _, set := os.LookupEnv(name); set || !ok {
+		return nil, nil
+	}
+
+	plugins := pluginDir(root, v)
+	if err := dirs(plugins); err != nil {
+		return nil, err
+	}
+
+	return []string{name + "=" + plugins}, nil
+}
+
+// pluginDir returns the plugin directory of helm v under root.
+func pluginDir(root, v string) string {
+	sv, err := version.ParseSemantic(v)
+	if err != nil {
+		return filepath.Join(root, v)
+	}
+
+	return filepath.Join(root, fmt.Sprintf("v%d", sv.Major()))
+}
+
+// pluginEnvCmd prints the plugin environment of the helm version the given
+// helm arguments would run, e.g. for `eval $(helm-wrapper plugin-env)`.
+func pluginEnvCmd(dir string, args []string) error {
+	v, _, err := resolveArgs(dir, args)
+	if err != nil {
+		return err
+	}
+
+	name, err := pluginVar(v)
+	if err != nil {
+		return err
+	}
+
+	env, err := pluginEnv(dir, v)
+	if err != nil {
+		return err
+	}
+
+	path := os.Getenv(name)
+	if len(env) != 0 {
+		path = strings.TrimPrefix(env[0], name+"=")
+	}
+
+	// Without an override, ask helm where its plugins live.
+	if path == "" {
+		if name == "HELM_PLUGIN" {
+			out, err := exec.Command(helmBin(dir, v), "home").Output()
+			if err != nil {
+				return err
+			}
+			path = filepath.Join(strings.TrimSpace(string(out)), "plugins")
+		} else {
+			out, err := exec.Command(helmBin(dir, v), "env", "HELM_PLUGINS").Output()
+			if err != nil {
+				return err
+			}
+			path = strings.TrimSpace(string(out))
+		}
+	}
+
+	fmt.Printf("HELM_BIN=%q\n", helmBin(dir, v))
+	fmt.Printf("%s=%q\n", name, path)
+	return nil
+}
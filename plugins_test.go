@@ -0,0 +1,70 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPluginDir(t *testing.T) {
+	tests := []struct {
+		v    string
+		want string
+	}{
+		{"v2.16.12", "/plugins/v2"},
+		{"v2.14.3", "/plugins/v2"},
+		{"v3.4.0", "/plugins/v3"},
+		{"v3.4.0-rc.1", "/plugins/v3"},
+		{"latest", "/plugins/latest"},
+	}
+
+	for _, tt := range tests {
+		if got := pluginDir("/plugins", tt.v); got != filepath.FromSlash(tt.want) {
+			t.Errorf("pluginDir(%q) = %q, want %q", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestPluginEnv(t *testing.T) {
+	cache := tempDir(t)
+	root := tempDir(t)
+
+	tests := []struct {
+		name   string
+		root   string
+		scoped string
+		user   map[string]string
+		v      string
+		want   []string
+	}{
+		{"helm's own by default", "", "", nil, "v3.4.0", nil},
+		{"helm 2's own by default", "", "", nil, "v2.16.12", nil},
+		{"helm 2 scoped", "", "true", nil, "v2.16.12", []string{"HELM_PLUGIN=" + filepath.Join(cache, ".plugins", "v2")}},
+		{"helm 3 scoped", "", "true", nil, "v3.4.0", []string{"HELM_PLUGINS=" + filepath.Join(cache, ".plugins", "v3")}},
+		{"configured root", root, "", nil, "v3.4.0", []string{"HELM_PLUGINS=" + filepath.Join(root, "v3")}},
+		{"user's helm 3 directory", "", "true", map[string]string{"HELM_PLUGINS": "/mine"}, "v3.4.0", nil},
+		{"user's helm 2 directory for helm 3", "", "true", map[string]string{"HELM_PLUGIN": "/mine"}, "v3.4.0", []string{"HELM_PLUGINS=" + filepath.Join(cache, ".plugins", "v3")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setenv(t, "HELM_WRAPPER_PLUGINS_DIR", tt.root)
+			setenv(t, "HELM_WRAPPER_SCOPE_PLUGINS", tt.scoped)
+			for _, name := range []string{"HELM_PLUGIN", "HELM_PLUGINS"} {
+				unsetenv(t, name)
+			}
+			for name, value := range tt.user {
+				setenv(t, name, value)
+			}
+
+			got, err := pluginEnv(cache, tt.v)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pluginEnv() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+)
+
+// prefetch downloads the given helm versions into dir, or, without arguments,
+// the client default version and every version of Tiller found through the
+// contexts of the kubeconfig. The number of parallel downloads, and of
+// connections to each host, defaults to HELM_WRAPPER_DOWNLOAD_CONCURRENCY.
+func prefetch(dir string, args []string) error {
+	def, err := downloadConcurrency()
+	if err != nil {
+		return err
+	}
+
+	fs := flag.NewFlagSet("prefetch", flag.ContinueOnError)
+	concurrency := fs.Int("concurrency", def, "maximum number of parallel downloads")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *concurrency < 1 {
+		return fmt.Errorf("concurrency must be at least 1, got %d", *concurrency)
+	}
+
+	limitConns(*concurrency)
+
+	// 2.16.12 and v2.16.12 are the same download.
+	var vs []string
+	seen := map[string]bool{}
+	for _, v := range fs.Args() {
+		v = canonical(v)
+		if !seen[v] {
+			seen[v] = true
+			vs = append(vs, v)
+		}
+	}
+
+	if len(vs) == 0 {
+		var err error
+		if vs, err = inUse(dir); err != nil {
+			return err
+		}
+	}
+
+	// The workers share the scratch directory, so it's settled beforehand.
+	if err := useScratch(dir); err != nil {
+		return err
+	}
+
+	sem := make(chan struct{}, *concurrency)
+	errs := make([]error, len(vs))
+	var wg sync.WaitGroup
+	for i, v := range vs {
+		wg.Add(1)
+		go func(i int, v string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			errs[i] = ensure(v, dir)
+		}(i, v)
+	}
+	wg.Wait()
+
+	var failed int
+	for i, err := range errs {
+		if err != nil {
+			failed++
+			log.Printf("couldn't prefetch helm %s: %v", vs[i], err)
+			continue
+		}
+		fmt.Println(helmBin(dir, vs[i]))
+	}
+
+	if failed != 0 {
+		return fmt.Errorf("%d of %d versions failed to prefetch", failed, len(vs))
+	}
+
+	return nil
+}
+
+// inUse returns the client default version along with the Tiller versions of
+// all contexts in the kubeconfig. Contexts where detection fails are skipped.
+func inUse(dir string) ([]string, error) {
+	v, err := clientVersion(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ensure(v, dir); err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{v: true}
+
+	config, err := kubeconfig("").RawConfig()
+	if err != nil {
+		log.Printf("couldn't load kubeconfig: %v", err)
+		return []string{v}, nil
+	}
+
+	for name := range config.Contexts {
+		server, ok, err := serverVersion(context.Background(), v, dir, name)
+		if err != nil {
+			log.Printf("couldn't detect Tiller in context %s: %v", name, err)
+			continue
+		}
+		if ok {
+			seen[server] = true
+		}
+	}
+
+	var vs []string
+	for v := range seen {
+		vs = append(vs, v)
+	}
+	sort.Strings(vs)
+
+	return vs, nil
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mirrorStats counts what a versionMirror served.
+type mirrorStats struct {
+	sync.Mutex
+	conns    int
+	http2    bool
+	archives int
+	// inFlight is how many archives are being served, and maxInFlight the
+	// most that were at once.
+	inFlight, maxInFlight int
+}
+
+// versionMirror serves the archives of helm vs with their checksums as the
+// mirror, over HTTP/2, for the rest of t, and trusts its certificate. Each
+// archive takes delay to serve.
+func versionMirror(t *testing.T, delay time.Duration, vs ...string) *mirrorStats {
+	t.Helper()
+
+	archive, sum := helmArchive(t, "#!/bin/sh\n")
+	files := map[string]string{}
+	for _, v := range vs {
+		files[archiveName(v)] = archive
+		files[archiveName(v)+".sha256"] = sum
+	}
+
+	stats := &mirrorStats{}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/")
+		body, ok := files[name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		archive := !strings.HasSuffix(name, ".sha256")
+		stats.Lock()
+		stats.http2 = r.ProtoMajor == 2
+		if archive {
+			stats.archives++
+			stats.inFlight++
+			if stats.inFlight > stats.maxInFlight {
+				stats.maxInFlight = stats.inFlight
+			}
+		}
+		stats.Unlock()
+
+		if archive {
+			time.Sleep(delay)
+			stats.Lock()
+			stats.inFlight--
+			stats.Unlock()
+		}
+		w.Write([]byte(body))
+	}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			stats.Lock()
+			stats.conns++
+			stats.Unlock()
+		}
+	}
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	trust(t, srv)
+	old := settings
+	t.Cleanup(func() { settings = old })
+	settings.Mirror = srv.URL
+
+	return stats
+}
+
+// trust makes downloads trust the certificate of the TLS server srv for the
+// rest of t.
+func trust(t *testing.T, srv *httptest.Server) {
+	t.Helper()
+
+	ca := filepath.Join(tempDir(t), "ca.pem")
+	b := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := ioutil.WriteFile(ca, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	setenv(t, "HELM_WRAPPER_MIRROR_CA", ca)
+}
+
+func TestPrefetchReusesConnections(t *testing.T) {
+	vs := []string{"v2.14.3", "v2.16.12", "v3.3.4", "v3.4.0"}
+
+	tests := []struct {
+		name        string
+		concurrency string
+		maxConns    int
+	}{
+		{"sequential", "1", 1},
+		{"parallel", "4", 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			restoreTransport(t)
+			useTempScratch(t)
+			stats := versionMirror(t, 0, vs...)
+			if err := setupTransport(); err != nil {
+				t.Fatal(err)
+			}
+
+			dir := tempDir(t)
+			var err error
+			out := captureStdout(t, func() { err = prefetch(dir, append([]string{"--concurrency", tt.concurrency}, vs...)) })
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if n := strings.Count(out, "\n"); n != len(vs) {
+				t.Errorf("prefetch() printed %d binaries, want %d", n, len(vs))
+			}
+			if stats.archives != len(vs) {
+				t.Errorf("mirror served %d archives, want %d", stats.archives, len(vs))
+			}
+			if !stats.http2 {
+				t.Error("downloads didn't use HTTP/2")
+			}
+
+			// Every archive and checksum would take a connection of its own
+			// without reuse.
+			if stats.conns > tt.maxConns {
+				t.Errorf("downloads opened %d connections, want at most %d", stats.conns, tt.maxConns)
+			}
+		})
+	}
+}
+
+func TestPrefetchConcurrency(t *testing.T) {
+	vs := []string{"v2.14.3", "v2.15.2", "v2.16.12", "v3.2.4", "v3.3.4", "v3.4.0"}
+
+	tests := []struct {
+		name    string
+		env     string
+		args    []string
+		want    int
+		wantErr bool
+	}{
+		{"default", "", nil, defaultConcurrency, false},
+		{"flag", "", []string{"--concurrency", "2"}, 2, false},
+		{"environment", "3", nil, 3, false},
+		{"flag over the environment", "3", []string{"--concurrency", "1"}, 1, false},
+		{"zero", "", []string{"--concurrency", "0"}, 0, true},
+		{"invalid environment", "many", nil, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			restoreTransport(t)
+			useTempScratch(t)
+			setenv(t, "HELM_WRAPPER_DOWNLOAD_CONCURRENCY", tt.env)
+			stats := versionMirror(t, 50*time.Millisecond, vs...)
+			if tt.env != "many" {
+				if err := setupTransport(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var err error
+			captureStdout(t, func() { err = prefetch(tempDir(t), append(tt.args, vs...)) })
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("prefetch() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			// Six slow downloads fill every slot they're allowed.
+			if stats.maxInFlight != tt.want {
+				t.Errorf("prefetch() ran %d downloads at once, want %d", stats.maxInFlight, tt.want)
+			}
+			if stats.conns > tt.want {
+				t.Errorf("prefetch() opened %d connections, want at most %d", stats.conns, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrefetchDedup(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"distinct", []string{"v2.16.12", "v3.4.0"}, 2},
+		{"with and without v", []string{"2.16.12", "v2.16.12", "v3.4.0", "3.4.0"}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			restoreTransport(t)
+			setenv(t, "HELM_WRAPPER_USE_SYSTEM_HELM", "false")
+			// The workers start without a scratch directory.
+			old := scratch
+			t.Cleanup(func() { scratch = old })
+			scratch = ""
+			setenv(t, "HELM_WRAPPER_TMP_DIR", tempDir(t))
+			stats := versionMirror(t, 10*time.Millisecond, "v2.16.12", "v3.4.0")
+			if err := setupTransport(); err != nil {
+				t.Fatal(err)
+			}
+
+			var err error
+			out := captureStdout(t, func() { err = prefetch(tempDir(t), tt.args) })
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if stats.archives != tt.want {
+				t.Errorf("prefetch() downloaded %d archives, want %d", stats.archives, tt.want)
+			}
+			if n := strings.Count(out, "\n"); n != tt.want {
+				t.Errorf("prefetch() printed %d binaries, want %d:\n%s", n, tt.want, out)
+			}
+		})
+	}
+}
+
+func BenchmarkConnectionReuse(b *testing.B) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, 64<<10))
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	for _, reuse := range []bool{true, false} {
+		name := "reused"
+		if !reuse {
+			name = "fresh"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			t := srv.Client().Transport.(*http.Transport).Clone()
+			t.DisableKeepAlives = !reuse
+			t.MaxIdleConnsPerHost = idleConnsPerHost
+			c := &http.Client{Transport: t}
+			defer t.CloseIdleConnections()
+
+			for i := 0; i < b.N; i++ {
+				resp, err := c.Get(srv.URL)
+				if err != nil {
+					b.Fatal(err)
+				}
+				ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// probeResolver serves clusters running either helm generation by probing
+// for them in the order set with HELM_WRAPPER_PROBE_ORDER, tiller,release by
+// default: tiller picks the version of a running Tiller, release helm 3 if
+// the release acted on is stored by helm 3, and annotation, only run when
+// listed, the version in the helm-wrapper/version annotation of the
+// namespace. If no probe matches, it returns errUndetected.
+type probeResolver struct {
+	dir    string
+	target target
+}
+
+// probes are the probes probeResolver can run, by name. Each reports whether
+// it matched and, if so, the version to run.
+var probes = map[string]func(ctx context.Context, r probeResolver) (string, bool, error){
+	"annotation": func(ctx context.Context, r probeResolver) (string, bool, error) {
+		clientset, err := newClientset(r.target.kubeContext)
+		if err != nil {
+			return "", false, err
+		}
+
+		v, ok, err := namespaceVersion(ctx, clientset, r.target)
+		if err != nil || !ok {
+			return "", false, err
+		}
+
+		v, err = pin(v, r.dir)
+		return v, err == nil, err
+	},
+	"release": func(ctx context.Context, r probeResolver) (string, bool, error) {
+		clientset, err := newClientset(r.target.kubeContext)
+		if err != nil {
+			return "", false, err
+		}
+
+		ok, err := helm3Target(ctx, clientset, r.target)
+		if err != nil || !ok {
+			return "", false, err
+		}
+
+		v, err := helm3Version(r.dir)
+		return v, err == nil, err
+	},
+	"tiller": func(ctx context.Context, r probeResolver) (string, bool, error) {
+		clientset, err := newClientset(r.target.kubeContext)
+		if err != nil {
+			return "", false, err
+		}
+
+		ok, err := checkTiller(ctx, clientset)
+		if err != nil || !ok {
+			return "", false, err
+		}
+
+		v, err := tillerResolver{dir: r.dir, kubeContext: r.target.kubeContext}.Resolve(ctx)
+		if errors.Is(err, errUndetected) {
+			return "", false, nil
+		}
+		return v, err == nil, err
+	},
+}
+
+// probeOrder returns the names of the probes to run, in order.
+func probeOrder() ([]string, error) {
+	s := os.Getenv("HELM_WRAPPER_PROBE_ORDER")
+	if s == "" {
+		return []string{"tiller", "release"}, nil
+	}
+
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := probes[name]; !ok {
+			return nil, fmt.Errorf("invalid HELM_WRAPPER_PROBE_ORDER %q, unknown probe %q", s, name)
+		}
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
+func (r probeResolver) Resolve(ctx context.Context) (string, error) {
+	names, err := probeOrder()
+	if err != nil {
+		return "", err
+	}
+
+	for _, name := range names {
+		v, ok, err := probes[name](ctx, r)
+		if noContext(err) {
+			log.Printf("no current kube-context; skipping detection")
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+
+		if ok {
+			debugf("probe %s matched, using helm %s", name, v)
+			return v, nil
+		}
+	}
+
+	return "", errUndetected
+}
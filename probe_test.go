@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+func TestProbeOrder(t *testing.T) {
+	tests := []struct {
+		env     string
+		want    []string
+		wantErr bool
+	}{
+		{"", []string{"tiller", "release"}, false},
+		{"release", []string{"release"}, false},
+		{"annotation, release ,tiller", []string{"annotation", "release", "tiller"}, false},
+		{"tiller,helm3", nil, true},
+	}
+
+	for _, tt := range tests {
+		setenv(t, "HELM_WRAPPER_PROBE_ORDER", tt.env)
+
+		got, err := probeOrder()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("probeOrder() of %q error = %v, wantErr %t", tt.env, err, tt.wantErr)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("probeOrder() of %q = %v, want %v", tt.env, got, tt.want)
+		}
+	}
+}
+
+func TestProbeHelm3ConfigMapStorage(t *testing.T) {
+	resetIndex(t)
+	releaseServer(t, []release{{TagName: "v3.4.0"}, {TagName: "v2.16.12"}}, "v3.4.0", "v2.16.12")
+	settings.Version = "v2.16.12"
+	setenv(t, "HELM_WRAPPER_PROBE_ORDER", "tiller,release")
+
+	// Helm 3 run with HELM_DRIVER=configmap stores releases in config maps.
+	fakeCluster(t, &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "team-a",
+		Name:      "sh.helm.release.v1.web.v1",
+		Labels:    map[string]string{"owner": "helm", "name": "web"},
+	}})
+
+	dir := tempDir(t)
+	if err := ioutil.WriteFile(binPath(dir, "v3.4.0"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := probeResolver{dir: dir, target: target{namespace: "team-a", release: "web"}}.Resolve(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got != "v3.4.0" {
+		t.Errorf("Resolve() = %q, want the latest helm 3", got)
+	}
+}
+
+func TestProbeFailures(t *testing.T) {
+	tests := []struct {
+		name      string
+		clientset func() (kubernetes.Interface, error)
+		wantErr   error
+	}{
+		{
+			name: "no context",
+			clientset: func() (kubernetes.Interface, error) {
+				return nil, clientcmd.ErrEmptyConfig
+			},
+			wantErr: errUndetected,
+		},
+		{
+			name: "forbidden",
+			clientset: func() (kubernetes.Interface, error) {
+				clientset := fake.NewSimpleClientset()
+				clientset.PrependReactor("list", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+					return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: "pods"}, "", nil)
+				})
+				return clientset, nil
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setenv(t, "HELM_WRAPPER_PROBE_ORDER", "tiller,release")
+			old := newClientset
+			t.Cleanup(func() { newClientset = old })
+			newClientset = func(string) (kubernetes.Interface, error) { return tt.clientset() }
+
+			_, err := probeResolver{dir: tempDir(t)}.Resolve(context.Background())
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Resolve() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+
+			if !apierrors.IsForbidden(err) {
+				t.Errorf("Resolve() error = %v, want the API error", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// mutating are the helm subcommands that change what's in the cluster, by the
+// names command resolves their aliases to.
+var mutating = map[string]bool{
+	"install":   true,
+	"rollback":  true,
+	"uninstall": true,
+	"upgrade":   true,
+}
+
+// protect refuses to run mutating commands in args against one of the
+// protectedContexts from the config file when HELM_WRAPPER_PROTECT is set,
+// unless HELM_WRAPPER_CONFIRM names the context or, on a terminal, the user
+// confirms.
+func protect(args []string) error {
+	_, args = pinArg(args)
+	if !envBool("HELM_WRAPPER_PROTECT") || !mutating[command(subcommand(args))] {
+		return nil
+	}
+
+	kubeContext := currentContext(parseTarget(args).kubeContext)
+	if !protected(kubeContext) {
+		return nil
+	}
+
+	if os.Getenv("HELM_WRAPPER_CONFIRM") == kubeContext {
+		return nil
+	}
+
+	refused := withCode(exitRefused, fmt.Errorf("context %s is protected, set HELM_WRAPPER_CONFIRM=%s to run helm %s against it", kubeContext, kubeContext, subcommand(args)))
+
+	// Quiet runs can't show the prompt, so they can't be confirmed there.
+	if !isTerminal(os.Stdin) || envBool("HELM_WRAPPER_QUIET") {
+		return refused
+	}
+
+	fmt.Fprintf(log.Writer(), "Run helm %s against protected context %s? [y/N] ", subcommand(args), kubeContext)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+
+	return refused
+}
+
+// deny refuses to run the helm subcommand in args if it's one of those listed,
+// comma separated, in HELM_WRAPPER_DENY_COMMANDS, e.g. delete,reset, so that
+// locked-down environments never run them. Aliases are resolved on both sides,
+// so denying delete denies uninstall and un as well.
+func deny(args []string) error {
+	_, args = pinArg(args)
+	sub := subcommand(args)
+	if sub == "" {
+		return nil
+	}
+
+	for _, c := range strings.Split(os.Getenv("HELM_WRAPPER_DENY_COMMANDS"), ",") {
+		if command(strings.TrimSpace(c)) == command(sub) {
+			return withCode(exitDenied, fmt.Errorf("helm %s is denied by HELM_WRAPPER_DENY_COMMANDS", sub))
+		}
+	}
+
+	return nil
+}
+
+// protected reports whether kubeContext is one of the protected contexts.
+func protected(kubeContext string) bool {
+	for _, c := range settings.ProtectedContexts {
+		if c == kubeContext {
+			return true
+		}
+	}
+
+	return false
+}
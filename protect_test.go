@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// exitCode returns the exit code err would end the wrapper with, or 0 if it's
+// nil.
+func exitCode(err error) int {
+	var ce *codedError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &ce):
+		return ce.code
+	}
+
+	return exitInternal
+}
+
+func TestDeny(t *testing.T) {
+	tests := []struct {
+		denied string
+		args   []string
+		want   int
+	}{
+		{"", []string{"delete", "web"}, 0},
+		{"delete", []string{"delete", "web"}, exitDenied},
+		{"delete", []string{"uninstall", "web"}, exitDenied},
+		{"delete", []string{"un", "web"}, exitDenied},
+		{"delete", []string{"--kube-context", "prod", "del", "web"}, exitDenied},
+		{"uninstall", []string{"+v2.16.12", "delete", "--purge", "web"}, exitDenied},
+		{"reset, ls", []string{"list"}, exitDenied},
+		{"delete,reset", []string{"upgrade", "web", "stable/web"}, 0},
+		{"delete", []string{"--help"}, 0},
+		{"delete", []string{"--kube-token", "T", "delete", "web"}, exitDenied},
+		{"delete", []string{"--kube-apiserver", "https://10.0.0.1", "--kube-ca-file", "ca.crt", "uninstall", "web"}, exitDenied},
+		{"delete", []string{"--qps", "50", "--burst-limit", "100", "--kube-tls-server-name", "k8s", "del", "web"}, exitDenied},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.denied+"/"+tt.args[0], func(t *testing.T) {
+			setenv(t, "HELM_WRAPPER_DENY_COMMANDS", tt.denied)
+
+			if got := exitCode(deny(tt.args)); got != tt.want {
+				t.Errorf("deny(%q) exit code = %d, want %d", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProtect(t *testing.T) {
+	old := settings
+	t.Cleanup(func() { settings = old })
+	settings.ProtectedContexts = []string{"prod"}
+	setenv(t, "HELM_WRAPPER_PROTECT", "true")
+	pretendTerminal(t, false)
+
+	tests := []struct {
+		name    string
+		args    []string
+		confirm string
+		want    int
+	}{
+		{"read only", []string{"--kube-context", "prod", "status", "web"}, "", 0},
+		{"unprotected", []string{"--kube-context", "dev", "delete", "web"}, "", 0},
+		{"delete", []string{"--kube-context", "prod", "delete", "web"}, "", exitRefused},
+		{"uninstall alias", []string{"--kube-context", "prod", "un", "web"}, "", exitRefused},
+		{"del alias", []string{"--kube-context", "prod", "del", "web"}, "", exitRefused},
+		{"upgrade", []string{"upgrade", "--kube-context", "prod", "web", "stable/web"}, "", exitRefused},
+		{"confirmed", []string{"--kube-context", "prod", "uninstall", "web"}, "prod", 0},
+		{"confirmed elsewhere", []string{"--kube-context", "prod", "uninstall", "web"}, "dev", exitRefused},
+		{"value flag first", []string{"--kube-token", "T", "--kube-context", "prod", "delete", "web"}, "", exitRefused},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setenv(t, "HELM_WRAPPER_CONFIRM", tt.confirm)
+
+			if got := exitCode(protect(tt.args)); got != tt.want {
+				t.Errorf("protect(%q) exit code = %d, want %d", tt.args, got, tt.want)
+			}
+		})
+	}
+}
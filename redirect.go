@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// checkRedirect is the CheckRedirect of the download clients. With
+// HELM_WRAPPER_REDIRECT_HOSTS, a comma separated list of hosts, or of domains
+// as *.example.com, downloads only follow redirects staying on the host they
+// started on or going to a listed one, and never from https to http, so a
+// compromised redirect can't point them elsewhere. Without it, redirects are
+// followed like by default.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+
+	s := os.Getenv("HELM_WRAPPER_REDIRECT_HOSTS")
+	if s == "" {
+		return nil
+	}
+
+	from := via[0].URL
+	if from.Scheme == "https" && req.URL.Scheme != "https" {
+		return &redirectError{fmt.Sprintf("refusing redirect from %s to insecure %s", from.Host, req.URL)}
+	}
+
+	host := req.URL.Hostname()
+	if host == from.Hostname() {
+		return nil
+	}
+
+	for _, allowed := range strings.Split(s, ",") {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if strings.HasPrefix(allowed, "*.") && strings.HasSuffix(strings.ToLower(host), allowed[1:]) || strings.EqualFold(host, allowed) {
+			return nil
+		}
+	}
+
+	return &redirectError{fmt.Sprintf("refusing redirect from %s to %s, which isn't in HELM_WRAPPER_REDIRECT_HOSTS", from.Host, req.URL.Host)}
+}
+
+// redirectError is a redirect checkRedirect refused. Retrying it is
+// pointless.
+type redirectError struct {
+	msg string
+}
+
+func (e *redirectError) Error() string {
+	return e.msg
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCheckRedirect(t *testing.T) {
+	tests := []struct {
+		name    string
+		hosts   string
+		from    string
+		to      string
+		hops    int
+		wantErr bool
+	}{
+		{"unrestricted", "", "https://get.helm.sh/helm.tar.gz", "http://evil.example.org/helm.tar.gz", 1, false},
+		{"same host", "mirror.example.com", "https://get.helm.sh/helm.tar.gz", "https://get.helm.sh/v2/helm.tar.gz", 1, false},
+		{"listed host", "mirror.example.com", "https://get.helm.sh/helm.tar.gz", "https://Mirror.Example.com/helm.tar.gz", 1, false},
+		{"listed among others", "a.example.com, mirror.example.com", "https://get.helm.sh/helm.tar.gz", "https://mirror.example.com/helm.tar.gz", 1, false},
+		{"listed domain", "*.blob.core.windows.net", "https://get.helm.sh/helm.tar.gz", "https://helm.blob.core.windows.net/helm.tar.gz", 1, false},
+		{"domain itself", "*.blob.core.windows.net", "https://get.helm.sh/helm.tar.gz", "https://blob.core.windows.net/helm.tar.gz", 1, true},
+		{"lookalike domain", "*.example.com", "https://get.helm.sh/helm.tar.gz", "https://evilexample.com/helm.tar.gz", 1, true},
+		{"unlisted host", "mirror.example.com", "https://get.helm.sh/helm.tar.gz", "https://evil.example.org/helm.tar.gz", 1, true},
+		{"downgrade", "get.helm.sh", "https://get.helm.sh/helm.tar.gz", "http://get.helm.sh/helm.tar.gz", 1, true},
+		{"plain http mirror", "mirror.example.com", "http://mirror.internal/helm.tar.gz", "http://mirror.example.com/helm.tar.gz", 1, false},
+		{"too many", "", "https://get.helm.sh/helm.tar.gz", "https://get.helm.sh/helm.tar.gz", 10, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setenv(t, "HELM_WRAPPER_REDIRECT_HOSTS", tt.hosts)
+
+			var via []*http.Request
+			for i := 0; i < tt.hops; i++ {
+				via = append(via, httptest.NewRequest(http.MethodGet, tt.from, nil))
+			}
+
+			err := checkRedirect(httptest.NewRequest(http.MethodGet, tt.to, nil), via)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkRedirect() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDownloadRefusesRedirect(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("archive"))
+	}))
+	defer target.Close()
+
+	// The same server under another name.
+	elsewhere := strings.Replace(target.URL, "127.0.0.1", "localhost", 1)
+
+	var requests int
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Redirect(w, r, elsewhere+r.URL.Path, http.StatusFound)
+	}))
+	defer mirror.Close()
+
+	tests := []struct {
+		name    string
+		hosts   string
+		wantErr bool
+	}{
+		{"allowed", "localhost", false},
+		{"refused", "mirror.example.com", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setenv(t, "HELM_WRAPPER_REDIRECT_HOSTS", tt.hosts)
+			resetRetries(t)
+			setenv(t, "HELM_WRAPPER_RETRY_DELAY", "0s")
+			requests = 0
+
+			err := withRetries("helm", func() error {
+				resp, err := newClient(0).Get(mirror.URL + "/helm.tar.gz")
+				if err != nil {
+					return err
+				}
+				return resp.Body.Close()
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Get() error = %v, wantErr %t", err, tt.wantErr)
+			}
+
+			var re *redirectError
+			if tt.wantErr && !errors.As(err, &re) {
+				t.Errorf("Get() error = %v, want a redirectError", err)
+			}
+			if requests != 1 {
+				t.Errorf("requested the mirror %d times, want once without retries", requests)
+			}
+		})
+	}
+}
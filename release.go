@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/client-go/kubernetes"
+)
+
+// helm3Selector matches the secrets and config maps helm 3 stores releases in.
+const helm3Selector = "owner=helm"
+
+// releaseResolver resolves to a helm 3 version when the release t acts on, or
+// any release in t's namespace if there's no release name, is stored the way
+// helm 3 does, and otherwise falls back to Tiller detection. Helm 3 doesn't
+// record which client version wrote a release, so the client default version
+// is used if it's a helm 3 one, and else the latest helm 3 release.
+type releaseResolver struct {
+	dir    string
+	target target
+}
+
+func (r releaseResolver) Resolve(ctx context.Context) (string, error) {
+	clientset, err := newClientset(r.target.kubeContext)
+	if noContext(err) {
+		log.Printf("no current kube-context; skipping release detection")
+		return "", errUndetected
+	}
+	if err != nil {
+		return "", err
+	}
+
+	ok, err := helm3Target(ctx, clientset, r.target)
+	if err != nil {
+		return "", err
+	}
+
+	if !ok {
+		return tillerResolver{dir: r.dir, kubeContext: r.target.kubeContext}.Resolve(ctx)
+	}
+
+	return helm3Version(r.dir)
+}
+
+// helm3Target reports whether the release t acts on, or any release in t's
+// namespace if there's no release name, is stored by helm 3.
+func helm3Target(ctx context.Context, clientset kubernetes.Interface, t target) (bool, error) {
+	namespace := t.namespace
+	if namespace == "" {
+		var err error
+		if namespace, _, err = kubeconfig(t.kubeContext).Namespace(); err != nil {
+			return false, err
+		}
+	}
+
+	return helm3Release(ctx, clientset, namespace, t.release)
+}
+
+// helm3Version returns the client default version if it's a helm 3 one, and
+// else the latest helm 3 release.
+func helm3Version(dir string) (string, error) {
+	v, err := clientVersion(dir)
+	if err != nil {
+		return "", err
+	}
+
+	if sv, err := version.ParseSemantic(v); err == nil && sv.Major() == 3 {
+		return v, nil
+	}
+
+	debugf("found helm 3 releases, but the client default is %s, using the latest helm 3", v)
+	return pin("latest-3", dir)
+}
+
+// helm3Release reports whether release, or any release if it's empty, is
+// stored by helm 3 in namespace, either as a secret or as a config map.
+func helm3Release(ctx context.Context, clientset kubernetes.Interface, namespace, release string) (bool, error) {
+	listOptions := metav1.ListOptions{
+		LabelSelector: helm3Selector,
+		Limit:         1,
+	}
+	if release != "" {
+		listOptions.LabelSelector = fmt.Sprintf("%s,name=%s", helm3Selector, release)
+	}
+
+	secrets, err := clientset.CoreV1().Secrets(namespace).List(ctx, listOptions)
+	if err != nil {
+		return false, err
+	}
+
+	if len(secrets.Items) != 0 {
+		return true, nil
+	}
+
+	configMaps, err := clientset.CoreV1().ConfigMaps(namespace).List(ctx, listOptions)
+	if err != nil {
+		return false, err
+	}
+
+	return len(configMaps.Items) != 0, nil
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+// releasesURL is the GitHub API listing helm releases.
+var releasesURL = "https://api.github.com/repos/helm/helm/releases"
+
+// knownVersion is a release every mirror is expected to carry, which doctor
+// checks the mirror is reachable with.
+const knownVersion = "v2.16.12"
+
+// latestTTL is how long a resolved latest version is trusted before the
+// release index is consulted again.
+const latestTTL = time.Hour
+
+type release struct {
+	TagName    string `json:"tag_name"`
+	Draft      bool   `json:"draft"`
+	Prerelease bool   `json:"prerelease"`
+}
+
+// releases returns the tags of all published helm releases that aren't
+// drafts or marked as prereleases.
+func releases() ([]string, error) {
+	c := newClient(time.Second * 30)
+
+	var tags []string
+	for page := 1; page <= 10; page++ {
+		resp, err := c.Get(fmt.Sprintf("%s?per_page=100&page=%d", releasesURL, page))
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("couldn't fetch helm releases: %q", resp.Status)
+		}
+
+		var rs []release
+		err = json.NewDecoder(resp.Body).Decode(&rs)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(rs) == 0 {
+			break
+		}
+
+		for _, r := range rs {
+			if r.Draft || r.Prerelease {
+				continue
+			}
+			tags = append(tags, r.TagName)
+		}
+	}
+
+	return tags, nil
+}
+
+// stable returns the stable versions among tags, newest first. A major of 0
+// matches any major version.
+func stable(tags []string, major uint) []string {
+	type tagged struct {
+		tag string
+		v   *version.Version
+	}
+
+	var vs []tagged
+	for _, t := range tags {
+		sv, err := version.ParseSemantic(t)
+		if err != nil {
+			continue
+		}
+
+		if sv.PreRelease() != "" {
+			continue
+		}
+
+		if major != 0 && sv.Major() != major {
+			continue
+		}
+
+		vs = append(vs, tagged{t, sv})
+	}
+
+	sort.Slice(vs, func(i, j int) bool {
+		return vs[j].v.LessThan(vs[i].v)
+	})
+
+	out := make([]string, len(vs))
+	for i, v := range vs {
+		out[i] = v.tag
+	}
+
+	return out
+}
+
+// latest returns the highest stable version among tags. A major of 0 matches
+// any major version.
+func latest(tags []string, major uint) (string, error) {
+	vs := stable(tags, major)
+	if len(vs) == 0 {
+		if major != 0 {
+			return "", fmt.Errorf("no stable helm %d release found", major)
+		}
+		return "", fmt.Errorf("no stable helm release found")
+	}
+
+	return vs[0], nil
+}
+
+// latestAvailable returns the newest of the stable versions among tags whose
+// archive for the host platform can be downloaded. Binaries of a new release
+// are often published a while after its tag.
+func latestAvailable(tags []string, major uint) (string, error) {
+	if _, err := latest(tags, major); err != nil {
+		return "", err
+	}
+
+	return firstAvailable(stable(tags, major))
+}
+
+// firstAvailable returns the first of the versions vs, newest first, whose
+// archive for the host platform can be downloaded. Versions in a local index
+// are taken to be, without checking.
+func firstAvailable(vs []string) (string, error) {
+	idx, err := loadIndex()
+	if err != nil {
+		return "", err
+	}
+
+	if idx != nil {
+		return vs[0], nil
+	}
+
+	c := newClient(time.Second * 30)
+	for i, v := range vs {
+		if i == 5 {
+			break
+		}
+
+		ok, size, err := available(c, archiveURL(v))
+		if err != nil {
+			return "", err
+		}
+
+		if ok {
+			debugf("helm %s is available (%d bytes)", v, size)
+			return v, nil
+		}
+
+		debugf("helm %s has no archive for %s yet", v, host)
+	}
+
+	return "", fmt.Errorf("none of the newest helm releases since %s have an archive for %s", vs[0], host)
+}
+
+// latestPatch resolves v, a minor version pin like v2.16, to the newest patch
+// release of that minor version when HELM_WRAPPER_ALWAYS_DOWNLOAD_LATEST_PATCH
+// is set, reusing the previous resolution stored in dir while it's younger
+// than latestTTL. Otherwise, and for versions with a patch level like v2.16.7,
+// v is returned as is.
+func latestPatch(v, dir string) (string, error) {
+	if !envBool("HELM_WRAPPER_ALWAYS_DOWNLOAD_LATEST_PATCH") || hasPatch(v) {
+		return v, nil
+	}
+
+	sv, err := version.ParseGeneric(v)
+	if err != nil {
+		return "", fmt.Errorf("invalid helm version %q: %v", v, err)
+	}
+	minor := fmt.Sprintf("v%d.%d", sv.Major(), sv.Minor())
+
+	cache := latestCache(dir, "latest-"+minor)
+	if v, ok := cachedLatest(cache); ok {
+		return v, nil
+	}
+
+	tags, err := releaseIndex(dir)
+	if err != nil {
+		return "", err
+	}
+
+	var patches []string
+	for _, t := range stable(tags, sv.Major()) {
+		if tv, err := version.ParseSemantic(t); err == nil && tv.Minor() == sv.Minor() {
+			patches = append(patches, t)
+		}
+	}
+
+	if len(patches) == 0 {
+		return "", fmt.Errorf("no stable helm %s release found", minor)
+	}
+
+	resolved, err := firstAvailable(patches)
+	if err != nil {
+		return "", err
+	}
+
+	if resolved != canonical(v) {
+		debugf("using helm %s, the latest patch of %s", resolved, v)
+	}
+
+	if err := storeLatest(cache, resolved); err != nil {
+		return "", err
+	}
+
+	return resolved, nil
+}
+
+// hasPatch reports whether version v has a patch level, as v2.16.7 does and
+// v2.16 doesn't.
+func hasPatch(v string) bool {
+	core := strings.SplitN(strings.SplitN(v, "+", 2)[0], "-", 2)[0]
+	return strings.Count(core, ".") >= 2
+}
+
+// indexTTL returns how long the release index cached in dir is used before
+// it's fetched again, set with HELM_WRAPPER_INDEX_TTL and defaulting to
+// latestTTL.
+func indexTTL() (time.Duration, error) {
+	s := os.Getenv("HELM_WRAPPER_INDEX_TTL")
+	if s == "" {
+		return latestTTL, nil
+	}
+
+	ttl, err := time.ParseDuration(s)
+	if err != nil || ttl < 0 {
+		return 0, fmt.Errorf("invalid HELM_WRAPPER_INDEX_TTL %q", s)
+	}
+
+	return ttl, nil
+}
+
+// releaseIndex returns the tags of helm releases, cached in dir for indexTTL.
+// If they can't be fetched, an expired cache is used rather than failing. With
+// a local index, they're its versions instead.
+func releaseIndex(dir string) ([]string, error) {
+	idx, err := loadIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	if idx != nil {
+		return idx.tags(), nil
+	}
+
+	ttl, err := indexTTL()
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dir, ".releases.json")
+
+	var cached []string
+	fi, statErr := os.Stat(path)
+	if statErr == nil {
+		if b, err := ioutil.ReadFile(path); err == nil && json.Unmarshal(b, &cached) == nil && len(cached) != 0 {
+			if time.Since(fi.ModTime()) < ttl {
+				return cached, nil
+			}
+		}
+	}
+
+	tags, err := releases()
+	if err != nil {
+		if len(cached) == 0 {
+			return nil, err
+		}
+
+		log.Printf("couldn't refresh the helm release index (%v), using the one from %s", err, fi.ModTime().Format(time.RFC3339))
+		return cached, nil
+	}
+
+	b, err := json.Marshal(tags)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		log.Printf("couldn't cache the helm release index: %v", err)
+	}
+
+	return tags, nil
+}
+
+// parseLatest reports whether v is one of the latest pseudo-versions and which
+// major version it's restricted to.
+func parseLatest(v string) (uint, bool) {
+	switch v {
+	case "latest":
+		return 0, true
+	case "latest-2":
+		return 2, true
+	case "latest-3":
+		return 3, true
+	}
+
+	return 0, false
+}
+
+// resolveLatest resolves a latest pseudo-version, reusing the previous
+// resolution stored in dir while it's younger than latestTTL.
+func resolveLatest(v, dir string) (string, error) {
+	major, ok := parseLatest(v)
+	if !ok {
+		return "", fmt.Errorf("unknown version alias %q", v)
+	}
+
+	cache := latestCache(dir, v)
+	if v, ok := cachedLatest(cache); ok {
+		return v, nil
+	}
+
+	tags, err := releaseIndex(dir)
+	if err != nil {
+		return "", err
+	}
+
+	resolved, err := latestAvailable(tags, major)
+	if err != nil {
+		return "", err
+	}
+
+	if err := storeLatest(cache, resolved); err != nil {
+		return "", err
+	}
+
+	return resolved, nil
+}
+
+// latestCache returns the file in dir holding the resolution of name, e.g.
+// latest-2 or latest-v2.16, against the current release index, the local one
+// or else releasesURL, so that configuring another index takes effect
+// straight away rather than once the previous resolution expires.
+func latestCache(dir, name string) string {
+	source := os.Getenv("HELM_WRAPPER_INDEX_FILE")
+	if source == "" {
+		source = releasesURL
+	}
+
+	sum := sha256.Sum256([]byte(source))
+	return filepath.Join(dir, fmt.Sprintf(".%s-%s", name, hex.EncodeToString(sum[:6])))
+}
+
+// cachedLatest returns the resolution stored in path, unless it's older than
+// latestTTL.
+func cachedLatest(path string) (string, bool) {
+	fi, err := os.Stat(path)
+	if err != nil || time.Since(fi.ModTime()) >= latestTTL {
+		return "", false
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil || len(b) == 0 {
+		return "", false
+	}
+
+	return strings.TrimSpace(string(b)), true
+}
+
+// storeLatest stores the resolution v in path. It's written to a temporary
+// file renamed into place, so that a concurrent run never reads it partly
+// written.
+func storeLatest(path, v string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := tmp.WriteString(v); err != nil {
+		return err
+	}
+
+	if err := tmp.Chmod(0644); err != nil {
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var tags = []string{"v3.4.0-rc.1", "v3.3.4", "v2.17.0-rc.1", "v2.16.12", "v3.3.10", "v2.16.9", "not-a-version"}
+
+func TestStable(t *testing.T) {
+	tests := []struct {
+		major uint
+		want  []string
+	}{
+		{0, []string{"v3.3.10", "v3.3.4", "v2.16.12", "v2.16.9"}},
+		{2, []string{"v2.16.12", "v2.16.9"}},
+		{3, []string{"v3.3.10", "v3.3.4"}},
+		{4, []string{}},
+	}
+
+	for _, tt := range tests {
+		got := stable(tags, tt.major)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("stable(%d) = %v, want %v", tt.major, got, tt.want)
+		}
+	}
+}
+
+func TestLatest(t *testing.T) {
+	tests := []struct {
+		major   uint
+		want    string
+		wantErr bool
+	}{
+		{0, "v3.3.10", false},
+		{2, "v2.16.12", false},
+		{3, "v3.3.10", false},
+		{4, "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := latest(tags, tt.major)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("latest(%d) error = %v, wantErr %t", tt.major, err, tt.wantErr)
+		}
+
+		if got != tt.want {
+			t.Errorf("latest(%d) = %q, want %q", tt.major, got, tt.want)
+		}
+	}
+}
+
+func TestParseLatest(t *testing.T) {
+	tests := []struct {
+		v     string
+		major uint
+		ok    bool
+	}{
+		{"latest", 0, true},
+		{"latest-2", 2, true},
+		{"latest-3", 3, true},
+		{"latest-4", 0, false},
+		{"v3.3.4", 0, false},
+	}
+
+	for _, tt := range tests {
+		major, ok := parseLatest(tt.v)
+		if major != tt.major || ok != tt.ok {
+			t.Errorf("parseLatest(%q) = %d, %t, want %d, %t", tt.v, major, ok, tt.major, tt.ok)
+		}
+	}
+}
+
+// releaseServer serves rs as the GitHub release listing and a helm archive
+// for every version in available. The mirror and releases URL point at it
+// for the rest of t.
+func releaseServer(t *testing.T, rs []release, available ...string) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/releases" {
+			if r.URL.Query().Get("page") != "1" {
+				w.Write([]byte("[]"))
+				return
+			}
+			json.NewEncoder(w).Encode(rs)
+			return
+		}
+
+		for _, v := range available {
+			if strings.HasSuffix(r.URL.Path, "/"+archiveName(v)) {
+				w.Write([]byte("archive"))
+				return
+			}
+		}
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	oldSettings, oldURL := settings, releasesURL
+	t.Cleanup(func() { settings, releasesURL = oldSettings, oldURL })
+	settings.Mirror = srv.URL
+	releasesURL = srv.URL + "/releases"
+
+	return srv
+}
+
+func TestReleasesSkipsPrereleases(t *testing.T) {
+	releaseServer(t, []release{
+		{TagName: "v3.4.0-rc.1", Prerelease: true},
+		{TagName: "v3.4.0", Draft: true},
+		{TagName: "v3.3.4"},
+		{TagName: "v2.16.12"},
+	})
+
+	got, err := releases()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"v3.3.4", "v2.16.12"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("releases() = %v, want %v", got, want)
+	}
+}
+
+func TestResolveLatest(t *testing.T) {
+	rs := []release{
+		{TagName: "v3.5.0-rc.1"},
+		{TagName: "v3.4.1", Prerelease: true},
+		{TagName: "v3.4.0"},
+		{TagName: "v3.3.4"},
+		{TagName: "v2.16.12"},
+	}
+
+	tests := []struct {
+		alias     string
+		available []string
+		want      string
+	}{
+		{"latest", []string{"v3.4.0", "v3.3.4", "v2.16.12"}, "v3.4.0"},
+		{"latest-2", []string{"v3.4.0", "v3.3.4", "v2.16.12"}, "v2.16.12"},
+		{"latest-3", []string{"v3.3.4"}, "v3.3.4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.alias, func(t *testing.T) {
+			resetIndex(t)
+			releaseServer(t, rs, tt.available...)
+
+			got, err := resolveLatest(tt.alias, tempDir(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tt.want {
+				t.Errorf("resolveLatest(%q) = %q, want %q", tt.alias, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultVersionIsLatest(t *testing.T) {
+	resetIndex(t)
+	releaseServer(t, []release{{TagName: "v3.5.0-rc.1"}, {TagName: "v3.4.0"}}, "v3.4.0")
+	settings.Version = defaultVersion
+
+	got, err := clientVersion(tempDir(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got != "v3.4.0" {
+		t.Errorf("clientVersion() = %q, want v3.4.0", got)
+	}
+}
+
+func TestLatestCache(t *testing.T) {
+	resetIndex(t)
+	releaseServer(t, []release{{TagName: "v3.4.0"}, {TagName: "v3.3.4"}}, "v3.4.0", "v3.3.4")
+	setenv(t, "HELM_WRAPPER_INDEX_FILE", "")
+	dir := tempDir(t)
+
+	steps := []struct {
+		name  string
+		index string
+		want  string
+	}{
+		{"releases", "", "v3.4.0"},
+		{"newly configured index", "versions:\n  - version: v3.5.0\n", "v3.5.0"},
+		{"releases again", "", "v3.4.0"},
+	}
+
+	for _, step := range steps {
+		resetIndex(t)
+		setenv(t, "HELM_WRAPPER_INDEX_FILE", "")
+		if step.index != "" {
+			useIndex(t, step.index)
+		}
+
+		got, err := resolveLatest("latest-3", dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != step.want {
+			t.Errorf("%s: resolveLatest() = %q, want %q", step.name, got, step.want)
+		}
+	}
+
+	for _, name := range names(t, dir) {
+		if strings.HasSuffix(name, ".tmp") {
+			t.Errorf("resolving left %s behind", name)
+		}
+	}
+}
+
+// resetIndex makes loadIndex read HELM_WRAPPER_INDEX_FILE again.
+func resetIndex(t *testing.T) {
+	index.once = sync.Once{}
+	index.idx, index.err = nil, nil
+	t.Cleanup(func() {
+		index.once = sync.Once{}
+		index.idx, index.err = nil, nil
+	})
+}
+
+func TestLatestPatch(t *testing.T) {
+	rs := []release{
+		{TagName: "v3.4.0"},
+		{TagName: "v2.17.0-rc.1"},
+		{TagName: "v2.16.12"},
+		{TagName: "v2.16.11"},
+		{TagName: "v2.15.2"},
+	}
+
+	tests := []struct {
+		name  string
+		mode  bool
+		v     string
+		cache string
+		age   time.Duration
+		want  string
+	}{
+		{"off", false, "v2.16", "", 0, "v2.16"},
+		{"minor pin", true, "v2.16", "", 0, "v2.16.12"},
+		{"patch pin", true, "v2.16.7", "", 0, "v2.16.7"},
+		{"prerelease pin", true, "v2.17.0-rc.1", "", 0, "v2.17.0-rc.1"},
+		{"fresh resolution", true, "v2.16", "v2.16.11", time.Minute, "v2.16.11"},
+		{"stale resolution", true, "v2.16", "v2.16.11", 2 * latestTTL, "v2.16.12"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetIndex(t)
+			releaseServer(t, rs, "v2.16.12", "v2.16.11")
+			setenv(t, "HELM_WRAPPER_ALWAYS_DOWNLOAD_LATEST_PATCH", strconv.FormatBool(tt.mode))
+
+			dir := tempDir(t)
+			if tt.cache != "" {
+				path := latestCache(dir, "latest-v2.16")
+				if err := ioutil.WriteFile(path, []byte(tt.cache), 0644); err != nil {
+					t.Fatal(err)
+				}
+				mtime := time.Now().Add(-tt.age)
+				if err := os.Chtimes(path, mtime, mtime); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := latestPatch(tt.v, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tt.want {
+				t.Errorf("latestPatch(%q) = %q, want %q", tt.v, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// VersionResolver decides which helm version to run. Resolve returns
+// errUndetected when it finds nothing to go by, so that the caller can tell
+// that apart from detecting the client default version.
+type VersionResolver interface {
+	Resolve(ctx context.Context) (string, error)
+}
+
+// errUndetected is returned by resolvers that couldn't detect a version, in
+// which case the client default version is run.
+var errUndetected = errors.New("no helm version detected")
+
+// resolvers maps the names of the resolution strategies, selected with the
+// strategy setting, to their constructors.
+var resolvers = map[string]func(dir string, t target) VersionResolver{
+	"annotation": func(dir string, t target) VersionResolver {
+		return annotationResolver{dir: dir, target: t}
+	},
+	"configmap": func(dir string, t target) VersionResolver {
+		return configMapResolver{dir: dir, kubeContext: t.kubeContext}
+	},
+	"pin": func(dir string, t target) VersionResolver {
+		return pinResolver{dir: dir}
+	},
+	"probe": func(dir string, t target) VersionResolver {
+		return probeResolver{dir: dir, target: t}
+	},
+	"release": func(dir string, t target) VersionResolver {
+		return releaseResolver{dir: dir, target: t}
+	},
+	"tiller": func(dir string, t target) VersionResolver {
+		return tillerResolver{dir: dir, kubeContext: t.kubeContext}
+	},
+}
+
+// canonical normalises a helm version to the form used in download URLs and
+// cache file names, e.g. " 2.16.7\n" to "v2.16.7".
+func canonical(v string) string {
+	v = strings.TrimSpace(v)
+	if v != "" && !strings.HasPrefix(v, "v") {
+		v = "v" + v
+	}
+
+	return v
+}
+
+// newResolver returns the configured resolution strategy for a command talking
+// to t.
+func newResolver(dir string, t target) (VersionResolver, error) {
+	newR, ok := resolvers[settings.Strategy]
+	if !ok {
+		var names []string
+		for name := range resolvers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown strategy %q, must be one of %s", settings.Strategy, strings.Join(names, ", "))
+	}
+
+	return newR(dir, t), nil
+}
+
+// pinResolver never detects anything, so the client default version is
+// always run.
+type pinResolver struct {
+	dir string
+}
+
+func (r pinResolver) Resolve(ctx context.Context) (string, error) {
+	return "", errUndetected
+}
+
+// tillerResolver resolves to the version of Tiller in the cluster of
+// kubeContext, or returns errUndetected if there's none.
+type tillerResolver struct {
+	dir         string
+	kubeContext string
+}
+
+func (r tillerResolver) Resolve(ctx context.Context) (_ string, err error) {
+	s := startSpan("detect")
+	defer func() { s.finish(err) }()
+
+	ttl, err := detectTTL()
+	if err != nil {
+		return "", err
+	}
+
+	var v string
+	if ttl == 0 {
+		v, err = r.detect(ctx)
+	} else {
+		v, err = r.detectCached(ctx, ttl)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if v == "" {
+		s.set("helm.detected", false)
+		return "", errUndetected
+	}
+	s.set("helm.version", v)
+
+	return v, nil
+}
+
+// detectCached is detect with its result, including detecting nothing, cached
+// for ttl.
+func (r tillerResolver) detectCached(ctx context.Context, ttl time.Duration) (string, error) {
+	kubeContext := currentContext(r.kubeContext)
+	if v, ok := cachedDetection(r.dir, kubeContext, ttl); ok {
+		return v, nil
+	}
+
+	v, err := r.detect(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if err := storeDetection(r.dir, kubeContext, v); err != nil {
+		log.Printf("couldn't cache detected version: %v", err)
+	}
+
+	return v, nil
+}
+
+// detect returns the version of Tiller, or "" if there's none.
+func (r tillerResolver) detect(ctx context.Context) (string, error) {
+	v, err := local(r.dir)
+	if err != nil {
+		return "", err
+	}
+
+	server, ok, err := serverVersion(ctx, v, r.dir, r.kubeContext)
+	if err != nil || !ok {
+		return "", err
+	}
+
+	// Custom Tiller builds report versions like v2.16.7+abc123, which
+	// aren't released. Fall back to the matching release, if there's one.
+	stripped := stripBuild(server)
+	if stripped == server {
+		return server, nil
+	}
+
+	if err := ensure(stripped, r.dir); err != nil {
+		log.Printf("Tiller reports %s, but helm %s isn't available (%v), using %s", server, stripped, err, v)
+		return "", nil
+	}
+
+	log.Printf("Tiller reports %s, using helm %s", server, stripped)
+	return stripped, nil
+}
+
+// stripBuild removes build metadata, e.g. +abc123, from a version.
+func stripBuild(v string) string {
+	if i := strings.Index(v, "+"); i != -1 {
+		return v[:i]
+	}
+
+	return v
+}
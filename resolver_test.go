@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestCanonical(t *testing.T) {
+	tests := []struct {
+		v    string
+		want string
+	}{
+		{"v2.16.7", "v2.16.7"},
+		{" 2.16.7\n", "v2.16.7"},
+		{"3.4.0-rc.1", "v3.4.0-rc.1"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := canonical(tt.v); got != tt.want {
+			t.Errorf("canonical(%q) = %q, want %q", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestStripBuild(t *testing.T) {
+	tests := []struct {
+		v    string
+		want string
+	}{
+		{"v2.16.7", "v2.16.7"},
+		{"v2.16.7+abc123", "v2.16.7"},
+		{"v3.0.0-rc.1+g1234", "v3.0.0-rc.1"},
+	}
+
+	for _, tt := range tests {
+		if got := stripBuild(tt.v); got != tt.want {
+			t.Errorf("stripBuild(%q) = %q, want %q", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestNewResolver(t *testing.T) {
+	old := settings
+	t.Cleanup(func() { settings = old })
+
+	for name := range resolvers {
+		settings.Strategy = name
+		if _, err := newResolver(tempDir(t), target{}); err != nil {
+			t.Errorf("newResolver() of strategy %s: %v", name, err)
+		}
+	}
+
+	settings.Strategy = "newest"
+	if _, err := newResolver(tempDir(t), target{}); err == nil {
+		t.Error("newResolver() of an unknown strategy succeeded")
+	}
+}
+
+// annotated returns namespace with the helm-wrapper/version annotation v.
+func annotated(namespace, v string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        namespace,
+		Annotations: map[string]string{versionAnnotation: v},
+	}}
+}
+
+// versionConfigMapOf returns the default version ConfigMap set to v.
+func versionConfigMapOf(v string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "helm-wrapper"},
+		Data:       map[string]string{versionKey: v},
+	}
+}
+
+// helm3Secret returns the secret helm 3 stores release in namespace in.
+func helm3Secret(namespace, release string) *corev1.Secret {
+	return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Namespace: namespace,
+		Name:      "sh.helm.release.v1." + release + ".v1",
+		Labels:    map[string]string{"owner": "helm", "name": release},
+	}}
+}
+
+func TestResolvers(t *testing.T) {
+	oldDelay := serverVersionDelay
+	t.Cleanup(func() { serverVersionDelay = oldDelay })
+	serverVersionDelay = 0
+
+	tiller := tillerPod("kube-system", "tiller-deploy-1", "v2.14.3")
+
+	tests := []struct {
+		strategy string
+		objects  []runtime.Object
+		probes   string
+		want     string
+	}{
+		{"pin", []runtime.Object{tiller}, "", ""},
+		{"tiller", []runtime.Object{tiller}, "", "v2.14.3"},
+		{"tiller", nil, "", ""},
+		{"annotation", []runtime.Object{annotated("team-a", "2.15.2")}, "", "v2.15.2"},
+		{"annotation", []runtime.Object{annotated("team-b", "2.15.2"), tiller}, "", "v2.14.3"},
+		{"annotation", nil, "", ""},
+		{"configmap", []runtime.Object{versionConfigMapOf("v3.4.0"), tiller}, "", "v3.4.0"},
+		{"configmap", []runtime.Object{tiller}, "", "v2.14.3"},
+		{"configmap", nil, "", ""},
+		{"release", []runtime.Object{helm3Secret("team-a", "web"), tiller}, "", "v3.4.0"},
+		{"release", []runtime.Object{helm3Secret("team-a", "api"), tiller}, "", "v2.14.3"},
+		{"release", nil, "", ""},
+		{"probe", []runtime.Object{tiller, helm3Secret("team-a", "web")}, "", "v2.14.3"},
+		{"probe", []runtime.Object{helm3Secret("team-a", "web")}, "", "v3.4.0"},
+		{"probe", []runtime.Object{tiller, annotated("team-a", "2.15.2")}, "annotation,tiller", "v2.15.2"},
+		{"probe", []runtime.Object{annotated("team-a", "2.15.2")}, "tiller,release", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			resetIndex(t)
+			releaseServer(t, []release{{TagName: "v3.4.0"}, {TagName: "v2.16.12"}}, "v3.4.0", "v2.16.12")
+			settings.Version = "v2.16.12"
+			settings.Strategy = tt.strategy
+			setenv(t, "HELM_WRAPPER_DETECT_TTL", "0")
+			setenv(t, "HELM_WRAPPER_PROBE_ORDER", tt.probes)
+			fakeCluster(t, tt.objects...)
+
+			dir := tempDir(t)
+			fakeHelm(t, dir, "v2.16.12", "Server: v2.14.3+g0e7f3b6", false)
+			for _, v := range []string{"v2.14.3", "v2.15.2", "v3.4.0"} {
+				if err := ioutil.WriteFile(binPath(dir, v), []byte("#!/bin/sh\n"), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			r, err := newResolver(dir, target{namespace: "team-a", release: "web"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := r.Resolve(context.Background())
+			if tt.want == "" {
+				if !errors.Is(err, errUndetected) {
+					t.Errorf("Resolve() = %q, %v, want errUndetected", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tt.want {
+				t.Errorf("Resolve() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTillerBuildMetadata(t *testing.T) {
+	oldDelay := serverVersionDelay
+	t.Cleanup(func() { serverVersionDelay = oldDelay })
+	serverVersionDelay = 0
+
+	tests := []struct {
+		name   string
+		server string
+		cached bool
+		want   string
+	}{
+		{"release", "Server: v2.14.3", false, "v2.14.3"},
+		{"custom build of a cached release", "Server: v2.14.3+abc123", true, "v2.14.3"},
+		{"git build of a cached release", "Server: v2.14.3+g0e7f3b6", true, "v2.14.3"},
+		{"custom build of an unavailable release", "Server: v2.14.3+abc123", false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetIndex(t)
+			releaseServer(t, []release{{TagName: "v2.16.12"}})
+			settings.Version = "v2.16.12"
+			setenv(t, "HELM_WRAPPER_DETECT_TTL", "0")
+			fakeCluster(t, tillerPod("kube-system", "tiller-deploy-1", "v2.14.3"))
+
+			dir := tempDir(t)
+			fakeHelm(t, dir, "v2.16.12", tt.server, false)
+			if tt.cached {
+				if err := ioutil.WriteFile(binPath(dir, "v2.14.3"), []byte("#!/bin/sh\n"), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := tillerResolver{dir: dir}.Resolve(context.Background())
+			if tt.want == "" {
+				if !errors.Is(err, errUndetected) {
+					t.Errorf("Resolve() = %q, %v, want errUndetected", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tt.want {
+				t.Errorf("Resolve() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
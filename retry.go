@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// maxRetryDelay caps the backoff between download attempts.
+const maxRetryDelay = 30 * time.Second
+
+// retries counts the retries left to all downloads of this run, so that e.g.
+// prefetch doesn't keep hammering a mirror that's down. It's set by
+// retryPolicy.
+var retries int32 = -1
+
+// retryPolicy returns how many attempts each download gets and the base delay
+// of the backoff between them, set with HELM_WRAPPER_DOWNLOAD_ATTEMPTS and
+// HELM_WRAPPER_RETRY_DELAY, and defaulting to 3 and 1s. HELM_WRAPPER_RETRY_BUDGET
+// limits the retries of all downloads of a run together, 10 by default.
+func retryPolicy() (int, time.Duration, error) {
+	attempts, err := envInt("HELM_WRAPPER_DOWNLOAD_ATTEMPTS", 3)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	delay := time.Second
+	if s := os.Getenv("HELM_WRAPPER_RETRY_DELAY"); s != "" {
+		if delay, err = time.ParseDuration(s); err != nil || delay < 0 {
+			return 0, 0, fmt.Errorf("invalid HELM_WRAPPER_RETRY_DELAY %q", s)
+		}
+	}
+
+	budget, err := envInt("HELM_WRAPPER_RETRY_BUDGET", 10)
+	if err != nil {
+		return 0, 0, err
+	}
+	if atomic.CompareAndSwapInt32(&retries, -1, int32(budget)) {
+		// Runs started together must not jitter alike.
+		rand.Seed(time.Now().UnixNano())
+	}
+
+	return attempts, delay, nil
+}
+
+// envInt returns the positive integer in the environment variable name, or def
+// if it's not set.
+func envInt(name string, def int) (int, error) {
+	s := os.Getenv(name)
+	if s == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid %s %q", name, s)
+	}
+
+	return n, nil
+}
+
+// backoff returns the delay before retry attempt, counting from 1, with full
+// jitter: a random delay up to base doubled for each earlier attempt, so that
+// runs failing together don't retry in lockstep.
+func backoff(attempt int, base time.Duration) time.Duration {
+	if base <= 0 {
+		return 0
+	}
+
+	ceiling := base << uint(attempt-1)
+	if ceiling > maxRetryDelay || ceiling <= 0 {
+		ceiling = maxRetryDelay
+	}
+
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// withRetries calls fn until it succeeds, fails permanently or runs out of
+// attempts or of the run's retry budget, backing off in between.
+func withRetries(what string, fn func() error) error {
+	attempts, base, err := retryPolicy()
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !retryable(err) || attempt >= attempts || !spendRetry() {
+			return err
+		}
+
+		d := backoff(attempt, base)
+		log.Printf("couldn't download %s (%v), retrying in %s", what, err, d.Round(time.Millisecond))
+		time.Sleep(d)
+	}
+}
+
+// spendRetry takes a retry from the run's budget, and reports whether there
+// was one left. An exhausted budget stays at zero rather than going negative,
+// which would read as not set yet and refill it.
+func spendRetry() bool {
+	for {
+		n := atomic.LoadInt32(&retries)
+		if n <= 0 {
+			return false
+		}
+
+		if atomic.CompareAndSwapInt32(&retries, n, n-1) {
+			return true
+		}
+	}
+}
+
+// statusError is an unexpected HTTP response status.
+type statusError struct {
+	url    string
+	status string
+	code   int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("couldn't download %s: %q", e.url, e.status)
+}
+
+// retryable reports whether a download failing with err may succeed when
+// retried, which isn't the case for client errors like 404 Not Found.
+func retryable(err error) bool {
+	if se, ok := err.(*statusError); ok {
+		return se.code >= 500 || se.code == 429
+	}
+
+	if _, ok := err.(*spaceError); ok {
+		return false
+	}
+
+	var re *redirectError
+	if errors.As(err, &re) {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// resetRetries gives the run a fresh retry budget, and again after t.
+func resetRetries(t *testing.T) {
+	retries = -1
+	t.Cleanup(func() { retries = -1 })
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		base    time.Duration
+		max     time.Duration
+	}{
+		{1, time.Second, time.Second},
+		{2, time.Second, 2 * time.Second},
+		{4, time.Second, 8 * time.Second},
+		{6, time.Second, maxRetryDelay},
+		{80, time.Second, maxRetryDelay},
+		{3, 0, 0},
+	}
+
+	for _, tt := range tests {
+		seen := map[time.Duration]bool{}
+		for i := 0; i < 100; i++ {
+			d := backoff(tt.attempt, tt.base)
+			if d < 0 || d > tt.max {
+				t.Fatalf("backoff(%d, %s) = %s, want at most %s", tt.attempt, tt.base, d, tt.max)
+			}
+			seen[d] = true
+		}
+
+		if tt.max != 0 && len(seen) < 10 {
+			t.Errorf("backoff(%d, %s) gave only %d distinct delays, want jitter", tt.attempt, tt.base, len(seen))
+		}
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&statusError{code: http.StatusServiceUnavailable}, true},
+		{&statusError{code: http.StatusTooManyRequests}, true},
+		{&statusError{code: http.StatusNotFound}, false},
+		{&statusError{code: http.StatusForbidden}, false},
+		{&spaceError{dir: "/tmp", need: 2, free: 1}, false},
+		{&redirectError{}, false},
+		{errors.New("connection reset by peer"), true},
+	}
+
+	for _, tt := range tests {
+		if got := retryable(tt.err); got != tt.want {
+			t.Errorf("retryable(%T %v) = %t, want %t", tt.err, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestWithRetries(t *testing.T) {
+	unavailable := &statusError{code: http.StatusServiceUnavailable}
+	notFound := &statusError{code: http.StatusNotFound}
+
+	tests := []struct {
+		name     string
+		attempts string
+		budget   string
+		errs     []error
+		want     int
+		wantErr  error
+	}{
+		{"first time", "", "", []error{nil}, 1, nil},
+		{"recovering", "", "", []error{unavailable, unavailable, nil}, 3, nil},
+		{"out of attempts", "", "", []error{unavailable, unavailable, unavailable, nil}, 3, unavailable},
+		{"more attempts", "5", "", []error{unavailable, unavailable, unavailable, nil}, 4, nil},
+		{"permanent", "", "", []error{notFound, nil}, 1, notFound},
+		{"out of budget", "5", "1", []error{unavailable, unavailable, nil}, 2, unavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetRetries(t)
+			setenv(t, "HELM_WRAPPER_DOWNLOAD_ATTEMPTS", tt.attempts)
+			setenv(t, "HELM_WRAPPER_RETRY_BUDGET", tt.budget)
+			setenv(t, "HELM_WRAPPER_RETRY_DELAY", "0s")
+
+			var calls int
+			err := withRetries("helm", func() error {
+				calls++
+				return tt.errs[calls-1]
+			})
+			if err != tt.wantErr {
+				t.Errorf("withRetries() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if calls != tt.want {
+				t.Errorf("withRetries() called fn %d times, want %d", calls, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryBudgetIsShared(t *testing.T) {
+	resetRetries(t)
+	useTempScratch(t)
+	setenv(t, "HELM_WRAPPER_DOWNLOAD_ATTEMPTS", "3")
+	setenv(t, "HELM_WRAPPER_RETRY_BUDGET", "3")
+	setenv(t, "HELM_WRAPPER_RETRY_DELAY", "1ms")
+
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(srv.Close)
+
+	old := settings
+	t.Cleanup(func() { settings = old })
+	settings.Mirror = srv.URL
+
+	// The first download takes two of the budget's three retries, leaving
+	// one to the second and none to the third.
+	for i, want := range []int{3, 2, 1} {
+		before := requests
+		if err := download("v2.16." + strconv.Itoa(i)); err == nil {
+			t.Fatal("download() from a mirror that's down succeeded")
+		}
+
+		if got := requests - before; got != want {
+			t.Errorf("download %d took %d requests, want %d", i+1, got, want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// minScratchSpace is the free space a scratch directory needs to hold a helm
+// archive with room to spare.
+const minScratchSpace = 64 << 20
+
+// scratch is the directory archives are downloaded to before extraction, set
+// by useScratch.
+var scratch string
+
+// useScratch sets scratch to the scratchDir of the cache directory dir, unless
+// it's already set, and removes archives there that interrupted downloads left
+// behind. It's only called on the way to a download, so that runs using cached
+// binaries don't touch the temp directory.
+func useScratch(dir string) error {
+	if scratch != "" {
+		return nil
+	}
+
+	d, err := scratchDir(dir)
+	if err != nil {
+		return withCode(exitConfig, err)
+	}
+	scratch = d
+
+	// Partial files in the cache directory are swept on every run.
+	if scratch != dir {
+		if err := sweepArchives(scratch); err != nil {
+			log.Printf("couldn't remove partial downloads from %s: %v", scratch, err)
+		}
+	}
+
+	return nil
+}
+
+// scratchDir returns HELM_WRAPPER_TMP_DIR if set, which must be usable, or
+// else the first of the system temp directory and the cache directory that's
+// writable and has space for an archive. Hardened containers often mount /tmp
+// read-only or size-limited.
+func scratchDir(dir string) (string, error) {
+	if d := os.Getenv("HELM_WRAPPER_TMP_DIR"); d != "" {
+		if err := usable(d); err != nil {
+			return "", fmt.Errorf("invalid HELM_WRAPPER_TMP_DIR: %v", err)
+		}
+		return d, nil
+	}
+
+	var errs []string
+	for _, d := range []string{os.TempDir(), dir} {
+		err := usable(d)
+		if err == nil {
+			if d != os.TempDir() {
+				log.Printf("downloading to %s, %s", d, errs[0])
+			}
+			return d, nil
+		}
+		errs = append(errs, err.Error())
+	}
+
+	return "", fmt.Errorf("no usable directory to download to: %v", errs)
+}
+
+// usable checks that dir is writable and, where free space can be told, has at
+// least minScratchSpace available.
+func usable(dir string) error {
+	f, err := ioutil.TempFile(dir, ".helm-wrapper")
+	if err != nil {
+		return fmt.Errorf("%s isn't writable: %v", dir, err)
+	}
+	f.Close()
+	os.Remove(f.Name())
+
+	free, ok := freeSpace(dir)
+	if ok && free < minScratchSpace {
+		return fmt.Errorf("%s has only %d bytes free", dir, free)
+	}
+
+	return nil
+}
+
+// spaceError is a download that wouldn't fit on its filesystem. Retrying it
+// is pointless.
+type spaceError struct {
+	dir        string
+	need, free int64
+}
+
+func (e *spaceError) Error() string {
+	return fmt.Sprintf("insufficient disk space in %s: need %s, only %s free", e.dir, humanSize(e.need), humanSize(e.free))
+}
+
+// checkSpace fails with a spaceError if dir can't hold size more bytes, so
+// that a full disk fails before the download rather than halfway through.
+// Unknown sizes and free space pass.
+func checkSpace(dir string, size int64) error {
+	free, ok := freeSpace(dir)
+	if !ok || size < 0 || uint64(size) <= free {
+		return nil
+	}
+
+	return &spaceError{dir: dir, need: size, free: int64(free)}
+}
+
+// scratchPath returns the path of name in the scratch directory, or in the
+// system temp directory if useScratch hasn't set one.
+func scratchPath(name string) string {
+	if scratch == "" {
+		return filepath.Join(os.TempDir(), name)
+	}
+
+	return filepath.Join(scratch, name)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScratchDir(t *testing.T) {
+	cache := tempDir(t)
+	tmp := tempDir(t)
+	missing := filepath.Join(tmp, "missing")
+
+	tests := []struct {
+		name    string
+		tmpDir  string
+		env     string
+		want    string
+		wantErr bool
+	}{
+		{"system temp dir", tmp, "", tmp, false},
+		{"unwritable temp dir", missing, "", cache, false},
+		{"override", missing, cache, cache, false},
+		{"unusable override", tmp, missing, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setenv(t, "TMPDIR", tt.tmpDir)
+			setenv(t, "HELM_WRAPPER_TMP_DIR", tt.env)
+
+			got, err := scratchDir(cache)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("scratchDir() error = %v, wantErr %t", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("scratchDir() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUseScratchIsLazy(t *testing.T) {
+	old := scratch
+	t.Cleanup(func() { scratch = old })
+	scratch = ""
+
+	tmp := tempDir(t)
+	setenv(t, "HELM_WRAPPER_TMP_DIR", tmp)
+
+	if got, want := scratchPath("helm.tmp"), filepath.Join(os.TempDir(), "helm.tmp"); got != want {
+		t.Errorf("scratchPath() before useScratch = %q, want %q", got, want)
+	}
+
+	if err := useScratch(tempDir(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := scratchPath("helm.tmp"), filepath.Join(tmp, "helm.tmp"); got != want {
+		t.Errorf("scratchPath() = %q, want %q", got, want)
+	}
+
+	setenv(t, "HELM_WRAPPER_TMP_DIR", tempDir(t))
+	if err := useScratch(tempDir(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := scratchPath("helm.tmp"), filepath.Join(tmp, "helm.tmp"); got != want {
+		t.Errorf("scratchPath() after a second useScratch = %q, want %q", got, want)
+	}
+}
+
+func TestCheckSpace(t *testing.T) {
+	dir := tempDir(t)
+
+	if err := checkSpace(dir, -1); err != nil {
+		t.Errorf("checkSpace() of an unknown size = %v, want nil", err)
+	}
+
+	if err := checkSpace(dir, 1); err != nil {
+		t.Errorf("checkSpace() of a byte = %v, want nil", err)
+	}
+
+	if _, ok := freeSpace(dir); !ok {
+		t.Skip("free space can't be told here")
+	}
+
+	err := checkSpace(dir, 1<<62)
+	if _, ok := err.(*spaceError); !ok {
+		t.Errorf("checkSpace() of 4EiB = %v, want a spaceError", err)
+	}
+
+	if retryable(err) {
+		t.Error("a spaceError is retryable")
+	}
+}
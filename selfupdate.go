@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+const selfReleaseURL = "https://api.github.com/repos/keepclean/helm-wrapper/releases/latest"
+
+// wrapperVersion is the wrapper's own version, set at build time with
+// -ldflags "-X main.wrapperVersion=v1.2.3".
+var wrapperVersion = "dev"
+
+type asset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+// selfUpdate replaces the running wrapper with its latest release. Releases
+// are expected to carry a helm-wrapper-<os>-<arch> asset along with its
+// .sha256 checksum.
+func selfUpdate(dir string, args []string) error {
+	fs := flag.NewFlagSet("self-update", flag.ContinueOnError)
+	force := fs.Bool("force", false, "update even if the running version is unknown or newer")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	c := newClient(settings.timeout)
+	resp, err := c.Get(selfReleaseURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("couldn't fetch helm-wrapper releases: %q", resp.Status)
+	}
+
+	var r struct {
+		release
+		Assets []asset `json:"assets"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return err
+	}
+
+	newer, err := isNewer(r.TagName, wrapperVersion)
+	if err != nil && !*force {
+		return fmt.Errorf("%v, use --force to update anyway", err)
+	}
+	if err == nil && !newer && !*force {
+		fmt.Printf("helm-wrapper %s is up to date\n", wrapperVersion)
+		return nil
+	}
+
+	name := fmt.Sprintf("helm-wrapper-%s-%s", runtime.GOOS, runtime.GOARCH)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+
+	var binURL, sumURL string
+	for _, a := range r.Assets {
+		switch a.Name {
+		case name:
+			binURL = a.URL
+		case name + ".sha256":
+			sumURL = a.URL
+		}
+	}
+	if binURL == "" || sumURL == "" {
+		return fmt.Errorf("release %s has no %s asset with a checksum", r.TagName, name)
+	}
+
+	sum, err := fetchChecksum(c, sumURL)
+	if err != nil {
+		return err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	exe, err = filepath.EvalSymlinks(exe)
+	if err != nil {
+		return err
+	}
+
+	if err := replaceExecutable(c, binURL, sum, exe); err != nil {
+		return err
+	}
+
+	fmt.Printf("updated helm-wrapper from %s to %s\n", wrapperVersion, r.TagName)
+	return nil
+}
+
+// isNewer reports whether the release tag is a newer version than current.
+func isNewer(tag, current string) (bool, error) {
+	latest, err := version.ParseSemantic(tag)
+	if err != nil {
+		return false, fmt.Errorf("invalid release version %q", tag)
+	}
+
+	cur, err := version.ParseSemantic(current)
+	if err != nil {
+		return false, fmt.Errorf("can't compare against the running version %q", current)
+	}
+
+	return cur.LessThan(latest), nil
+}
+
+// replaceExecutable downloads url next to exe and, once its sha256 matches
+// sum, renames it over exe so the update is atomic. The new executable keeps
+// the mode of the old one.
+func replaceExecutable(c *http.Client, url, sum, exe string) error {
+	fi, err := os.Stat(exe)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("couldn't download %s: %q", url, resp.Status)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(exe), ".helm-wrapper")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha256.New()
+	if _, err := copyBuffered(io.MultiWriter(tmp, h), resp.Body); err != nil {
+		return err
+	}
+
+	if got := hex.EncodeToString(h.Sum(nil)); got != sum {
+		return fmt.Errorf("checksum mismatch for %s: got %s, want %s", url, got, sum)
+	}
+
+	if err := tmp.Chmod(fi.Mode().Perm()); err != nil {
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), exe)
+}
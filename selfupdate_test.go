@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestIsNewer(t *testing.T) {
+	tests := []struct {
+		tag     string
+		current string
+		want    bool
+		wantErr bool
+	}{
+		{"v1.3.0", "v1.2.0", true, false},
+		{"v1.2.0", "v1.2.0", false, false},
+		{"v1.1.0", "v1.2.0", false, false},
+		{"1.3.0", "v1.2.0", true, false},
+		{"v1.3.0", "1.2.0", true, false},
+		{"1.2.0", "v1.2.0", false, false},
+		{"v1.2.0", "v1.2.0-rc.1", true, false},
+		{"v1.2.0-rc.2", "v1.2.0-rc.1", true, false},
+		{"v1.2.0-rc.1", "v1.2.0", false, false},
+		{"v1.2.0-rc.1", "v1.1.0", true, false},
+		{"latest", "v1.2.0", false, true},
+		{"v1.3.0", "dev", false, true},
+	}
+
+	for _, tt := range tests {
+		got, err := isNewer(tt.tag, tt.current)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("isNewer(%q, %q) error = %v, wantErr %t", tt.tag, tt.current, err, tt.wantErr)
+			continue
+		}
+
+		if got != tt.want {
+			t.Errorf("isNewer(%q, %q) = %t, want %t", tt.tag, tt.current, got, tt.want)
+		}
+	}
+}
+
+func TestReplaceExecutable(t *testing.T) {
+	const bin = "#!/bin/sh\necho new\n"
+	sum := sha256.Sum256([]byte(bin))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(bin))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		sum     string
+		mode    os.FileMode
+		want    string
+		wantErr bool
+	}{
+		{"replaced", hex.EncodeToString(sum[:]), 0755, bin, false},
+		{"mode kept", hex.EncodeToString(sum[:]), 0750, bin, false},
+		{"checksum mismatch", hex.EncodeToString(make([]byte, sha256.Size)), 0755, "old", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := tempDir(t)
+			exe := filepath.Join(dir, "helm-wrapper")
+			if err := ioutil.WriteFile(exe, []byte("old"), tt.mode); err != nil {
+				t.Fatal(err)
+			}
+			// Not masked by the umask.
+			if err := os.Chmod(exe, tt.mode); err != nil {
+				t.Fatal(err)
+			}
+
+			err := replaceExecutable(newClient(0), srv.URL, tt.sum, exe)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("replaceExecutable() error = %v, wantErr %t", err, tt.wantErr)
+			}
+
+			if b, err := ioutil.ReadFile(exe); err != nil || string(b) != tt.want {
+				t.Errorf("executable holds %q, %v, want %q", b, err, tt.want)
+			}
+
+			fi, err := os.Stat(exe)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if runtime.GOOS != "windows" && fi.Mode().Perm() != tt.mode {
+				t.Errorf("executable has mode %v, want %v", fi.Mode().Perm(), tt.mode)
+			}
+
+			if got := names(t, dir); !reflect.DeepEqual(got, []string{"helm-wrapper"}) {
+				t.Errorf("update left %v behind", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+)
+
+// systemHelms caches the outcome of systemHelm by version, as looking costs
+// running helm.
+var systemHelms = struct {
+	sync.Mutex
+	byVersion map[string]string
+}{byVersion: map[string]string{}}
+
+// systemHelm returns the helm on PATH if HELM_WRAPPER_USE_SYSTEM_HELM is set
+// and it reports being helm v, so that it runs rather than a copy downloaded
+// into the cache. The wrapper itself, often installed as helm, never counts,
+// and neither does any helm when a platform is forced.
+func systemHelm(v string) (string, bool) {
+	if !envBool("HELM_WRAPPER_USE_SYSTEM_HELM") || host != native {
+		return "", false
+	}
+
+	systemHelms.Lock()
+	defer systemHelms.Unlock()
+
+	if path, ok := systemHelms.byVersion[v]; ok {
+		return path, path != ""
+	}
+
+	path := lookSystemHelm(v)
+	systemHelms.byVersion[v] = path
+	return path, path != ""
+}
+
+func lookSystemHelm(v string) string {
+	path, err := exec.LookPath("helm")
+	if err != nil {
+		return ""
+	}
+
+	if path, err = filepath.EvalSymlinks(path); err != nil {
+		return ""
+	}
+
+	if self, err := os.Executable(); err == nil {
+		if self, err = filepath.EvalSymlinks(self); err == nil && self == path {
+			return ""
+		}
+	}
+
+	if got := reportedVersion(path); got != v {
+		debugf("helm on PATH at %s is %q, not %s", path, got, v)
+		return ""
+	}
+
+	debugf("using helm %s on PATH at %s", v, path)
+	return path
+}
+
+// helmBin returns the helm v binary to run: the system helm if systemHelm
+// picks it, or else the one in the cache in dir.
+func helmBin(dir, v string) string {
+	if path, ok := systemHelm(v); ok {
+		return path
+	}
+
+	return newCache(dir).Get(v)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// stubSystemHelm puts a helm on PATH that reports version out, for the rest
+// of t, and returns its path.
+func stubSystemHelm(t *testing.T, out string) string {
+	t.Helper()
+
+	dir := tempDir(t)
+	path := filepath.Join(dir, "helm")
+	script := fmt.Sprintf("#!/bin/sh\necho '%s'\n", out)
+	if err := ioutil.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	setenv(t, "PATH", dir)
+
+	systemHelms.byVersion = map[string]string{}
+	t.Cleanup(func() { systemHelms.byVersion = map[string]string{} })
+
+	path, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestSystemHelm(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+		forced  bool
+		out     string
+		want    bool
+	}{
+		{"helm 3 match", true, false, "v3.4.0+g7090a89", true},
+		{"helm 2 match", true, false, "Client: v3.4.0+g47f0b88", true},
+		{"mismatch", true, false, "v3.3.4+gabc", false},
+		{"disabled", false, false, "v3.4.0+g7090a89", false},
+		{"forced platform", true, true, "v3.4.0+g7090a89", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := stubSystemHelm(t, tt.out)
+			setenv(t, "HELM_WRAPPER_USE_SYSTEM_HELM", fmt.Sprint(tt.enabled))
+			if tt.forced {
+				forceHost(t, foreign())
+			}
+
+			got, ok := systemHelm("v3.4.0")
+			if ok != tt.want {
+				t.Fatalf("systemHelm() = %q, %t, want %t", got, ok, tt.want)
+			}
+
+			dir := tempDir(t)
+			want := binPath(dir, "v3.4.0")
+			if ok {
+				want = path
+				if got != path {
+					t.Errorf("systemHelm() = %q, want %q", got, path)
+				}
+			}
+
+			if bin := helmBin(dir, "v3.4.0"); bin != want {
+				t.Errorf("helmBin() = %q, want %q", bin, want)
+			}
+		})
+	}
+}
+
+func TestSha256CmdSystemHelm(t *testing.T) {
+	stubSystemHelm(t, "v3.4.0+g7090a89")
+	dir := tempDir(t)
+
+	setenv(t, "HELM_WRAPPER_USE_SYSTEM_HELM", "false")
+	if err := sha256Cmd(dir, []string{"v3.4.0"}); err == nil {
+		t.Error("sha256Cmd() of an uncached version succeeded without the system helm")
+	}
+
+	setenv(t, "HELM_WRAPPER_USE_SYSTEM_HELM", "true")
+	if err := sha256Cmd(dir, []string{"v3.4.0"}); err != nil {
+		t.Errorf("sha256Cmd() of the system helm failed: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimit returns HELM_WRAPPER_DOWNLOAD_RATE_LIMIT, in bytes per second, or
+// 0 if downloads aren't throttled.
+func rateLimit() (int, error) {
+	s := os.Getenv("HELM_WRAPPER_DOWNLOAD_RATE_LIMIT")
+	if s == "" {
+		return 0, nil
+	}
+
+	limit, err := strconv.Atoi(s)
+	if err != nil || limit <= 0 {
+		return 0, fmt.Errorf("invalid HELM_WRAPPER_DOWNLOAD_RATE_LIMIT %q, must be a positive number of bytes per second", s)
+	}
+
+	return limit, nil
+}
+
+// downloadClient returns the client archives are downloaded with. Throttled
+// downloads can take far longer than the download timeout, so it doesn't
+// apply to them as a whole: fetch instead fails them once they've received
+// nothing for that long.
+func downloadClient() (*http.Client, error) {
+	limit, err := rateLimit()
+	if err != nil {
+		return nil, err
+	}
+
+	if limit != 0 {
+		return newClient(0), nil
+	}
+
+	return newClient(settings.timeout), nil
+}
+
+// throttle limits reads from r to HELM_WRAPPER_DOWNLOAD_RATE_LIMIT bytes per
+// second. Without a limit r is returned as is.
+func throttle(r io.Reader) (io.Reader, error) {
+	limit, err := rateLimit()
+	if err != nil || limit == 0 {
+		return r, err
+	}
+
+	return &throttledReader{r: r, limiter: rate.NewLimiter(rate.Limit(limit), limit)}, nil
+}
+
+type throttledReader struct {
+	r       io.Reader
+	limiter *rate.Limiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > t.limiter.Burst() {
+		p = p[:t.limiter.Burst()]
+	}
+
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if werr := t.limiter.WaitN(context.Background(), n); werr != nil {
+			return n, werr
+		}
+	}
+
+	return n, err
+}
+
+// idleReader pushes back the idle timer, if there's one, each time reading
+// from r makes progress.
+type idleReader struct {
+	r    io.Reader
+	idle *time.Timer
+}
+
+func (i idleReader) Read(p []byte) (int, error) {
+	n, err := i.r.Read(p)
+	if n > 0 && i.idle != nil {
+		i.idle.Reset(settings.timeout)
+	}
+
+	return n, err
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	tests := []struct {
+		env     string
+		want    int
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"1048576", 1 << 20, false},
+		{"0", 0, true},
+		{"1MB", 0, true},
+	}
+
+	for _, tt := range tests {
+		setenv(t, "HELM_WRAPPER_DOWNLOAD_RATE_LIMIT", tt.env)
+
+		got, err := rateLimit()
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("rateLimit() with %q error = %v, wantErr %t", tt.env, err, tt.wantErr)
+		}
+
+		if got != tt.want {
+			t.Errorf("rateLimit() with %q = %d, want %d", tt.env, got, tt.want)
+		}
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	setenv(t, "HELM_WRAPPER_DOWNLOAD_RATE_LIMIT", "4096")
+
+	r, err := throttle(bytes.NewReader(make([]byte, 6144)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(r); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first second's worth is the burst, the rest has to wait.
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("reading 6KiB at 4KiB/s took %s, want about 500ms", elapsed)
+	}
+}
+
+// withTimeout sets the download timeout for the rest of t.
+func withTimeout(t *testing.T, timeout time.Duration) {
+	old := settings
+	t.Cleanup(func() { settings = old })
+	settings.timeout = timeout
+}
+
+func TestThrottledDownloadOutlastsTimeout(t *testing.T) {
+	body := bytes.Repeat([]byte("helm"), 400<<10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	withTimeout(t, 300*time.Millisecond)
+	setenv(t, "HELM_WRAPPER_DOWNLOAD_RATE_LIMIT", "1048576")
+	setenv(t, "HELM_WRAPPER_COPY_BUFFER", "16384")
+
+	c, err := downloadClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	n, _, err := fetch(c, srv.URL, filepath.Join(tempDir(t), "helm.tmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != int64(len(body)) {
+		t.Errorf("fetch() wrote %d bytes, want %d", n, len(body))
+	}
+
+	if elapsed := time.Since(start); elapsed < settings.timeout {
+		t.Errorf("fetch() took %s, want longer than the %s timeout for the test to mean anything", elapsed, settings.timeout)
+	}
+}
+
+func TestStalledDownloadFails(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "8192")
+		w.Write(make([]byte, 1024))
+		w.(http.Flusher).Flush()
+		<-done
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	withTimeout(t, 200*time.Millisecond)
+	setenv(t, "HELM_WRAPPER_DOWNLOAD_RATE_LIMIT", "1048576")
+
+	c, err := downloadClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = fetch(c, srv.URL, filepath.Join(tempDir(t), "helm.tmp"))
+	if err == nil || !strings.Contains(err.Error(), "stalled") {
+		t.Errorf("fetch() error = %v, want a stall", err)
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/client-go/kubernetes"
+	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+const serverVersionAttempts = 3
+
+// serverVersionDelay is how long serverVersion waits between attempts.
+var serverVersionDelay = time.Second
+
+// serverVersion returns the version of Tiller running in the cluster of
+// kubeContext, asking it with a helm 2 client picked by tillerClient, and
+// whether there's one that answers. Failing that, the client default helm v is
+// what's run. An empty kubeContext means the current context.
+func serverVersion(ctx context.Context, v, dir, kubeContext string) (string, bool, error) {
+	clientset, err := newClientset(kubeContext)
+	if noContext(err) {
+		log.Printf("no current kube-context; skipping Tiller detection, using helm %s", v)
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	ok, err := checkTiller(ctx, clientset)
+	if err != nil || !ok {
+		return "", false, err
+	}
+
+	client, err := tillerClient(v, dir)
+	if err != nil {
+		log.Printf("couldn't get a helm 2 client to ask Tiller with (%v), using helm %s", err, v)
+		return "", false, nil
+	}
+
+	args := []string{"version", "--server", "--template", "{{.Server.SemVer}}"}
+	if kubeContext != "" {
+		args = append(args, "--kube-context", kubeContext)
+	}
+
+	// Connections to Tiller are flaky, so retry a few times before settling
+	// for the client default version.
+	var out []byte
+	for attempt := 1; ; attempt++ {
+		out, err = exec.CommandContext(ctx, helmBin(dir, client), args...).CombinedOutput()
+		if err == nil {
+			break
+		}
+
+		if attempt == serverVersionAttempts {
+			log.Printf("couldn't get Tiller's version (%v: %s), using helm %s", err, strings.TrimSpace(string(out)), v)
+			return "", false, nil
+		}
+
+		select {
+		case <-time.After(serverVersionDelay):
+		case <-ctx.Done():
+			return "", false, ctx.Err()
+		}
+	}
+
+	sv, ok := tillerVersion(string(out))
+	if !ok {
+		log.Printf("couldn't find Tiller's version in %q, using helm %s", strings.TrimSpace(string(out)), v)
+		return "", false, nil
+	}
+
+	return sv, true, nil
+}
+
+// tillerClient returns the helm version to ask Tiller with, made sure to be in
+// dir: v itself if it's helm 2, and otherwise the newest helm 2 release, as
+// helm 3 has no --server flag. Without the release index, it's knownVersion.
+func tillerClient(v, dir string) (string, error) {
+	if sv, err := version.ParseGeneric(v); err == nil && sv.Major() == 2 {
+		return v, nil
+	}
+
+	client, err := resolveLatest("latest-2", dir)
+	if err != nil {
+		debugf("couldn't resolve the newest helm 2 (%v), asking Tiller with %s", err, knownVersion)
+		client = knownVersion
+	}
+
+	return client, ensure(client, dir)
+}
+
+// tillerVersion picks Tiller's version out of the output of helm version
+// --server, which warnings may precede: the first semver on the last line
+// holding one.
+func tillerVersion(out string) (string, bool) {
+	lines := strings.Split(out, "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		for _, f := range strings.Fields(lines[i]) {
+			if _, err := version.ParseSemantic(f); err == nil {
+				return canonical(f), true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// impersonation is the identity helm is told to act as, set by
+// impersonateFrom, which detection then acts as too.
+var impersonation clientcmdapi.AuthInfo
+
+// impersonateFrom takes the user and groups to impersonate from helm args'
+// --kube-as-user and --kube-as-group, so that detection runs with the same
+// identity and RBAC as helm itself.
+func impersonateFrom(args []string) {
+	impersonation = clientcmdapi.AuthInfo{
+		Impersonate:       flagValue(args, "--kube-as-user"),
+		ImpersonateGroups: flagValues(args, "--kube-as-group"),
+	}
+}
+
+// kubeconfig returns the client configuration for kubeContext, or for the
+// current context if it's empty, loaded like kubectl does from KUBECONFIG or
+// ~/.kube/config, with any impersonation helm is told to use.
+func kubeconfig(kubeContext string) clientcmd.ClientConfig {
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(),
+		&clientcmd.ConfigOverrides{CurrentContext: kubeContext, AuthInfo: impersonation},
+	)
+}
+
+// noContext reports whether err is from a missing or empty kubeconfig, or one
+// without a current context, in which case there's no cluster to detect
+// anything in.
+func noContext(err error) bool {
+	return err != nil && clientcmd.IsEmptyConfig(err)
+}
+
+// newClientset returns a client for the cluster of kubeContext, or of the
+// current context if it's empty. It's a variable so that tests can hand out
+// fake clientsets.
+var newClientset = func(kubeContext string) (kubernetes.Interface, error) {
+	config, err := kubeconfig(kubeContext).ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tuneConfig(config); err != nil {
+		return nil, err
+	}
+
+	return kubernetes.NewForConfig(config)
+}
+
+// tuneConfig applies HELM_WRAPPER_KUBE_QPS, HELM_WRAPPER_KUBE_BURST and
+// HELM_WRAPPER_KUBE_TIMEOUT to config. Requests time out after 30s unless the
+// kubeconfig or HELM_WRAPPER_KUBE_TIMEOUT says otherwise, so detection can't
+// hang on an unreachable cluster. Proxies are taken from HTTPS_PROXY and
+// NO_PROXY, like kubectl does.
+func tuneConfig(config *rest.Config) error {
+	if s := os.Getenv("HELM_WRAPPER_KUBE_QPS"); s != "" {
+		qps, err := strconv.ParseFloat(s, 32)
+		if err != nil || qps <= 0 {
+			return fmt.Errorf("invalid HELM_WRAPPER_KUBE_QPS %q", s)
+		}
+		config.QPS = float32(qps)
+	}
+
+	if s := os.Getenv("HELM_WRAPPER_KUBE_BURST"); s != "" {
+		burst, err := strconv.Atoi(s)
+		if err != nil || burst <= 0 {
+			return fmt.Errorf("invalid HELM_WRAPPER_KUBE_BURST %q", s)
+		}
+		config.Burst = burst
+	}
+
+	if s := os.Getenv("HELM_WRAPPER_KUBE_TIMEOUT"); s != "" {
+		timeout, err := time.ParseDuration(s)
+		if err != nil || timeout < 0 {
+			return fmt.Errorf("invalid HELM_WRAPPER_KUBE_TIMEOUT %q", s)
+		}
+		config.Timeout = timeout
+	} else if config.Timeout == 0 {
+		config.Timeout = 30 * time.Second
+	}
+
+	return nil
+}
+
+// checkTiller reports whether a Tiller pod can be found through clientset.
+func checkTiller(ctx context.Context, clientset kubernetes.Interface) (bool, error) {
+	listOptions := metav1.ListOptions{
+		LabelSelector: settings.Selector,
+	}
+
+	pods, err := clientset.CoreV1().Pods(settings.Namespace).List(ctx, listOptions)
+	if err != nil {
+		return false, err
+	}
+
+	if len(pods.Items) == 0 {
+		return false, nil
+	}
+
+	return true, nil
+}
+
+type tiller struct {
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	Ready     bool   `json:"ready"`
+	Version   string `json:"version"`
+}
+
+// findTillers returns the pods matching the Tiller selector in all namespaces.
+// Their version is taken from the tag of the tiller container's image.
+func findTillers(clientset kubernetes.Interface) ([]tiller, error) {
+	listOptions := metav1.ListOptions{
+		LabelSelector: settings.Selector,
+	}
+
+	pods, err := clientset.CoreV1().Pods(metav1.NamespaceAll).List(context.TODO(), listOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	var ts []tiller
+	for _, p := range pods.Items {
+		t := tiller{Namespace: p.Namespace, Pod: p.Name}
+		for _, c := range p.Status.Conditions {
+			if c.Type == corev1.PodReady {
+				t.Ready = c.Status == corev1.ConditionTrue
+			}
+		}
+		for _, c := range p.Spec.Containers {
+			if i := strings.LastIndex(c.Image, ":"); i != -1 && c.Name == "tiller" {
+				t.Version = c.Image[i+1:]
+			}
+		}
+		ts = append(ts, t)
+	}
+
+	return ts, nil
+}
+
+func tillers(dir string, args []string) error {
+	output, _, err := outputFlags("tillers", args)
+	if err != nil {
+		return err
+	}
+
+	clientset, err := newClientset("")
+	if err != nil {
+		return err
+	}
+
+	ts, err := findTillers(clientset)
+	if err != nil {
+		return err
+	}
+
+	if output == "json" {
+		if ts == nil {
+			ts = []tiller{}
+		}
+		return printJSON(ts)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tPOD\tREADY\tVERSION")
+	for _, t := range ts {
+		fmt.Fprintf(w, "%s\t%s\t%t\t%s\n", t.Namespace, t.Pod, t.Ready, t.Version)
+	}
+
+	return w.Flush()
+}
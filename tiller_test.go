@@ -0,0 +1,481 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+)
+
+// tillerPod returns a Tiller pod of version v in namespace.
+func tillerPod(namespace, name, v string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+			Labels:    map[string]string{"app": "helm", "name": "tiller"},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "tiller", Image: "gcr.io/kubernetes-helm/tiller:" + v}},
+		},
+		Status: corev1.PodStatus{
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		},
+	}
+}
+
+// fakeCluster makes newClientset return a fake clientset holding objects for
+// the rest of t.
+func fakeCluster(t *testing.T, objects ...runtime.Object) {
+	t.Helper()
+
+	clientset := fake.NewSimpleClientset(objects...)
+	old := newClientset
+	t.Cleanup(func() { newClientset = old })
+	newClientset = func(string) (kubernetes.Interface, error) {
+		return clientset, nil
+	}
+}
+
+func TestCheckTiller(t *testing.T) {
+	tests := []struct {
+		name string
+		pods []runtime.Object
+		want bool
+	}{
+		{"no pods", nil, false},
+		{"tiller", []runtime.Object{tillerPod("kube-system", "tiller-deploy-1", "v2.16.12")}, true},
+		{"tiller in another namespace", []runtime.Object{tillerPod("tiller", "tiller-deploy-1", "v2.16.12")}, false},
+		{"unlabelled pod", []runtime.Object{&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "coredns"}}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := checkTiller(context.Background(), fake.NewSimpleClientset(tt.pods...))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tt.want {
+				t.Errorf("checkTiller() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeHelm installs a helm v in dir that prints out when asked for the
+// server version and fails when fail is set.
+func fakeHelm(t *testing.T, dir, v, out string, fail bool) {
+	t.Helper()
+
+	code := 0
+	if fail {
+		code = 1
+	}
+
+	script := fmt.Sprintf("#!/bin/sh\nprintf '%%s' '%s'\nexit %d\n", out, code)
+	if err := ioutil.WriteFile(binPath(dir, v), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerVersion(t *testing.T) {
+	tests := []struct {
+		name   string
+		pods   []runtime.Object
+		out    string
+		fail   bool
+		want   string
+		wantOK bool
+	}{
+		{"no tiller", nil, "v2.14.3", false, "", false},
+		{"tiller", []runtime.Object{tillerPod("kube-system", "tiller-deploy-1", "v2.14.3")}, "v2.14.3", false, "v2.14.3", true},
+		{"tiller without the v", []runtime.Object{tillerPod("kube-system", "tiller-deploy-1", "v2.14.3")}, "2.14.3", false, "v2.14.3", true},
+		{"tiller not answering", []runtime.Object{tillerPod("kube-system", "tiller-deploy-1", "v2.14.3")}, "Error: could not find a ready tiller pod", true, "", false},
+		{"garbage", []runtime.Object{tillerPod("kube-system", "tiller-deploy-1", "v2.14.3")}, "Server: &version.Version{}", false, "", false},
+		{"warnings first", []runtime.Object{tillerPod("kube-system", "tiller-deploy-1", "v2.14.3")}, "WARNING: Kubernetes configuration file is group-readable.\nv2.14.3\n", false, "v2.14.3", true},
+	}
+
+	oldDelay := serverVersionDelay
+	t.Cleanup(func() { serverVersionDelay = oldDelay })
+	serverVersionDelay = 0
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := tempDir(t)
+			fakeHelm(t, dir, "v2.16.12", tt.out, tt.fail)
+			fakeCluster(t, tt.pods...)
+
+			got, ok, err := serverVersion(context.Background(), "v2.16.12", dir, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("serverVersion() = %q, %t, want %q, %t", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestServerVersionHelm3Default(t *testing.T) {
+	oldDelay := serverVersionDelay
+	t.Cleanup(func() { serverVersionDelay = oldDelay })
+	serverVersionDelay = 0
+
+	tests := []struct {
+		name   string
+		index  bool
+		client string
+		want   string
+		wantOK bool
+	}{
+		{"newest helm 2", true, "v2.17.0", "v2.14.3", true},
+		{"no release index", false, knownVersion, "v2.14.3", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetIndex(t)
+			srv := releaseServer(t, []release{{TagName: "v3.4.0"}, {TagName: "v2.17.0"}, {TagName: "v2.16.12"}}, "v3.4.0", "v2.17.0", "v2.16.12")
+			if !tt.index {
+				releasesURL = srv.URL + "/gone"
+			}
+			fakeCluster(t, tillerPod("kube-system", "tiller-deploy-1", "v2.14.3"))
+
+			dir := tempDir(t)
+			fakeHelm(t, dir, "v3.4.0", "Error: unknown flag: --server", true)
+			fakeHelm(t, dir, tt.client, "v2.14.3", false)
+
+			got, ok, err := serverVersion(context.Background(), "v3.4.0", dir, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("serverVersion() = %q, %t, want %q, %t", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestTillerVersion(t *testing.T) {
+	tests := []struct {
+		name   string
+		out    string
+		want   string
+		wantOK bool
+	}{
+		{"bare", "v2.14.3", "v2.14.3", true},
+		{"trailing newline", "v2.14.3\n", "v2.14.3", true},
+		{"custom build", "v2.14.3+abc123", "v2.14.3+abc123", true},
+		{"warning first", "WARNING: Kubernetes configuration file is world-readable.\nv2.14.3", "v2.14.3", true},
+		{"warning naming a version", "W1015 kubectl v1.18.3 is deprecated\nv2.14.3", "v2.14.3", true},
+		{"prefixed", "Server: v2.14.3", "v2.14.3", true},
+		{"empty", "", "", false},
+		{"no version", "Error: could not find tiller", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := tillerVersion(tt.out)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("tillerVersion() of %s = %q, %t, want %q, %t", tt.name, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestFindTillers(t *testing.T) {
+	old := settings
+	t.Cleanup(func() { settings = old })
+	settings.Selector = "app=helm,name=tiller"
+
+	unready := tillerPod("team-b", "tiller-deploy-2", "v2.14.3")
+	unready.Status.Conditions[0].Status = corev1.ConditionFalse
+
+	clientset := fake.NewSimpleClientset(
+		tillerPod("kube-system", "tiller-deploy-1", "v2.16.12"),
+		unready,
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "web", Labels: map[string]string{"app": "web"}}},
+	)
+
+	got, err := findTillers(clientset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(got, func(i, j int) bool { return got[i].Namespace < got[j].Namespace })
+
+	want := []tiller{
+		{Namespace: "kube-system", Pod: "tiller-deploy-1", Ready: true, Version: "v2.16.12"},
+		{Namespace: "team-b", Pod: "tiller-deploy-2", Ready: false, Version: "v2.14.3"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("findTillers() = %+v, want %+v", got, want)
+	}
+}
+
+// useKubeconfig points KUBECONFIG, for the rest of t, at a kubeconfig whose
+// current context is the cluster served by the TLS server srv under path.
+func useKubeconfig(t *testing.T, srv *httptest.Server, path string) {
+	t.Helper()
+
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	config := fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: %s
+    certificate-authority-data: %s
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+users:
+- name: test
+  user:
+    token: t0ken
+current-context: test
+`, srv.URL+path, base64.StdEncoding.EncodeToString(ca))
+
+	file := filepath.Join(tempDir(t), "config")
+	if err := ioutil.WriteFile(file, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	setenv(t, "KUBECONFIG", file)
+}
+
+func TestTuneConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		qps         string
+		burst       string
+		timeout     string
+		kubeTimeout time.Duration
+		want        rest.Config
+		wantErr     bool
+	}{
+		{"defaults", "", "", "", 0, rest.Config{Timeout: 30 * time.Second}, false},
+		{"kubeconfig timeout", "", "", "", time.Minute, rest.Config{Timeout: time.Minute}, false},
+		{"tuned", "50", "100", "5s", time.Minute, rest.Config{QPS: 50, Burst: 100, Timeout: 5 * time.Second}, false},
+		{"no timeout", "", "", "0s", 0, rest.Config{}, false},
+		{"invalid qps", "fast", "", "", 0, rest.Config{}, true},
+		{"zero burst", "", "0", "", 0, rest.Config{}, true},
+		{"negative timeout", "", "", "-1s", 0, rest.Config{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setenv(t, "HELM_WRAPPER_KUBE_QPS", tt.qps)
+			setenv(t, "HELM_WRAPPER_KUBE_BURST", tt.burst)
+			setenv(t, "HELM_WRAPPER_KUBE_TIMEOUT", tt.timeout)
+
+			config := &rest.Config{Timeout: tt.kubeTimeout}
+			err := tuneConfig(config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("tuneConfig() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if config.QPS != tt.want.QPS || config.Burst != tt.want.Burst || config.Timeout != tt.want.Timeout {
+				t.Errorf("tuneConfig() = qps %v, burst %d, timeout %s, want %v, %d, %s",
+					config.QPS, config.Burst, config.Timeout, tt.want.QPS, tt.want.Burst, tt.want.Timeout)
+			}
+		})
+	}
+}
+
+func TestCheckTillerThroughProxy(t *testing.T) {
+	pods := `{"kind":"PodList","apiVersion":"v1","items":[{"metadata":{"name":"tiller-deploy-1","namespace":"kube-system"}}]}`
+
+	tests := []struct {
+		name    string
+		delay   time.Duration
+		want    bool
+		wantErr bool
+	}{
+		{"answering", 0, true, false},
+		{"hanging", 5 * time.Second, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := settings
+			t.Cleanup(func() { settings = old })
+			settings.Namespace = "kube-system"
+			settings.Selector = "app=helm,name=tiller"
+			setenv(t, "HELM_WRAPPER_KUBE_TIMEOUT", "200ms")
+
+			// Clusters behind e.g. Rancher's proxy are served under a path
+			// on a port of its own.
+			auth := make(chan string, 1)
+			srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/k8s/clusters/c-abc/api/v1/namespaces/kube-system/pods" {
+					http.NotFound(w, r)
+					return
+				}
+				select {
+				case auth <- r.Header.Get("Authorization"):
+				default:
+				}
+
+				select {
+				case <-time.After(tt.delay):
+				case <-r.Context().Done():
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(pods))
+			}))
+			t.Cleanup(srv.Close)
+			useKubeconfig(t, srv, "/k8s/clusters/c-abc")
+
+			clientset, err := newClientset("")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			start := time.Now()
+			got, err := checkTiller(context.Background(), clientset)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkTiller() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("checkTiller() = %t, want %t", got, tt.want)
+			}
+
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("checkTiller() took %s, HELM_WRAPPER_KUBE_TIMEOUT wasn't applied", elapsed)
+			}
+			if got := <-auth; got != "Bearer t0ken" {
+				t.Errorf("request had Authorization %q, want the kubeconfig's token", got)
+			}
+		})
+	}
+}
+
+func TestServerVersionWithoutContext(t *testing.T) {
+	contextless := `apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: https://127.0.0.1:6443
+users:
+- name: test
+  user:
+    token: t0ken
+`
+
+	tests := []struct {
+		name        string
+		config      *string
+		kubeContext string
+		wantWarning bool
+	}{
+		{"no kubeconfig", nil, "", true},
+		{"empty kubeconfig", new(string), "", true},
+		{"no current context", &contextless, "", true},
+		{"unknown context", &contextless, "staging", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(tempDir(t), "config")
+			if tt.config != nil {
+				if err := ioutil.WriteFile(path, []byte(*tt.config), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			setenv(t, "KUBECONFIG", path)
+
+			var v string
+			var ok bool
+			var err error
+			stderr := captureStderr(t, func() {
+				v, ok, err = serverVersion(context.Background(), "v2.16.12", tempDir(t), tt.kubeContext)
+			})
+
+			if got := strings.Contains(stderr, "no current kube-context; skipping Tiller detection"); got != tt.wantWarning {
+				t.Errorf("serverVersion() warned %q, want the no context warning: %t", stderr, tt.wantWarning)
+			}
+			if tt.wantWarning && (err != nil || ok || v != "") {
+				t.Errorf("serverVersion() = %q, %t, %v, want to fall back to the default", v, ok, err)
+			}
+			if !tt.wantWarning && err == nil {
+				t.Error("serverVersion() of an unknown context succeeded")
+			}
+		})
+	}
+}
+
+func TestImpersonateFrom(t *testing.T) {
+	pods := `{"kind":"PodList","apiVersion":"v1","items":[]}`
+
+	tests := []struct {
+		name       string
+		args       []string
+		wantUser   string
+		wantGroups []string
+	}{
+		{"none", []string{"upgrade", "web", "./chart"}, "", nil},
+		{"user", []string{"upgrade", "--kube-as-user=alice", "web", "./chart"}, "alice", nil},
+		{"user and groups", []string{"list", "--kube-as-user", "alice", "--kube-as-group", "dev", "--kube-as-group=ops"}, "alice", []string{"dev", "ops"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := impersonation
+			t.Cleanup(func() { impersonation = old })
+
+			var mu sync.Mutex
+			var user string
+			var groups []string
+			srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				user = r.Header.Get("Impersonate-User")
+				groups = r.Header["Impersonate-Group"]
+				mu.Unlock()
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(pods))
+			}))
+			t.Cleanup(srv.Close)
+			useKubeconfig(t, srv, "")
+
+			impersonateFrom(tt.args)
+			clientset, err := newClientset("")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := checkTiller(context.Background(), clientset); err != nil {
+				t.Fatal(err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if user != tt.wantUser || !reflect.DeepEqual(groups, tt.wantGroups) {
+				t.Errorf("detection impersonated %q in %q, want %q in %q", user, groups, tt.wantUser, tt.wantGroups)
+			}
+		})
+	}
+}
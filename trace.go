@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// span is a timed operation of the wrapper, exported as an OpenTelemetry
+// span. A nil span, which startSpan returns when tracing is off, ignores
+// everything done with it.
+type span struct {
+	name       string
+	id, parent string
+	start, end time.Time
+	attrs      map[string]interface{}
+	err        error
+}
+
+// spanExporter sends finished spans of a trace somewhere.
+type spanExporter interface {
+	export(traceID string, spans []*span) error
+}
+
+// tracer holds the spans of this run, all in one trace.
+var tracer struct {
+	sync.Mutex
+	once     sync.Once
+	exporter spanExporter
+	traceID  string
+	open     []*span
+	done     []*span
+}
+
+// tracing reports whether spans are exported, which they are when
+// OTEL_EXPORTER_OTLP_ENDPOINT or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set,
+// unless OTEL_TRACES_EXPORTER is none. Spans are sent with OTLP over HTTP as
+// JSON, the only protocol supported, with OTEL_EXPORTER_OTLP_HEADERS.
+func tracing() bool {
+	tracer.once.Do(func() {
+		if tracer.exporter != nil {
+			return
+		}
+
+		exporter, err := otlpFromEnv()
+		if err != nil {
+			log.Printf("not tracing: %v", err)
+			return
+		}
+		tracer.exporter = exporter
+	})
+
+	return tracer.exporter != nil
+}
+
+// startSpan starts the span name, a child of the innermost open span, or nil
+// when tracing is off.
+func startSpan(name string) *span {
+	if !tracing() {
+		return nil
+	}
+
+	tracer.Lock()
+	defer tracer.Unlock()
+
+	if tracer.traceID == "" {
+		tracer.traceID = randomID(16)
+	}
+
+	s := &span{name: name, id: randomID(8), start: time.Now(), attrs: map[string]interface{}{}}
+	if n := len(tracer.open); n != 0 {
+		s.parent = tracer.open[n-1].id
+	}
+	tracer.open = append(tracer.open, s)
+
+	return s
+}
+
+// set sets the attribute key of s to v, a string, bool or integer.
+func (s *span) set(key string, v interface{}) {
+	if s == nil {
+		return
+	}
+
+	tracer.Lock()
+	defer tracer.Unlock()
+	s.attrs[key] = v
+}
+
+// finish ends s, marking it failed if err isn't nil.
+func (s *span) finish(err error) {
+	if s == nil {
+		return
+	}
+
+	tracer.Lock()
+	defer tracer.Unlock()
+
+	s.end, s.err = time.Now(), err
+	for i, o := range tracer.open {
+		if o == s {
+			tracer.open = append(tracer.open[:i], tracer.open[i+1:]...)
+			break
+		}
+	}
+	tracer.done = append(tracer.done, s)
+}
+
+// flushTraces exports the finished spans, ending any still open first. It's
+// called before the wrapper exits or replaces itself with helm. Failures are
+// only logged.
+func flushTraces() {
+	if !tracing() {
+		return
+	}
+
+	tracer.Lock()
+	open := append([]*span(nil), tracer.open...)
+	tracer.Unlock()
+	for i := len(open) - 1; i >= 0; i-- {
+		open[i].finish(nil)
+	}
+
+	tracer.Lock()
+	spans := tracer.done
+	tracer.done = nil
+	tracer.Unlock()
+
+	if len(spans) == 0 {
+		return
+	}
+
+	if err := tracer.exporter.export(tracer.traceID, spans); err != nil {
+		log.Printf("couldn't export traces: %v", err)
+	}
+}
+
+// randomID returns n random bytes in hex.
+func randomID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// otlpExporter sends spans to an OpenTelemetry collector with OTLP over HTTP
+// as JSON.
+type otlpExporter struct {
+	url     string
+	headers http.Header
+	service string
+}
+
+// otlpFromEnv returns the exporter configured by the standard OTEL_*
+// environment variables, or nil if there's none.
+func otlpFromEnv() (spanExporter, error) {
+	if os.Getenv("OTEL_TRACES_EXPORTER") == "none" {
+		return nil, nil
+	}
+
+	url := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if url == "" {
+		base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		if base == "" {
+			return nil, nil
+		}
+		url = strings.TrimSuffix(base, "/") + "/v1/traces"
+	}
+
+	protocol := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL")
+	if protocol == "" {
+		protocol = os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL")
+	}
+	if protocol != "" && protocol != "http/json" {
+		return nil, fmt.Errorf("OTLP protocol %q isn't supported, only http/json", protocol)
+	}
+
+	headers := http.Header{}
+	for _, h := range strings.Split(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), ",") {
+		if strings.TrimSpace(h) == "" {
+			continue
+		}
+
+		kv := strings.SplitN(h, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid OTEL_EXPORTER_OTLP_HEADERS entry %q, must be key=value", h)
+		}
+		headers.Set(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+	}
+
+	service := os.Getenv("OTEL_SERVICE_NAME")
+	if service == "" {
+		service = "helm-wrapper"
+	}
+
+	return otlpExporter{url: url, headers: headers, service: service}, nil
+}
+
+// otlpValue is an OTLP attribute value.
+type otlpValue map[string]interface{}
+
+type otlpAttr struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+func otlpAttrs(attrs map[string]interface{}) []otlpAttr {
+	out := []otlpAttr{}
+	for k, v := range attrs {
+		var value otlpValue
+		switch v := v.(type) {
+		case bool:
+			value = otlpValue{"boolValue": v}
+		case int:
+			value = otlpValue{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = otlpValue{"intValue": strconv.FormatInt(v, 10)}
+		default:
+			value = otlpValue{"stringValue": fmt.Sprint(v)}
+		}
+		out = append(out, otlpAttr{Key: k, Value: value})
+	}
+
+	return out
+}
+
+func (e otlpExporter) export(traceID string, spans []*span) error {
+	type otlpSpan struct {
+		TraceID      string      `json:"traceId"`
+		SpanID       string      `json:"spanId"`
+		ParentSpanID string      `json:"parentSpanId,omitempty"`
+		Name         string      `json:"name"`
+		Kind         int         `json:"kind"`
+		Start        string      `json:"startTimeUnixNano"`
+		End          string      `json:"endTimeUnixNano"`
+		Attributes   []otlpAttr  `json:"attributes"`
+		Status       interface{} `json:"status,omitempty"`
+	}
+
+	var out []otlpSpan
+	for _, s := range spans {
+		o := otlpSpan{
+			TraceID:      traceID,
+			SpanID:       s.id,
+			ParentSpanID: s.parent,
+			Name:         s.name,
+			Kind:         1, // internal
+			Start:        strconv.FormatInt(s.start.UnixNano(), 10),
+			End:          strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:   otlpAttrs(s.attrs),
+		}
+		if s.err != nil {
+			o.Status = map[string]interface{}{"code": 2, "message": s.err.Error()}
+		}
+		out = append(out, o)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttrs(map[string]interface{}{"service.name": e.service}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "helm-wrapper"},
+				"spans": out,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range e.headers {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := (&http.Client{Timeout: 5 * time.Second}).Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s returned %q", e.url, resp.Status)
+	}
+
+	return nil
+}
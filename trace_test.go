@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// memoryExporter keeps exported spans in memory.
+type memoryExporter struct {
+	traceID string
+	spans   []*span
+}
+
+func (e *memoryExporter) export(traceID string, spans []*span) error {
+	e.traceID = traceID
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+// withExporter makes spans go to e, or turns tracing off if it's nil, for the
+// rest of the test.
+func withExporter(t *testing.T, e spanExporter) {
+	reset := func(e spanExporter) {
+		tracer.once = sync.Once{}
+		tracer.exporter = e
+		tracer.traceID = ""
+		tracer.open = nil
+		tracer.done = nil
+	}
+
+	reset(e)
+	t.Cleanup(func() { reset(nil) })
+}
+
+func TestSpans(t *testing.T) {
+	e := &memoryExporter{}
+	withExporter(t, e)
+
+	root := startSpan("helm-wrapper")
+	fetch := startSpan("fetch")
+	fetch.set("helm.version", "v2.16.12")
+	fetch.set("cache.hit", false)
+	download := startSpan("download")
+	download.finish(errors.New("not found"))
+	fetch.finish(nil)
+	exec := startSpan("exec")
+	exec.set("helm.exit_code", 1)
+	flushTraces()
+
+	if len(e.spans) != 4 || e.traceID == "" {
+		t.Fatalf("exported %d spans of trace %q, want 4", len(e.spans), e.traceID)
+	}
+
+	byName := map[string]*span{}
+	for _, s := range e.spans {
+		if s.end.IsZero() {
+			t.Errorf("span %s wasn't finished", s.name)
+		}
+		byName[s.name] = s
+	}
+
+	tests := []struct {
+		name    string
+		parent  *span
+		attrs   map[string]interface{}
+		wantErr bool
+	}{
+		{"helm-wrapper", nil, nil, false},
+		{"fetch", root, map[string]interface{}{"helm.version": "v2.16.12", "cache.hit": false}, false},
+		{"download", fetch, nil, true},
+		{"exec", root, map[string]interface{}{"helm.exit_code": 1}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := byName[tt.name]
+			if s == nil {
+				t.Fatal("not exported")
+			}
+
+			var parent string
+			if tt.parent != nil {
+				parent = tt.parent.id
+			}
+			if s.parent != parent {
+				t.Errorf("parent = %q, want %q", s.parent, parent)
+			}
+
+			for k, v := range tt.attrs {
+				if s.attrs[k] != v {
+					t.Errorf("attribute %s = %v, want %v", k, s.attrs[k], v)
+				}
+			}
+
+			if (s.err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %t", s.err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTracingOff(t *testing.T) {
+	withExporter(t, nil)
+	unsetenv(t, "OTEL_EXPORTER_OTLP_ENDPOINT")
+	unsetenv(t, "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+
+	if tracing() {
+		t.Fatal("tracing() = true without an endpoint")
+	}
+
+	s := startSpan("fetch")
+	if s != nil {
+		t.Fatalf("startSpan() = %v, want nil", s)
+	}
+	s.set("helm.version", "v2.16.12")
+	s.finish(nil)
+	flushTraces()
+}
+
+func TestOTLPFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantURL string
+		wantErr bool
+	}{
+		{"unset", nil, "", false},
+		{"base", map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4318/"}, "http://collector:4318/v1/traces", false},
+		{"traces", map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4318", "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT": "http://traces/v1"}, "http://traces/v1", false},
+		{"none", map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4318", "OTEL_TRACES_EXPORTER": "none"}, "", false},
+		{"grpc", map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4317", "OTEL_EXPORTER_OTLP_PROTOCOL": "grpc"}, "", true},
+		{"bad headers", map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4318", "OTEL_EXPORTER_OTLP_HEADERS": "token"}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, k := range []string{"OTEL_EXPORTER_OTLP_ENDPOINT", "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "OTEL_TRACES_EXPORTER", "OTEL_EXPORTER_OTLP_PROTOCOL", "OTEL_EXPORTER_OTLP_TRACES_PROTOCOL", "OTEL_EXPORTER_OTLP_HEADERS"} {
+				unsetenv(t, k)
+			}
+			for k, v := range tt.env {
+				setenv(t, k, v)
+			}
+
+			e, err := otlpFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("otlpFromEnv() error = %v, wantErr %t", err, tt.wantErr)
+			}
+
+			var url string
+			if e != nil {
+				url = e.(otlpExporter).url
+			}
+			if url != tt.wantURL {
+				t.Errorf("otlpFromEnv() url = %q, want %q", url, tt.wantURL)
+			}
+		})
+	}
+}
+
+func TestOTLPExport(t *testing.T) {
+	var got struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID      string `json:"traceId"`
+					SpanID       string `json:"spanId"`
+					ParentSpanID string `json:"parentSpanId"`
+					Name         string `json:"name"`
+					Status       struct {
+						Code int `json:"code"`
+					} `json:"status"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	setenv(t, "OTEL_EXPORTER_OTLP_ENDPOINT", srv.URL)
+	setenv(t, "OTEL_EXPORTER_OTLP_HEADERS", "Authorization=Bearer token")
+	unsetenv(t, "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	unsetenv(t, "OTEL_TRACES_EXPORTER")
+	withExporter(t, nil)
+
+	root := startSpan("helm-wrapper")
+	startSpan("download").finish(errors.New("not found"))
+	root.finish(nil)
+	flushTraces()
+
+	if auth != "Bearer token" {
+		t.Errorf("Authorization = %q, want the configured header", auth)
+	}
+
+	if len(got.ResourceSpans) != 1 || len(got.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("unexpected payload %+v", got)
+	}
+	spans := got.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("exported %d spans, want 2", len(spans))
+	}
+
+	download, helmWrapper := spans[0], spans[1]
+	if download.Name != "download" || helmWrapper.Name != "helm-wrapper" {
+		t.Fatalf("exported %q and %q, want download and helm-wrapper", download.Name, helmWrapper.Name)
+	}
+	if download.TraceID != helmWrapper.TraceID || len(download.TraceID) != 32 {
+		t.Errorf("trace IDs %q and %q, want the same 16 bytes", download.TraceID, helmWrapper.TraceID)
+	}
+	if download.ParentSpanID != helmWrapper.SpanID {
+		t.Errorf("download parent = %q, want %q", download.ParentSpanID, helmWrapper.SpanID)
+	}
+	if download.Status.Code != 2 || helmWrapper.Status.Code != 0 {
+		t.Errorf("status codes %d and %d, want 2 and 0", download.Status.Code, helmWrapper.Status.Code)
+	}
+}
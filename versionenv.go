@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+// envRule sets environment variables for the helm versions in a range.
+type envRule struct {
+	// Versions is a space separated list of constraints all matching
+	// versions meet, each a version prefixed with >=, >, <=, < or =, e.g.
+	// ">=3.7 <3.8".
+	Versions string            `json:"versions"`
+	Vars     map[string]string `json:"vars"`
+}
+
+// versionEnv returns the environment the env rules of the config file set for
+// helm v. Variables the user already set are left alone, and where rules
+// overlap, the later one wins.
+func versionEnv(v string) ([]string, error) {
+	vars := map[string]string{}
+	for _, r := range settings.Env {
+		ok, err := inRange(v, r.Versions)
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			continue
+		}
+
+		for name, value := range r.Vars {
+			if _, set := os.LookupEnv(name); !set {
+				vars[name] = value
+			}
+		}
+	}
+
+	var env []string
+	for name, value := range vars {
+		env = append(env, name+"="+value)
+	}
+	sort.Strings(env)
+
+	return env, nil
+}
+
+// constraintOps are the comparisons allowed in a version range, longest first
+// so that >= isn't taken for >.
+var constraintOps = []string{">=", "<=", ">", "<", "="}
+
+// inRange reports whether helm v meets every constraint in the version range
+// r.
+func inRange(v, r string) (bool, error) {
+	sv, err := version.ParseGeneric(v)
+	if err != nil {
+		return false, err
+	}
+
+	constraints := strings.Fields(r)
+	if len(constraints) == 0 {
+		return false, fmt.Errorf("empty version range in the env rules of the config file")
+	}
+
+	for _, c := range constraints {
+		var op string
+		for _, o := range constraintOps {
+			if strings.HasPrefix(c, o) {
+				op = o
+				break
+			}
+		}
+
+		b := strings.TrimPrefix(c, op)
+		if !strings.Contains(b, ".") {
+			// ParseGeneric wants at least a minor version.
+			b += ".0"
+		}
+
+		bound, err := version.ParseGeneric(b)
+		if op == "" || err != nil {
+			return false, fmt.Errorf("invalid version constraint %q in the env rules of the config file, expected e.g. >=3.7", c)
+		}
+
+		var ok bool
+		switch op {
+		case ">=":
+			ok = sv.AtLeast(bound)
+		case ">":
+			ok = sv.AtLeast(bound) && !bound.AtLeast(sv)
+		case "<=":
+			ok = bound.AtLeast(sv)
+		case "<":
+			ok = sv.LessThan(bound)
+		case "=":
+			ok = sv.AtLeast(bound) && bound.AtLeast(sv)
+		}
+
+		if !ok {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInRange(t *testing.T) {
+	tests := []struct {
+		v       string
+		r       string
+		want    bool
+		wantErr bool
+	}{
+		{"v3.7.2", ">=3.7 <3.8", true, false},
+		{"v3.8.0", ">=3.7 <3.8", false, false},
+		{"v3.6.3", ">=3.7 <3.8", false, false},
+		{"v3.7.0", ">3.7", false, false},
+		{"v3.7.1", ">3.7", true, false},
+		{"v3.7.0", "<=3.7", true, false},
+		{"v3.7.1", "<=3.7", false, false},
+		{"v2.16.12", "<3", true, false},
+		{"v3.0.0", "<3", false, false},
+		{"v3.4.0", "=3.4.0", true, false},
+		{"v3.4.1", "=3.4.0", false, false},
+		{"v3.4.0-rc.1", ">=3.4", true, false},
+		{"v3.4.0-rc.1", "<3.4", false, false},
+		{"v3.4.0", "", false, true},
+		{"v3.4.0", "3.4", false, true},
+		{"v3.4.0", ">=three", false, true},
+		{"latest", ">=3", false, true},
+	}
+
+	for _, tt := range tests {
+		got, err := inRange(tt.v, tt.r)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("inRange(%q, %q) error = %v, wantErr %t", tt.v, tt.r, err, tt.wantErr)
+			continue
+		}
+
+		if got != tt.want {
+			t.Errorf("inRange(%q, %q) = %t, want %t", tt.v, tt.r, got, tt.want)
+		}
+	}
+}
+
+func TestVersionEnv(t *testing.T) {
+	useConfig(t, `env:
+- versions: "<3"
+  vars:
+    TILLER_NAMESPACE: tiller
+    HELM_TLS_ENABLE: "true"
+- versions: ">=3.7 <3.8"
+  vars:
+    HELM_EXPERIMENTAL_OCI: "1"
+- versions: ">=3"
+  vars:
+    HELM_EXPERIMENTAL_OCI: "0"
+    HELM_DRIVER: configmap
+`)
+	setenv(t, "HELM_DRIVER", "secret")
+	unsetenv(t, "TILLER_NAMESPACE")
+	unsetenv(t, "HELM_TLS_ENABLE")
+	unsetenv(t, "HELM_EXPERIMENTAL_OCI")
+
+	tests := []struct {
+		v    string
+		want []string
+	}{
+		{"v2.16.12", []string{"HELM_TLS_ENABLE=true", "TILLER_NAMESPACE=tiller"}},
+		{"v3.7.2", []string{"HELM_EXPERIMENTAL_OCI=0"}},
+		{"v3.4.0", []string{"HELM_EXPERIMENTAL_OCI=0"}},
+	}
+
+	for _, tt := range tests {
+		got, err := versionEnv(tt.v)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("versionEnv(%q) = %q, want %q", tt.v, got, tt.want)
+		}
+	}
+
+	settings.Env = []envRule{{Versions: ">=3.x"}}
+	if _, err := versionEnv("v3.4.0"); err == nil {
+		t.Error("versionEnv() with an invalid rule succeeded")
+	}
+}